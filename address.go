@@ -0,0 +1,91 @@
+package cree
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+/*
+ * address for server and client
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - address like unix:///tmp/cree.sock, tcp://127.0.0.1:5300
+ * - websocket address like ws://127.0.0.1:5301/cree, wss://...
+ */
+
+//inter macro define
+const (
+	addressSeparator = "://"
+	networkUnix      = "unix"
+	schemeWs         = "ws"
+	schemeWss        = "wss"
+)
+
+//address info
+type addressInfo struct {
+	network   string //network for listen or dial
+	address   string //host:port or unix path
+	path      string //websocket path
+	webSocket bool
+	secure    bool //wss
+}
+
+//parse address into network and address
+//if address is empty, use tcp host and port
+func parseAddress(
+	address string,
+	network string,
+	host string,
+	port int) (*addressInfo, error) {
+	//tcp host and port
+	if address == "" {
+		info := &addressInfo{
+			network: network,
+			address: net.JoinHostPort(host, strconv.Itoa(port)),
+		}
+		return info, nil
+	}
+
+	//scheme address
+	idx := strings.Index(address, addressSeparator)
+	if idx <= 0 {
+		return nil, errors.New("invalid address, like unix:///tmp/cree.sock")
+	}
+	info := &addressInfo{
+		network: address[:idx],
+		address: address[idx + len(addressSeparator):],
+	}
+	switch info.network {
+	case networkUnix, "tcp", "tcp4", "tcp6":
+	case schemeWs, schemeWss:
+		//split websocket path
+		info.webSocket = true
+		info.secure = info.network == schemeWss
+		info.network = "tcp"
+		info.path = "/"
+		if i := strings.Index(info.address, "/"); i >= 0 {
+			info.path = info.address[i:]
+			info.address = info.address[:i]
+		}
+	default:
+		return nil, errors.New("unsupported network " + info.network)
+	}
+	if info.address == "" {
+		return nil, errors.New("invalid address, path or host is empty")
+	}
+	return info, nil
+}
+
+//listen on network address
+func listen(network, address string) (net.Listener, error) {
+	//remove stale unix socket file
+	if network == networkUnix {
+		if fi, err := os.Stat(address); err == nil && fi.Mode() & os.ModeSocket != 0 {
+			os.Remove(address)
+		}
+	}
+	return net.Listen(network, address)
+}
@@ -1,9 +1,13 @@
 package cree
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"sync"
@@ -24,66 +28,177 @@ import (
 type ClientConf struct {
 	Host         string
 	Port         int
+	Address      string //like unix:///tmp/cree.sock or ws://127.0.0.1:5301/cree, used instead of host and port
 	ReadBuffSize int
 	ConnTimeOut  time.Duration
 	WriteTimeOut int //xx seconds
+	TLS          *define.TLSConf //enable tls if not nil
+	Packet       string //packet codec name, same as server
+	Checksum     bool   //crc32c in header of default codec, same as server
+	Codec        string //data codec of typed call, same as server, default define.CodecJson
+	FragmentBudget int  //max reassembled message size, default define.DefaultFragmentBudget
+	Compress     []string //compress codecs by preference, negotiated on connect
+	CompressThreshold int //data less than it not compressed, default define.DefaultCompressThreshold
+	Secure       bool     //negotiate secure channel on connect, connect failed if refused
+	SecureServerKey []byte //pinned static public key of server, optional
+	Hello        bool     //say hello with protocol version and capabilities, connect failed if rejected
+	App          string   //app name of client, sent by hello
+	AppVersion   string   //app version of client, sent by hello
 }
 
 type clientPacket struct {
+	kind      uint32
 	messageId uint32
 	data      []byte
 }
 
+//inter error define
+var (
+	ErrClientClosed     = errors.New("cree.client, client closed")
+	ErrCallTimeOut      = errors.New("cree.client, call time out")
+	ErrCallDisconnected = errors.New("cree.client, connect closed before response")
+	ErrCallUnsupported  = errors.New("cree.client, call not supported by packet without kind")
+	ErrSecureRefused    = errors.New("cree.client, secure channel refused by server")
+	ErrSecureKeyPinned  = errors.New("cree.client, static key of server not matched")
+)
+
 //face info
 type Client struct {
 	conf       *ClientConf
 	conn       net.Conn
 	connected  bool
 	cbForRead  func(msg iface.IMessage) error
-	packetChan chan clientPacket
-	closeChan  chan bool
-	pack       iface.IPacket
+	cbForStream func(stream iface.IStream)
+	requestMap map[uint32]func(msg iface.IMessage) ([]byte, error) //msgId -> handler of server request
+	packetChan chan clientPacket //send queue of current connect, not kept after closed
+	closeChan  chan struct{} //closed when connect closed
+	doneChan   chan struct{} //closed when read process quit
+	readErr    error
+	pack       iface.IPacket //current codec, may be compress negotiated
+	basePack   iface.IPacket //codec without compress
+	codec      iface.ICodec  //data codec of typed call
+	helloKey   *ecdh.PrivateKey //key of secure channel, sent by hello
+	capabilities *define.Capabilities //negotiated by hello, nil if not replied
+	handler    *face.Handler //response waiters
+	mux        *face.Mux     //streams of current connect
+	connSeq    int64         //seq of current connect, key of waiters
 	writeMu    sync.Mutex
+	sync.RWMutex
 }
 
-//construct
+//construct without side effects
+//call ConnServer or Connect to run
 func NewClient(
 		conf *ClientConf,
 	) *Client {
+	//check
+	if conf == nil {
+		conf = &ClientConf{}
+	}
+
 	//self init
 	this := &Client{
 		conf: conf,
-		pack: face.NewPacket(),
-		packetChan: make(chan clientPacket, define.DefaultChanSize),
-		closeChan: make(chan bool, 1),
+		basePack: newPacket(conf.Packet, 0, conf.Checksum),
+		codec: newCodec(conf.Codec),
+		handler: face.NewHandler(),
+		requestMap: map[uint32]func(msg iface.IMessage) ([]byte, error){},
 	}
 
 	//inter init
+	this.pack = this.basePack
 	this.interInit()
 	return this
 }
 
 //close connect
 func (c *Client) Close() {
-	if c.conn != nil {
-		c.conn.Close()
-		close(c.closeChan)
-	}
-	c.connected = false
+	c.RLock()
+	conn := c.conn
+	c.RUnlock()
+	c.closeConn(conn)
 }
 
-//set cb for read data
+//set cb for read data, should be called before connect
 func (c *Client) SetCBForRead(cb func(msg iface.IMessage) error) bool {
 	if cb == nil {
 		return false
 	}
+	c.Lock()
+	defer c.Unlock()
 	c.cbForRead = cb
 	return true
 }
 
+//set cb for stream opened by server, called in background
+//stream opened by server reset if not set
+func (c *Client) SetCBForStream(cb func(stream iface.IStream)) bool {
+	if cb == nil {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.cbForStream = cb
+	return true
+}
+
+//open stream to server, closed when connect closed
+func (c *Client) OpenStream() (iface.IStream, error) {
+	c.RLock()
+	connected := c.connected
+	mux := c.mux
+	c.RUnlock()
+	if !connected {
+		return nil, errors.New("connect is nil")
+	}
+	stream, err := mux.Open()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+//add handler of server request, returned data sent as reply
+//returned error sent as error frame, *face.FrameError kept as it is
+func (c *Client) AddRequestHandler(
+	messageId uint32,
+	handler func(msg iface.IMessage) ([]byte, error)) error {
+	//check
+	if handler == nil {
+		return errors.New("invalid parameter")
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.requestMap[messageId] = handler
+	return nil
+}
+
 //set max pack size
 func (c *Client) SetMaxPackSize(size int) {
-	c.pack.SetMaxPackSize(size)
+	c.basePack.SetMaxPackSize(size)
+}
+
+//get data codec of typed call
+func (c *Client) GetCodec() iface.ICodec {
+	return c.codec
+}
+
+//set byte order of default codec, same as server
+func (c *Client) SetLittleEndian(littleEndian bool) {
+	c.basePack.SetLittleEndian(littleEndian)
+}
+
+//get capabilities negotiated by hello
+//nil if hello not sent, or server not support protocol version
+func (c *Client) GetCapabilities() *define.Capabilities {
+	c.RLock()
+	defer c.RUnlock()
+	return c.capabilities
+}
+
+//get count of corrupt frames, checksum enabled
+func (c *Client) GetCorruptCount() int64 {
+	return getCorruptCount(c.basePack)
 }
 
 //set read buff size
@@ -99,125 +214,530 @@ func (c *Client) SetReadBuffSize(size int) bool {
 func (c *Client) SendPacket(
 	messageId uint32,
 	data []byte) error {
-	var (
-		m any = nil
-	)
+	return c.SendFrame(define.KindPush, messageId, data)
+}
+
+//send packet data with kind, like request or pong
+//kind made by frame type and seq, see face.MakeKind
+func (c *Client) SendFrame(
+	kind uint32,
+	messageId uint32,
+	data []byte) error {
 	//check
 	if data == nil {
 		return errors.New("invalid parameter")
 	}
-	if !c.connected || c.conn == nil {
+	c.RLock()
+	connected := c.connected
+	packetChan := c.packetChan
+	closeChan := c.closeChan
+	c.RUnlock()
+	if !connected {
 		return errors.New("connect is nil")
 	}
 
-	//try catch panic
-	defer func() {
-		if err := recover(); err != m {
-			log.Printf("cree.client.SendPacket err:%v\n", err)
-		}
-	}()
-
 	//send to chan
 	cp := clientPacket{
+		kind: kind,
 		messageId: messageId,
 		data: data,
 	}
-	c.packetChan <- cp
-	return nil
+	return c.pushPacket(context.Background(), packetChan, closeChan, cp)
+}
+
+//send request and wait response of same seq
+//return ErrCallTimeOut, ErrCallDisconnected or *face.FrameError of remote
+//codec without kind, like varint, return ErrCallUnsupported
+func (c *Client) Call(
+	ctx context.Context,
+	messageId uint32,
+	data []byte) (iface.IMessage, error) {
+	return c.call(ctx, define.KindRequest, messageId, data)
 }
 
 //connect server
 func (c *Client) ConnServer(timeOuts ...time.Duration) error {
 	var (
 		timeOut time.Duration
-		conn net.Conn
-		err error
 	)
+	if timeOuts != nil && len(timeOuts) > 0 {
+		timeOut = timeOuts[0]
+	}
+	return c.connect(context.Background(), timeOut)
+}
+
+//connect server with ctx, read and send process started
+func (c *Client) Connect(ctx context.Context) error {
+	return c.connect(ctx, 0)
+}
+
+//serve until ctx done or connect closed
+//connect closed before return, if closed by Close return ErrClientClosed
+func (c *Client) Serve(ctx context.Context) error {
+	//get read process
+	c.RLock()
+	doneChan := c.doneChan
+	c.RUnlock()
+	if doneChan == nil {
+		return errors.New("cree.client, client not connected")
+	}
+
+	//wait
+	select {
+	case <- ctx.Done():
+		c.Close()
+		<- doneChan
+		return ctx.Err()
+	case <- doneChan:
+		c.RLock()
+		defer c.RUnlock()
+		return c.readErr
+	}
+}
+
+////////////////
+//private func
+////////////////
+
+//connect server and say hello, time out of conf used if zero
+func (c *Client) connect(ctx context.Context, timeOut time.Duration) error {
 	//check
-	if c.conf.Host == "" || c.conf.Port <= 0 {
+	if c.conf.Address == "" &&
+		(c.conf.Host == "" || c.conf.Port <= 0) {
 		return errors.New("host or port is invalid")
 	}
-	if c.connected {
+	if _, err := face.CreatePacket(c.conf.Packet); err != nil {
+		return err
+	}
+	if _, err := face.CreateCodec(c.conf.Codec); err != nil {
+		return err
+	}
+	if c.conf.Checksum && !canChecksum(c.conf.Packet) {
+		return fmt.Errorf("cree.client, packet %v not support checksum", c.conf.Packet)
+	}
+	if timeOut <= 0 {
+		timeOut = c.conf.ConnTimeOut
+	}
+
+	//open connect
+	opened, err := c.openConn(ctx, timeOut)
+	if err != nil || !opened {
+		return err
+	}
+	return c.hello(ctx, timeOut)
+}
+
+//say hello to server, negotiate protocol, compress codec and secure keys
+//compress not used if server refused, but secure channel required
+//connect closed if rejected by server, error frame returned
+func (c *Client) hello(ctx context.Context, timeOut time.Duration) error {
+	var (
+		frameErr *face.FrameError
+	)
+	//check
+	if !c.conf.Hello && len(c.conf.Compress) <= 0 && !c.conf.Secure {
 		return nil
 	}
+	if !face.HasKind(c.basePack) {
+		if !c.conf.Secure {
+			return nil
+		}
+		c.Close()
+		return fmt.Errorf("cree.client, packet %v not support secure channel", c.conf.Packet)
+	}
 
-	if timeOuts != nil && len(timeOuts) > 0 {
-		timeOut = timeOuts[0]
-	}else{
-		timeOut = c.conf.ConnTimeOut
+	//offer capabilities of client
+	info := &face.HelloInfo{
+		Magic: define.HelloMagic,
+		Version: define.ProtocolVersion,
+		App: c.conf.App,
+		AppVersion: c.conf.AppVersion,
+		Caps: define.CapFragment | define.CapStream,
+		MaxPackSize: c.basePack.GetMaxPackSize(),
+		Endian: face.PacketEndian(c.basePack),
+		Compress: c.conf.Compress,
 	}
-	if timeOut <= 0 {
-		timeOut = define.DefaultTcpDialTimeOut * time.Second
+	if len(c.conf.Compress) > 0 {
+		info.Caps |= define.CapCompress
+	}
+	if c.conf.Checksum {
+		info.Caps |= define.CapChecksum
+	}
+
+	//gen key of secure channel
+	if c.conf.Secure {
+		info.Caps |= define.CapSecure
+		key, err := face.NewSecureKey()
+		if err != nil {
+			c.Close()
+			return err
+		}
+		c.Lock()
+		c.helloKey = key
+		c.Unlock()
+		info.Key = key.PublicKey().Bytes()
+	}
+
+	//send hello and wait reply
+	data, _ := json.Marshal(info)
+	ctx, cancel := context.WithTimeout(ctx, timeOut)
+	defer cancel()
+	_, err := c.call(ctx, define.KindHello, 0, data)
+	if err == ErrCallDisconnected {
+		//read process quit, like secure channel refused
+		c.RLock()
+		if c.readErr != nil {
+			err = c.readErr
+		}
+		c.RUnlock()
+	}
+	if err != nil && (c.conf.Secure || !errors.As(err, &frameErr) ||
+		frameErr.Code == define.FrameErrRejected) {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+//enable compress codec and secure channel selected by server
+//called by read process, before following frames framed
+func (c *Client) onHello(
+	msg iface.IMessage,
+	reader *face.PacketReader,
+	mux *face.Mux) error {
+	var (
+		packet = c.basePack
+		err error
+	)
+	hello := &face.HelloInfo{}
+	if subErr := json.Unmarshal(msg.GetData(), hello); subErr != nil {
+		hello = &face.HelloInfo{}
+	}
+
+	//keep capabilities replied by server
+	if hello.Magic == define.HelloMagic {
+		capabilities := &define.Capabilities{
+			Version: hello.Version,
+			App: c.conf.App,
+			AppVersion: c.conf.AppVersion,
+			Caps: hello.Caps,
+			MaxPackSize: hello.MaxPackSize,
+		}
+		if len(hello.Compress) > 0 {
+			capabilities.Compress = hello.Compress[0]
+		}
+		c.Lock()
+		c.capabilities = capabilities
+		c.Unlock()
+		mux.SetCaps(hello.Caps)
+	}
+
+	//derive secure keys by server keys
+	if c.conf.Secure {
+		if len(hello.Key) <= 0 {
+			return ErrSecureRefused
+		}
+		if len(c.conf.SecureServerKey) > 0 &&
+			!bytes.Equal(c.conf.SecureServerKey, hello.StaticKey) {
+			return ErrSecureKeyPinned
+		}
+		c.RLock()
+		helloKey := c.helloKey
+		c.RUnlock()
+		state, subErr := face.DialSecure(helloKey, hello.Key, hello.StaticKey)
+		if subErr != nil {
+			return subErr
+		}
+		if packet, err = face.NewSecurePacket(packet, state); err != nil {
+			return err
+		}
+	}
+
+	//enable compress, data compressed before sealed
+	if len(hello.Compress) > 0 {
+		compressPacket, subErr := face.NewCompressPacket(packet, hello.Compress[0], c.conf.CompressThreshold)
+		if subErr != nil {
+			log.Printf("cree.client, enable compress failed, err:%v\n", subErr)
+		}else{
+			packet = compressPacket
+		}
+	}
+	if packet == c.basePack {
+		return nil
+	}
+	c.Lock()
+	c.pack = packet
+	c.Unlock()
+	reader.SetPacket(packet)
+	return nil
+}
+
+//open connect, return false if already connected
+func (c *Client) openConn(ctx context.Context, timeOut time.Duration) (bool, error) {
+	var (
+		conn net.Conn
+		err error
+	)
+	c.Lock()
+	defer c.Unlock()
+	if c.connected {
+		return false, nil
 	}
-	//log.Printf("confTimeout:%v, timeout:%v\n", c.conf.ConnTimeOut, timeOut)
 
 	//format address
-	address := fmt.Sprintf("%s:%d", c.conf.Host, c.conf.Port)
+	info, err := parseAddress(c.conf.Address,
+		define.DefaultTcpVersion, c.conf.Host, c.conf.Port)
+	if err != nil {
+		return false, err
+	}
 
 	//try connect server
-	if timeOut > 0 {
-		conn, err = net.DialTimeout("tcp", address, timeOut)
+	dialer := &net.Dialer{Timeout: timeOut}
+	if c.conf.TLS != nil || info.secure {
+		tlsConf := &tls.Config{}
+		if c.conf.TLS != nil {
+			tlsConf, err = buildTLSConfig(c.conf.TLS, false)
+			if err != nil {
+				return false, err
+			}
+		}
+		if tlsConf.ServerName == "" {
+			tlsConf.ServerName, _, _ = net.SplitHostPort(info.address)
+		}
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: tlsConf}
+		conn, err = tlsDialer.DialContext(ctx, info.network, info.address)
 	}else{
-		conn, err = net.Dial("tcp", address)
+		conn, err = dialer.DialContext(ctx, info.network, info.address)
 	}
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	//websocket handshake
+	if info.webSocket {
+		deadline := time.Now().Add(timeOut)
+		if v, ok := ctx.Deadline(); ok && v.Before(deadline) {
+			deadline = v
+		}
+		wsConn := face.NewWsConn(conn, false, info.address, info.path)
+		wsConn.SetDeadline(deadline)
+		if err = wsConn.Handshake(); err != nil {
+			conn.Close()
+			return false, err
+		}
+		wsConn.SetDeadline(time.Time{})
+		conn = wsConn
 	}
 
 	//sync conn
 	c.conn = conn
+	c.pack = c.basePack
+	c.capabilities = nil
+	c.connSeq++
 	c.connected = true
+	c.readErr = nil
+	c.packetChan = make(chan clientPacket, define.DefaultChanSize)
+	c.closeChan = make(chan struct{})
+	c.doneChan = make(chan struct{})
+	c.mux = c.newMux(conn, c.packetChan, c.closeChan)
+
+	//spawn read and send process
+	go c.runReadProcess(conn, c.cbForRead, c.doneChan, c.connSeq, c.mux)
+	go c.runSendProcess(conn, c.packetChan, c.closeChan)
+	return true, nil
+}
 
-	//spawn read process
-	//go c.runReadProcess()
-	return nil
+//init streams of connect, frames sent before connect closed
+func (c *Client) newMux(
+	conn net.Conn,
+	packetChan chan clientPacket,
+	closeChan chan struct{}) *face.Mux {
+	send := func(kind, messageId uint32, data []byte) error {
+		cp := clientPacket{
+			kind: kind,
+			messageId: messageId,
+			data: data,
+		}
+		return c.pushPacket(context.Background(), packetChan, closeChan, cp)
+	}
+	mux := face.NewMux(send, c.basePack, true)
+	mux.SetAddr(conn.LocalAddr(), conn.RemoteAddr())
+	mux.SetCBForAccept(func(stream iface.IStream) {
+		c.RLock()
+		cbForStream := c.cbForStream
+		c.RUnlock()
+		if cbForStream == nil {
+			stream.Reset()
+			return
+		}
+		cbForStream(stream)
+	})
+	return mux
 }
 
-////////////////
-//private func
-////////////////
+//call handler of server request and send reply
+func (c *Client) handleRequest(msg iface.IMessage) {
+	var (
+		kind uint32
+		data []byte
+		err error
+		m any = nil
+	)
+	//defer
+	defer func() {
+		if subErr := recover(); subErr != m {
+			log.Println("client.handleRequest panic, err:", subErr)
+		}
+	}()
 
-//packet one data
-func (c *Client) packetData(
-	messageId uint32,
-	data []byte) []byte {
-	message := face.NewMessage()
-	message.Id = messageId
-	message.SetData(data)
-	byteData, _ := c.pack.Pack(message)
-	return byteData
+	//call handler
+	c.RLock()
+	handler, ok := c.requestMap[msg.GetId()]
+	c.RUnlock()
+	seq := face.KindSeq(msg.GetKind())
+	if ok {
+		data, err = handler(msg)
+	}else{
+		tips := fmt.Sprintf("no handler for message id:%d", msg.GetId())
+		err = face.NewFrameError(define.FrameErrNoHandler, tips)
+	}
+
+	//send reply or error frame
+	if err != nil {
+		kind = face.MakeKind(define.KindError, seq)
+		data = face.ToFrameError(err, define.FrameErrInternal).Encode()
+	}else{
+		kind = face.MakeKind(define.KindResponse, seq)
+		if data == nil {
+			data = []byte{}
+		}
+	}
+	c.SendFrame(kind, msg.GetId(), data)
 }
 
-//read one message
-func (c *Client) readMessage() (iface.IMessage, error) {
-	//init header
-	header := make([]byte, c.pack.GetHeadLen())
+//send frame with seq and wait response of same seq
+func (c *Client) call(
+	ctx context.Context,
+	frameType uint32,
+	messageId uint32,
+	data []byte) (iface.IMessage, error) {
+	//check
+	if data == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	if !face.CarryKind(c.basePack) {
+		return nil, ErrCallUnsupported
+	}
+	c.RLock()
+	connected := c.connected
+	packetChan := c.packetChan
+	closeChan := c.closeChan
+	connSeq := c.connSeq
+	c.RUnlock()
+	if !connected {
+		return nil, ErrCallDisconnected
+	}
 
-	//read message header
-	_, err := io.ReadFull(c.conn, header)
+	//add waiter before send
+	seq := c.handler.NextSeq()
+	waiter, err := c.handler.AddWaiter(connSeq, seq)
 	if err != nil {
 		return nil, err
 	}
+	defer c.handler.RemoveWaiter(connSeq, seq)
 
-	//unpack header
-	message, subErr := c.pack.UnPack(header)
-	if subErr != nil || message == nil {
-		return nil, subErr
+	//send request
+	cp := clientPacket{
+		kind: face.MakeKind(frameType, seq),
+		messageId: messageId,
+		data: data,
+	}
+	if err = c.pushPacket(ctx, packetChan, closeChan, cp); err != nil {
+		return nil, callErr(err)
 	}
 
-	//read real data and storage into message object
-	if message.GetLen() > 0 {
-		data := make([]byte, message.GetLen())
-		_, err = c.conn.Read(data)
-		if err != nil {
-			return nil, err
+	//wait response
+	select {
+	case message, ok := <- waiter:
+		if !ok {
+			return nil, ErrCallDisconnected
+		}
+		if face.KindType(message.GetKind()) == define.KindError {
+			return nil, face.DecodeFrameError(message)
 		}
-		message.SetData(data)
+		return message, nil
+	case <- closeChan:
+		return nil, ErrCallDisconnected
+	case <- ctx.Done():
+		return nil, callErr(ctx.Err())
 	}
-	return message, nil
 }
 
-func (c *Client) sendRealPacket(pack *clientPacket) error {
+//push packet into send chan of connect
+//packet not sent by later connect, refused if connect closed
+func (c *Client) pushPacket(
+	ctx context.Context,
+	packetChan chan clientPacket,
+	closeChan chan struct{},
+	cp clientPacket) error {
+	//check data split into fragments by peer support
+	c.RLock()
+	pack, caps := c.pack, c.getCaps()
+	c.RUnlock()
+	if err := face.CheckFragment(pack, caps, len(cp.data)); err != nil {
+		return err
+	}
+	select {
+	case <- closeChan:
+		return ErrClientClosed
+	default:
+	}
+	select {
+	case packetChan <- cp:
+		return nil
+	case <- closeChan:
+		return ErrClientClosed
+	case <- ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//close connect if it's current one
+func (c *Client) closeConn(conn net.Conn) {
+	c.Lock()
+	if conn == nil || c.conn != conn || !c.connected {
+		c.Unlock()
+		return
+	}
+	c.connected = false
+	c.packetChan = nil
+	close(c.closeChan)
+	c.Unlock()
+	conn.Close()
+}
+
+//packet one data into dst buffer
+func (c *Client) packetData(
+	dst []byte,
+	kind uint32,
+	messageId uint32,
+	data []byte) ([]byte, error) {
+	c.RLock()
+	pack, caps := c.pack, c.getCaps()
+	c.RUnlock()
+	return face.AppendFrames(pack, dst, kind, messageId, data, caps)
+}
+
+//get capabilities of server, 0 if hello not done
+//should be called with locker
+func (c *Client) getCaps() uint32 {
+	if c.capabilities == nil {
+		return 0
+	}
+	return c.capabilities.Caps
+}
+
+func (c *Client) sendRealPacket(conn net.Conn, pack *clientPacket) error {
 	var (
 		m any = nil
 	)
@@ -225,9 +745,6 @@ func (c *Client) sendRealPacket(pack *clientPacket) error {
 	if pack == nil {
 		return errors.New("invalid parameter")
 	}
-	if !c.connected || c.conn == nil {
-		return errors.New("connect is nil")
-	}
 
 	//try catch panic
 	defer func() {
@@ -236,8 +753,16 @@ func (c *Client) sendRealPacket(pack *clientPacket) error {
 		}
 	}()
 
-	//packet data
-	packet := c.packetData(pack.messageId, pack.data)
+	//packet data with pooled buffer
+	buff := face.AcquireBuffer()
+	packet, err := c.packetData(*buff, pack.kind, pack.messageId, pack.data)
+	defer func() {
+		*buff = packet
+		face.ReleaseBuffer(buff)
+	}()
+	if err != nil {
+		return err
+	}
 
 	//set write timeout
 	writeTimeOut := time.Duration(c.conf.WriteTimeOut)  * time.Second
@@ -246,20 +771,25 @@ func (c *Client) sendRealPacket(pack *clientPacket) error {
 	defer c.writeMu.Unlock()
 
 	//send direct
-	err := c.conn.SetWriteDeadline(time.Now().Add(writeTimeOut))
+	err = conn.SetWriteDeadline(time.Now().Add(writeTimeOut))
 	if err != nil {
 		return err
 	}
-	_, err = c.conn.Write(packet)
-	c.conn.SetWriteDeadline(time.Time{}) // clear deadline
+	_, err = conn.Write(packet)
+	conn.SetWriteDeadline(time.Time{}) // clear deadline
 	if err != nil {
 		log.Printf("cree.client.sendRealPacket failed err:%v\n", err)
 	}
 	return err
 }
 
-//read process
-func (c *Client) runReadProcess() {
+//read process of one connect, quit when read failed
+func (c *Client) runReadProcess(
+	conn net.Conn,
+	cbForRead func(msg iface.IMessage) error,
+	doneChan chan struct{},
+	connSeq int64,
+	mux *face.Mux) {
 	var (
 		msg iface.IMessage
 		err error
@@ -269,40 +799,100 @@ func (c *Client) runReadProcess() {
 	//defer
 	defer func() {
 		if subErr := recover(); subErr != m {
-			log.Println("client.runReadProcess panic, err:", err)
+			log.Println("client.runReadProcess panic, err:", subErr)
 		}
-		c.Close()
+
+		//closed by Close or broken
+		c.Lock()
+		if c.conn == conn {
+			if !c.connected {
+				err = ErrClientClosed
+			}
+			c.readErr = err
+		}
+		c.Unlock()
+		c.closeConn(conn)
+		c.handler.CloseWaiters(connSeq)
+		mux.Close(face.ErrConnClosed)
+		close(doneChan)
 	}()
 
 	//loop
+	reader := face.NewPacketReader(c.basePack, c.conf.ReadBuffSize)
+	assembler := face.NewAssembler(c.conf.FragmentBudget)
 	for {
-		//check connect
-		if c.conn == nil {
+		//try read tcp data
+		msg, err = reader.ReadMessage(conn)
+		if err != nil {
+			return
+		}
+
+		//reassemble fragments of large message
+		whole, subErr := assembler.Add(msg, nil)
+		if subErr != nil {
+			//call of response over budget failed
+			if whole != nil && face.KindType(whole.GetKind()) == define.KindResponse {
+				errMsg := face.NewMessage()
+				errMsg.SetKind(face.MakeKind(define.KindError, face.KindSeq(whole.GetKind())))
+				errMsg.SetId(whole.GetId())
+				errMsg.SetData(face.ToFrameError(subErr, define.FrameErrTooLarge).Encode())
+				c.handler.NotifyWaiter(connSeq, errMsg)
+			}
+			log.Printf("cree.client, reassemble message failed, err:%v\n", subErr)
+			continue
+		}
+		if whole == nil {
+			continue
+		}
+		msg = whole
+
+		//answer ping of server
+		if face.KindType(msg.GetKind()) == define.KindPing {
+			kind := face.MakeKind(define.KindPong, face.KindSeq(msg.GetKind()))
+			data := msg.GetData()
+			if data == nil {
+				data = []byte{}
+			}
+			c.SendFrame(kind, msg.GetId(), data)
 			continue
 		}
 
-		//try read tcp data
-		msg, err = c.readMessage()
-		if err != nil {
-			if ne, ok := err.(net.Error); ok && ne.Timeout() {
-				break
+		//frame of stream handled by mux
+		if face.IsStreamFrame(msg.GetKind()) {
+			mux.HandleFrame(msg)
+			continue
+		}
+
+		//route response to waiter of call
+		if !face.IsDataFrame(msg.GetKind()) {
+			if face.KindType(msg.GetKind()) == define.KindHello {
+				if err = c.onHello(msg, reader, mux); err != nil {
+					return
+				}
 			}
+			c.handler.NotifyWaiter(connSeq, msg)
+			continue
+		}
+
+		//reply server request in background
+		if face.KindType(msg.GetKind()) == define.KindRequest {
+			go c.handleRequest(msg)
 			continue
 		}
 
 		//call cb
-		if c.cbForRead != nil {
-			//unpack data
-			c.cbForRead(msg)
+		if cbForRead != nil {
+			cbForRead(msg)
 		}
 	}
 }
 
-//send process
-func (c *Client) runSendProcess() {
+//send process of one connect
+func (c *Client) runSendProcess(
+	conn net.Conn,
+	packetChan chan clientPacket,
+	closeChan chan struct{}) {
 	var (
-		cp clientPacket
-		ok bool
 		m any = nil
 	)
 
@@ -311,42 +901,20 @@ func (c *Client) runSendProcess() {
 		if err := recover(); err != m {
 			log.Printf("client.runSendProcess panic, err:%v\n", err)
 		}
-		close(c.packetChan)
 	}()
 
 	//loop
 	for {
 		select {
-		case cp, ok = <- c.packetChan:
-			if ok && &cp != nil {
-				//send real packet
-				c.sendRealPacket(&cp)
-			}
-		case <- c.closeChan:
+		case cp := <- packetChan:
+			//send real packet
+			c.sendRealPacket(conn, &cp)
+		case <- closeChan:
 			return
 		}
 	}
 }
 
-//cb for consumer
-func (c *Client) cbForConsumer(
-	data interface{}) error {
-	//check
-	if data == nil {
-		return errors.New("invalid parameter")
-	}
-	packBytes, ok := data.([]byte)
-	if !ok || packBytes == nil {
-		return errors.New("data should be `[]byte` type")
-	}
-	if c.conn == nil {
-		return errors.New("client connect is nil")
-	}
-	//write to connect
-	_, err := c.conn.Write(packBytes)
-	return err
-}
-
 //inter init
 func (c *Client) interInit() {
 	//check config
@@ -359,10 +927,16 @@ func (c *Client) interInit() {
 	if c.conf.WriteTimeOut <= 0 {
 		c.conf.WriteTimeOut = define.DefaultTcpWriteTimeOut
 	}
+}
 
-	//start read process
-	go c.runReadProcess()
-
-	//start send process
-	go c.runSendProcess()
-}
\ No newline at end of file
+//convert error of call to typed error
+func callErr(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrCallTimeOut
+	case err == ErrClientClosed:
+		return ErrCallDisconnected
+	default:
+		return err
+	}
+}
@@ -7,7 +7,16 @@ const (
 	DefaultTcpReadBuffSize = 1024
 
 	DefaultTcpDialTimeOut  = 5  //xx seconds
+	DefaultHandshakeTimeOut = 5 //xx seconds
+	AcceptRetryMinDelay    = 5    //xx milliseconds
+	AcceptRetryMaxDelay    = 1000 //xx milliseconds
 	DefaultTcpWriteTimeOut = 2   //xx seconds
+	DefaultWriteBlockTimeOut = 2 //xx seconds
+	DefaultWriteFlushBytes   = 65536
+
+	//for object pool
+	DefaultPoolBufferSize    = 4096
+	DefaultPoolBufferMaxSize = 65536
 	DefaultManagerTicker   = 60  //xx seconds
 	DefaultUnActiveSeconds = 60  //xx seconds
 	DefaultGCRate          = 300 //xx seconds
@@ -15,6 +24,11 @@ const (
 	DefaultChanSize		   = 1024
 	DefaultSmallChanSize   = 256
 
+	//for epoll engine
+	DefaultEventLoopReadSize  = 65536 //read buffer size of one event loop
+	DefaultEventLoopWaitMs    = 100   //xx milliseconds
+	DefaultEventLoopQueueSize = 64    //messages of one connect wait dispatch, read paused if full
+
 	//for bucket
 	DefaultBuckets        = 5
 	DefaultBucketReadRate = 0.1 //xx seconds
@@ -29,4 +43,157 @@ const (
 	HandlerQueueChanSize    = 1024
 	PacketMaxSize           = 2048 //2KB
 	FullPercent				= 100
-)
\ No newline at end of file
+)
+
+//built-in packet codec names
+const (
+	PacketDefault   = "default"   //dataLen(4byte) + messageKind(4byte) + messageId(4byte)
+	PacketCompact   = "compact"   //dataLen(2byte) + messageId(2byte)
+	PacketVarint    = "varint"    //uvarint dataLen
+	PacketLength    = "length"    //dataLen(4byte)
+	PacketDelimiter = "delimiter" //data end with \n
+)
+
+//built-in data codec names of typed router
+const (
+	CodecJson = "json"
+	CodecGob  = "gob"
+	CodecRaw  = "raw" //[]byte, string or binary marshaler
+)
+
+//frame type of message kind
+//kind = seq << KindSeqShift | frame type
+const (
+	KindPush     = iota //one way message, same as legacy message
+	KindRequest         //response with same seq expected
+	KindResponse
+	KindError
+	KindPing
+	KindPong
+	KindAck
+	KindClose
+	KindHello //connect time handshake, like compress and secure negotiation
+	KindFragment //part of large message, reassembled by receiver
+	KindStreamOpen   //open stream, message id as stream id
+	KindStreamData   //data of stream, limited by window of peer
+	KindStreamWindow //grant receive window, data = increment(4byte)
+	KindStreamClose  //write side of stream closed
+	KindStreamReset  //stream aborted, data = error frame
+)
+
+//kind layout
+const (
+	KindTypeMask     = 0x3F
+	KindFlagSecure   = 0x40 //data sealed by negotiated secure keys
+	KindFlagCompress = 0x80 //data compressed by negotiated codec
+	KindSeqShift     = 8
+	KindSeqMax       = 1 << 24 - 1
+)
+
+//built-in compress codec names
+const (
+	CompressFlate = "flate"
+	CompressGzip  = "gzip"
+	DefaultCompressThreshold = 256 //data less than it not compressed
+)
+
+//hello handshake
+const (
+	HelloMagic      = 0x43524545 //CREE
+	ProtocolVersion = 1
+	EndianLittle    = "little"
+	EndianBig       = "big"
+	HelloRejectTimeOut = 1000 //xx milliseconds, wait time for reject frame written
+)
+
+//capability bits of hello
+const (
+	CapCompress = 1 << iota
+	CapChecksum
+	CapSecure
+	CapFragment
+	CapStream
+)
+
+//stream multiplexing
+const (
+	StreamWindowSize = 256 << 10 //initial receive window of one stream, 256KB
+	StreamChunkSize  = 16 << 10  //max data of one stream frame, less if over max pack size
+	StreamMaxOpen    = 1024      //max opened streams of one connect
+)
+
+//fragment of large message
+const (
+	FragmentReserve       = 64      //reserved bytes of fragment frame, like seal overhead
+	DefaultFragmentBudget = 1 << 20 //max reassembled message size of one connect, 1MB
+	FragmentStreamWait    = 1000    //xx milliseconds, wait router read stream data over budget
+)
+
+//code of error frame
+const (
+	FrameErrInternal  = iota + 1 //handler failed
+	FrameErrNoHandler            //no router of message id
+	FrameErrBadFrame             //frame decode failed
+	FrameErrInsecure             //plain data frame refused, secure channel required
+	FrameErrTooLarge             //reassembled message over memory budget
+	FrameErrRejected             //hello refused, like incompatible protocol or by hook
+	FrameErrNoHello              //data frame refused, hello required
+	FrameErrBadData              //data decode failed by codec of typed router
+	FrameErrCanceled             //stream reset by application
+)
+
+//read mode for connect
+const (
+	ReadModeRoutine = iota //one reader goroutine per connect
+	ReadModeTicker         //legacy bucket ticker polling
+	ReadModeEvent          //read by server event loops
+)
+
+//overflow policy for connect write queue
+const (
+	OverflowBlock      = iota //block until queue has space or time out
+	OverflowDropOldest        //drop oldest queued data
+	OverflowDropNewest        //drop new data
+	OverflowDisconnect        //disconnect slow consumer
+)
+
+//io engine for server
+const (
+	EngineRoutine = "routine" //one reader goroutine per connect
+	EngineEpoll   = "epoll"   //linux epoll event loops
+)
+//listener name for server
+const (
+	ListenerDefault   = "default" //listener of server conf address
+	ListenerWebSocket = "ws"      //listener of server conf websocket address
+)
+
+//proxy protocol
+const (
+	ProxyV1MaxSize      = 107 //max header size of v1, include CRLF
+	ProxyV2HeaderSize   = 16  //fixed header size of v2
+	ProxyV2CmdLocal     = 0x0 //health check of proxy, keep origin address
+	ProxyV2CmdProxy     = 0x1
+	ProxyV2TLVAlpn      = 0x01
+	ProxyV2TLVAuthority = 0x02
+	ProxyV2TLVUniqueId  = 0x05
+	ProxyV2TLVSSL       = 0x20
+)
+
+//message data
+const (
+	ServerGoingAway = "server going away"
+)
+
+//hot restart
+const (
+	UpgradeEnvName        = "CREE_UPGRADE_FD" //env of handoff socket fd for child process
+	UpgradeTimeOut        = 10  //xx seconds, for receive listeners of child process
+	UpgradeBatchSize      = 128 //max fds of one handoff frame
+	UpgradeFrameHeadSize  = 8
+	UpgradeFrameListeners = 1 //parent -> child, listener fds
+	UpgradeFrameReady     = 2 //child -> parent, ready for connects
+	UpgradeFrameConnects  = 3 //parent -> child, connect fds
+	UpgradeFrameDone      = 4 //parent -> child, all connects sent
+	UpgradeFrameAck       = 5 //child -> parent, connects restored
+)
@@ -1,6 +1,52 @@
 package define
 
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"time"
+)
+
 type (
+	//tls config
+	TLSConf struct {
+		CertFile     string            //cert pem file
+		KeyFile      string            //key pem file
+		Certificates []tls.Certificate //loaded certs, used if no cert file
+		CAFile       string            //ca pem file for verify peer
+		CAPool       *x509.CertPool    //ca pool for verify peer, used if no ca file
+		ClientAuth   tls.ClientAuthType //server side, like tls.RequireAndVerifyClientCert
+		MinVersion   uint16            //like tls.VersionTLS12
+		ServerName   string            //client side, server name for verify
+		SkipVerify   bool              //client side, skip verify server cert
+	}
+
+	//proxy protocol v2 tlv
+	ProxyTLV struct {
+		Type  byte
+		Value []byte
+	}
+
+	//connect write queue config
+	WriteQueueConf struct {
+		Size         int           //max queued data
+		Overflow     int           //overflow policy
+		BlockTimeOut time.Duration //wait time for block policy
+		WriteTimeOut time.Duration //socket write deadline
+		FlushBytes   int           //max coalesced bytes of one write, 0 means one data per write
+		FlushWindow  time.Duration //wait time for more data before write
+	}
+
+	//capabilities of hello
+	//offered by client, or negotiated after reply
+	Capabilities struct {
+		Version     int    //protocol version
+		App         string //app name of client
+		AppVersion  string
+		Caps        uint32 //capability bits, like CapCompress
+		Compress    string //negotiated compress codec
+		MaxPackSize int
+	}
+
 	//send message request
 	SendMsgReq struct {
 		MsgId    uint32
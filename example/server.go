@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime/pprof"
 	"time"
 
@@ -84,15 +86,16 @@ func main() {
 		TcpVersion: "tcp",
 		Buckets: 31,
 		ErrMsgId: 100,
-		ReadTickerRate: 0.05,
-		SendTickerRate: 0.05,
 	}
 
 	//start pprof
 	//go runPProf()
 
 	//init server
-	server := cree.NewServer(conf)
+	server, err := cree.New(conf)
+	if err != nil {
+		log.Fatalln(err)
+	}
 
 	//register hook for new tcp connect start and stop
 	server.SetConnected(OnConnAdd)
@@ -110,10 +113,17 @@ func main() {
 	server.AddRouter(2, testApi)
 	server.AddRouter(3, testApi)
 
+	//listen
+	if err = server.Listen(); err != nil {
+		log.Fatalln(err)
+	}
 	fmt.Printf("start server on %s:%d\n", host, port)
 
-	//start service
-	server.Start()
+	//serve until interrupted
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = server.Serve(ctx)
+	log.Println("server stopped, err:", err)
 }
 
 ////////////
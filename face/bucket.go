@@ -1,13 +1,14 @@
 package face
 
 import (
+	"context"
 	"errors"
-	"io"
 	"log"
 	"math/rand"
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/andyzhou/cree/define"
 	"github.com/andyzhou/cree/iface"
@@ -21,6 +22,8 @@ import (
  * - bucket id hashed by connect id
  * - batch buckets contain all tcp connects
  * - one bucket contain batch connects
+ * - each connect read by its own goroutine,
+ *   bucket ticker polling is legacy mode only
  */
 
 //face info
@@ -28,35 +31,38 @@ type Bucket struct {
 	//inter obj
 	bucketId       int
 	errMsgId       uint32
+	readMode       int
 	readTickerRate float64
-	sendTickerRate float64
 
 	packet        iface.IPacket //packet interface
 	readMsgTicker *queue.Ticker //ticker for read connect msg
-	sendMsgQueue  *queue.List   //inter queue for send message
+	sendChan      chan *define.SendMsgReq //inter queue for send message
+	closeChan     chan bool
+	closeOnce     sync.Once
+	stopRead      int32 //stop read new message or not
 
 	//run env data
 	connMap   map[int64]iface.IConnect //connId -> IConnect
 	connCount int64
+	readWg    sync.WaitGroup //for connect readers
+	sendWg    sync.WaitGroup //for send process
 
 	//cb func
 	cbForReadMessage  func(iface.IConnect, iface.IRequest) error
+	cbForGroupMessage func(iface.IConnect, iface.IRequest) error
 	cbForDisconnected func(iface.IConnect)
 	sync.RWMutex
 }
 
 //construct
+//if read ticker rate > 0, use legacy ticker polling read mode
+//rates after read ticker rate ignored, send message consumed without ticker
 func NewBucket(id int, errMsgId uint32, tickerRates ...float64) *Bucket {
 	var (
 		readTickerRate float64
-		sendTickerRate float64
 	)
 	//check ticker rates
-	ratesLen := len(tickerRates)
-	if ratesLen > 1 {
-		readTickerRate = tickerRates[0]
-		sendTickerRate = tickerRates[1]
-	}else if ratesLen > 0 {
+	if len(tickerRates) > 0 {
 		readTickerRate = tickerRates[0]
 	}
 
@@ -67,19 +73,70 @@ func NewBucket(id int, errMsgId uint32, tickerRates ...float64) *Bucket {
 		packet: NewPacket(),
 		connMap: map[int64]iface.IConnect{},
 		readTickerRate: readTickerRate,
-		sendTickerRate: sendTickerRate,
+		sendChan: make(chan *define.SendMsgReq, define.DefaultChanSize),
+		closeChan: make(chan bool),
+	}
+	if readTickerRate > 0 {
+		this.readMode = define.ReadModeTicker
 	}
 	this.interInit()
 	return this
 }
 
 //quit
+//close all connects and stop inter goroutines
 func (f *Bucket) Quit() {
+	//stop read and send
+	f.StopRead()
+	f.stopSend()
+
+	//close all connects
+	for _, conn := range f.GetConnects() {
+		f.closeConn(conn)
+	}
+	f.freeRunMemory()
+}
+
+//stop read new message, used for graceful shutdown
+//blocked readers waked up by read deadline
+func (f *Bucket) StopRead() {
+	if !atomic.CompareAndSwapInt32(&f.stopRead, 0, 1) {
+		return
+	}
+
 	//close inter ticker
 	if f.readMsgTicker != nil {
 		f.readMsgTicker.Quit()
 	}
-	f.freeRunMemory()
+
+	//wake up blocked readers
+	for _, conn := range f.GetConnects() {
+		if netConn := conn.GetConn(); netConn != nil {
+			netConn.SetReadDeadline(time.Now())
+		}
+	}
+}
+
+//wait in-flight messages handled by readers
+func (f *Bucket) WaitRead(ctx context.Context) error {
+	return WaitWithContext(ctx, &f.readWg)
+}
+
+//flush queued send messages and write queue of connects
+func (f *Bucket) Flush(ctx context.Context) error {
+	//stop send process after queued messages sent
+	f.stopSend()
+	if err := WaitWithContext(ctx, &f.sendWg); err != nil {
+		return err
+	}
+
+	//flush write queue of connects
+	for _, conn := range f.GetConnects() {
+		if err := conn.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 //send sync message
@@ -88,13 +145,14 @@ func (f *Bucket) SendMessage(req *define.SendMsgReq) error {
 	if req == nil || req.MsgId < 0 || req.Data == nil {
 		return errors.New("invalid parameter")
 	}
-	if f.sendMsgQueue == nil {
-		return errors.New("inter send message queue is nil")
-	}
 
 	//save into running queue
-	err := f.sendMsgQueue.Push(req)
-	return err
+	select {
+	case f.sendChan <- req:
+	case <- f.closeChan:
+		return errors.New("bucket send process stopped")
+	}
+	return nil
 }
 
 //////////////////
@@ -109,6 +167,14 @@ func (f *Bucket) SetCBForReadMessage(cb func(iface.IConnect, iface.IRequest) err
 	f.cbForReadMessage = cb
 }
 
+//set cb for read message of connect which joined group
+func (f *Bucket) SetCBForGroupMessage(cb func(iface.IConnect, iface.IRequest) error) {
+	if cb == nil {
+		return
+	}
+	f.cbForGroupMessage = cb
+}
+
 //set cb for conn disconnected
 func (f *Bucket) SetCBForDisconnected(cb func(connect iface.IConnect)) {
 	if cb == nil {
@@ -129,8 +195,8 @@ func (f *Bucket) GetConnect(connId int64) (iface.IConnect, error) {
 	}
 
 	//get target with locker
-	f.Lock()
-	defer f.Unlock()
+	f.RLock()
+	defer f.RUnlock()
 	conn, ok := f.connMap[connId]
 	if ok && conn != nil {
 		return conn, nil
@@ -156,6 +222,41 @@ func (f *Bucket) RemoveConnect(connId int64) error {
 	return err
 }
 
+//get snapshot of connects with locker
+func (f *Bucket) GetConnects() []iface.IConnect {
+	f.RLock()
+	defer f.RUnlock()
+	connects := make([]iface.IConnect, 0, len(f.connMap))
+	for _, v := range f.connMap {
+		connects = append(connects, v)
+	}
+	return connects
+}
+
+//detach connect, removed and closed without disconnected cb
+//used when connect handed off to other process
+func (f *Bucket) DetachConnect(connId int64) error {
+	//check
+	if connId <= 0 {
+		return errors.New("invalid parameter")
+	}
+
+	//remove with locker
+	f.Lock()
+	conn, ok := f.connMap[connId]
+	if ok {
+		f.removeConn(connId)
+	}
+	f.Unlock()
+	if !ok || conn == nil {
+		return errors.New("can't get conn by id")
+	}
+
+	//close local connect
+	conn.Quit()
+	return nil
+}
+
 //add new connect
 func (f *Bucket) AddConnect(conn iface.IConnect) error {
 	//check
@@ -172,12 +273,74 @@ func (f *Bucket) AddConnect(conn iface.IConnect) error {
 
 	//sync into run env with locker
 	f.Lock()
-	defer f.Unlock()
+	if f.connMap == nil || atomic.LoadInt32(&f.stopRead) == 1 {
+		f.Unlock()
+		return errors.New("bucket is quit")
+	}
 	f.connMap[connId] = conn
 	atomic.AddInt64(&f.connCount, 1)
+
+	//spawn reader for new connect
+	if f.readMode == define.ReadModeRoutine {
+		f.readWg.Add(1)
+		go f.runReadProcess(conn)
+	}
+	f.Unlock()
 	return nil
 }
 
+//dispatch one read result of connect
+//if connect broken, close it and return false
+func (f *Bucket) DispatchRead(
+	conn iface.IConnect,
+	req iface.IRequest,
+	err error) bool {
+	//check
+	if conn == nil {
+		return false
+	}
+	if err != nil {
+		if IsBrokenErr(err) {
+			//io read failed
+			//close connect and remove it
+			f.closeConn(conn)
+			return false
+		}
+
+		//general error
+		log.Printf("bucket %v read conn %v data failed, err:%v\n",
+			f.bucketId, conn.GetConnId(), err.Error())
+
+		//send error frame to client connect
+		SendFrameError(conn, req, f.errMsgId, err)
+		return true
+	}
+
+	//control frame handled by handler
+	if req != nil && !IsDataFrame(req.GetMessage().GetKind()) {
+		return true
+	}
+
+	//if conn has group id, redirect to group
+	if conn.GetGroupId() > 0 {
+		if f.cbForGroupMessage != nil {
+			f.cbForGroupMessage(conn, req)
+		}
+		return true
+	}
+
+	//check and call read message cb
+	if f.cbForReadMessage != nil {
+		f.cbForReadMessage(conn, req)
+	}
+	return true
+}
+
+//set read mode, should be called before add connect
+func (f *Bucket) SetReadMode(mode int) {
+	f.readMode = mode
+}
+
 //set error message id
 func (f *Bucket) SetErrMsgId(id uint32) error {
 	if id < 0 {
@@ -187,10 +350,51 @@ func (f *Bucket) SetErrMsgId(id uint32) error {
 	return nil
 }
 
+//set packet codec, should be called before send
+func (f *Bucket) SetPacket(packet iface.IPacket) {
+	if packet == nil {
+		return
+	}
+	f.packet = packet
+}
+
 ///////////////
 //private func
 ///////////////
 
+//run read process for one connect
+//read blocked only on this connect, not whole bucket
+func (f *Bucket) runReadProcess(conn iface.IConnect) {
+	var (
+		m any = nil
+	)
+
+	//defer
+	defer func() {
+		if err := recover(); err != m {
+			log.Printf("bucket.runReadProcess panic, err:%v\n", err)
+		}
+		f.readWg.Done()
+	}()
+
+	//loop until connect closed or read stopped
+	for {
+		req, err := conn.ReadMessage()
+		if err != nil && atomic.LoadInt32(&f.stopRead) == 1 {
+			ReleaseRequest(req)
+			return
+		}
+		ok := f.DispatchRead(conn, req, err)
+		ReleaseRequest(req)
+		if !ok {
+			return
+		}
+		if atomic.LoadInt32(&f.stopRead) == 1 {
+			return
+		}
+	}
+}
+
 ////cb for read connect data
 //func (f *Bucket) cbForReadConnDataOld(
 //	workerId int32,
@@ -248,13 +452,16 @@ func (f *Bucket) cbForReadConnData(inputs ...interface{}) error {
 		err error
 	)
 	//check
-	if f.connCount <= 0 || f.connMap == nil {
+	if atomic.LoadInt64(&f.connCount) <= 0 {
 		return errors.New("no any active connections")
 	}
 
 	//loop read connect data with locker
 	f.Lock()
 	defer f.Unlock()
+	if f.connMap == nil {
+		return errors.New("no any active connections")
+	}
 	for connId, conn := range f.connMap {
 		//check connect
 		if connId <= 0 || conn == nil {
@@ -269,7 +476,7 @@ func (f *Bucket) cbForReadConnData(inputs ...interface{}) error {
 		//read message
 		req, err = conn.ReadMessage()
 		if err != nil {
-			if err == io.EOF {
+			if IsBrokenErr(err) {
 				//io read failed
 				//close connect and remove it
 				f.closeConn(conn, true)
@@ -278,9 +485,14 @@ func (f *Bucket) cbForReadConnData(inputs ...interface{}) error {
 				log.Printf("bucket %v read conn %v data failed, err:%v\n",
 					f.bucketId, conn.GetConnId(), err.Error())
 
-				//send error to client connect
-				conn.SendMessage(f.errMsgId, []byte(err.Error()))
+				//send error frame to client connect
+				SendFrameError(conn, req, f.errMsgId, err)
 			}
+			ReleaseRequest(req)
+			continue
+		}
+		if !IsDataFrame(req.GetMessage().GetKind()) {
+			ReleaseRequest(req)
 			continue
 		}
 
@@ -295,6 +507,7 @@ func (f *Bucket) cbForReadConnData(inputs ...interface{}) error {
 		if f.cbForReadMessage != nil {
 			f.cbForReadMessage(conn, req)
 		}
+		ReleaseRequest(req)
 	}
 	return nil
 }
@@ -324,7 +537,7 @@ func (f *Bucket) cbForConsumerSendData(data interface{}) error {
 	}
 
 	//loop send
-	for _, v := range f.connMap {
+	for _, v := range f.GetConnects() {
 		//check
 		if v == nil {
 			continue
@@ -335,7 +548,10 @@ func (f *Bucket) cbForConsumerSendData(data interface{}) error {
 			continue
 		}
 		//send message to target connect
-		err = v.SendData(msgData)
+		err = CheckFragment(f.packet, PeerCaps(v), len(req.Data))
+		if err == nil {
+			err = v.SendData(msgData)
+		}
 		if err != nil {
 			log.Printf("bucket.cbForConsumerSendData failed, err:%v\n", err.Error())
 		}
@@ -392,6 +608,7 @@ func (f *Bucket) checkSendCondition(
 func (f *Bucket) closeConn(conn iface.IConnect, skipLocker ...bool) error {
 	var (
 		skipLockerOpt bool
+		removed bool
 	)
 	//check
 	if conn == nil {
@@ -401,21 +618,31 @@ func (f *Bucket) closeConn(conn iface.IConnect, skipLocker ...bool) error {
 		skipLockerOpt = skipLocker[0]
 	}
 
-	//get key data
-	connId := conn.GetConnId()
+	//remove from run env
+	if skipLockerOpt {
+		removed = f.removeConn(conn.GetConnId())
+	}else{
+		f.Lock()
+		removed = f.removeConn(conn.GetConnId())
+		f.Unlock()
+	}
 
-	//close connect and remove it
+	//close connect
 	conn.Quit()
 
 	//check and call closed cb
-	if f.cbForDisconnected != nil {
+	//if removed already, skip it
+	if removed && f.cbForDisconnected != nil {
 		f.cbForDisconnected(conn)
 	}
+	return nil
+}
 
-	//remove from run env
-	if !skipLockerOpt {
-		f.Lock()
-		defer f.Unlock()
+//remove connect from run env, caller should hold locker
+//if connect not exists, return false
+func (f *Bucket) removeConn(connId int64) bool {
+	if _, ok := f.connMap[connId]; !ok {
+		return false
 	}
 	delete(f.connMap, connId)
 
@@ -431,17 +658,17 @@ func (f *Bucket) closeConn(conn iface.IConnect, skipLocker ...bool) error {
 	}
 
 	//gc memory
-	if needRebuild || f.connCount <= 0 {
+	if needRebuild || connCount <= 0 {
 		newConnMap := map[int64]iface.IConnect{}
 		for k, v := range f.connMap {
 			newConnMap[k] = v
 		}
 		f.connMap = newConnMap
-		if f.connCount <= 0 {
+		if connCount <= 0 {
 			runtime.GC()
 		}
 	}
-	return nil
+	return true
 }
 
 //free run memory
@@ -454,44 +681,67 @@ func (f *Bucket) freeRunMemory() {
 }
 
 //pack message
+//large data split into fragments, sent to connects support fragment
 func (f *Bucket) packMessage(messageId uint32, data []byte) ([]byte, error) {
-	//create message
-	message := NewMessage()
-	message.SetId(messageId)
-	message.SetData(data)
-
-	//create message packet
-	byteData, err := f.packet.Pack(message)
-	return byteData, err
+	//create message packet, large data split into fragments
+	return AppendFrames(f.packet, nil, define.KindPush, messageId, data, define.CapFragment)
 }
 
 //init read message ticker
 func (f *Bucket) initReadMsgTicker() {
-	//get connect read msg rate
-	if f.readTickerRate <= 0 {
-		f.readTickerRate = define.DefaultBucketReadRate
-	}
-
 	//init read msg ticker
 	f.readMsgTicker = queue.NewTicker(f.readTickerRate)
 	f.readMsgTicker.SetCheckerCallback(f.cbForReadConnData)
 }
 
-//init send message queue and consumer
-func (f *Bucket) initSendMsgConsumer() {
-	//get send msg rate
-	if f.sendTickerRate <= 0 {
-		f.sendTickerRate = define.DefaultBucketSendRate
+//stop send process, queued messages sent before quit
+func (f *Bucket) stopSend() {
+	f.closeOnce.Do(func() {
+		close(f.closeChan)
+	})
+}
+
+//run send process
+func (f *Bucket) runSendProcess() {
+	var (
+		m any = nil
+	)
+
+	//defer
+	defer func() {
+		if err := recover(); err != m {
+			log.Printf("bucket.runSendProcess panic, err:%v\n", err)
+		}
+		f.sendWg.Done()
+	}()
+
+	//loop
+	for {
+		select {
+		case req := <- f.sendChan:
+			f.cbForConsumerSendData(req)
+		case <- f.closeChan:
+			//send left messages
+			for {
+				select {
+				case req := <- f.sendChan:
+					f.cbForConsumerSendData(req)
+				default:
+					return
+				}
+			}
+		}
 	}
-	f.sendMsgQueue = queue.NewList()
-	f.sendMsgQueue.SetConsumer(f.cbForConsumerSendData, f.sendTickerRate)
 }
 
 //inter init
 func (f *Bucket) interInit() {
-	//init read msg ticker
-	f.initReadMsgTicker()
+	//init read msg ticker for legacy mode
+	if f.readMode == define.ReadModeTicker {
+		f.initReadMsgTicker()
+	}
 
-	//init send message queue
-	f.initSendMsgConsumer()
+	//run send process
+	f.sendWg.Add(1)
+	go f.runSendProcess()
 }
\ No newline at end of file
@@ -0,0 +1,145 @@
+package face
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for message data codec
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - codecs registered by name, used by typed router and call
+ * - raw codec for []byte, string and binary marshaler
+ */
+
+//inter error define
+var (
+	ErrCodecType = errors.New("type not supported by codec")
+)
+
+//registered codecs
+var (
+	codecCreators = map[string]func() iface.ICodec{
+		define.CodecJson: func() iface.ICodec { return NewJsonCodec() },
+		define.CodecGob: func() iface.ICodec { return NewGobCodec() },
+		define.CodecRaw: func() iface.ICodec { return NewRawCodec() },
+	}
+	codecLocker sync.RWMutex
+)
+
+//json codec
+type JsonCodec struct {
+}
+
+//gob codec, one value per data
+type GobCodec struct {
+}
+
+//raw codec, data copied as it is
+type RawCodec struct {
+}
+
+//construct
+func NewJsonCodec() *JsonCodec {
+	return &JsonCodec{}
+}
+
+func NewGobCodec() *GobCodec {
+	return &GobCodec{}
+}
+
+func NewRawCodec() *RawCodec {
+	return &RawCodec{}
+}
+
+//register codec creator by name, replace old one
+func RegisterCodec(name string, creator func() iface.ICodec) error {
+	//check
+	if name == "" || creator == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//sync with locker
+	codecLocker.Lock()
+	defer codecLocker.Unlock()
+	codecCreators[name] = creator
+	return nil
+}
+
+//create codec by name, json codec if name is empty
+func CreateCodec(name string) (iface.ICodec, error) {
+	if name == "" {
+		name = define.CodecJson
+	}
+	codecLocker.RLock()
+	creator, ok := codecCreators[name]
+	codecLocker.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no such codec %v", name)
+	}
+	return creator(), nil
+}
+
+///////////////////
+//api for codec
+///////////////////
+
+func (f *JsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (f *JsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (f *GobCodec) Marshal(v any) ([]byte, error) {
+	buff := bytes.NewBuffer(nil)
+	if err := gob.NewEncoder(buff).Encode(v); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (f *GobCodec) Unmarshal(data []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (f *RawCodec) Marshal(v any) ([]byte, error) {
+	switch val := v.(type) {
+	case []byte:
+		return val, nil
+	case *[]byte:
+		return *val, nil
+	case string:
+		return []byte(val), nil
+	case *string:
+		return []byte(*val), nil
+	case encoding.BinaryMarshaler:
+		return val.MarshalBinary()
+	}
+	return nil, fmt.Errorf("%w, %T", ErrCodecType, v)
+}
+
+//data copied, buffer of message may be reused
+func (f *RawCodec) Unmarshal(data []byte, v any) error {
+	switch val := v.(type) {
+	case *[]byte:
+		*val = append([]byte{}, data...)
+		return nil
+	case *string:
+		*val = string(data)
+		return nil
+	case encoding.BinaryUnmarshaler:
+		return val.UnmarshalBinary(append([]byte{}, data...))
+	}
+	return fmt.Errorf("%w, %T", ErrCodecType, v)
+}
@@ -0,0 +1,271 @@
+package face
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for payload compress
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - compressors registered by name, negotiated by hello frame
+ * - compressed data marked by flag of kind
+ * - max pack size checked for decompressed data
+ */
+
+//registered compressors
+var (
+	compressorCreators = map[string]func() iface.ICompressor{
+		define.CompressFlate: func() iface.ICompressor { return NewFlateCompressor(flate.DefaultCompression) },
+		define.CompressGzip: func() iface.ICompressor { return NewGzipCompressor(gzip.DefaultCompression) },
+	}
+	compressorLocker sync.RWMutex
+)
+
+//flate compressor
+type FlateCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+//gzip compressor
+type GzipCompressor struct {
+	level   int
+	writers sync.Pool
+}
+
+//packet with payload compress
+//wrap codec with kind, data less than threshold not compressed
+type CompressPacket struct {
+	iface.IPacket
+	name       string
+	compressor iface.ICompressor
+	threshold  int
+}
+
+//construct
+func NewFlateCompressor(level int) *FlateCompressor {
+	this := &FlateCompressor{
+		level: level,
+	}
+	return this
+}
+
+func NewGzipCompressor(level int) *GzipCompressor {
+	this := &GzipCompressor{
+		level: level,
+	}
+	return this
+}
+
+func NewCompressPacket(
+	packet iface.IPacket,
+	name string,
+	threshold int) (*CompressPacket, error) {
+	//check
+	if packet == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	compressor, err := CreateCompressor(name)
+	if err != nil {
+		return nil, err
+	}
+	if threshold <= 0 {
+		threshold = define.DefaultCompressThreshold
+	}
+	this := &CompressPacket{
+		IPacket: packet,
+		name: name,
+		compressor: compressor,
+		threshold: threshold,
+	}
+	return this, nil
+}
+
+//register compressor creator by name, replace old one
+func RegisterCompressor(name string, creator func() iface.ICompressor) error {
+	//check
+	if name == "" || creator == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//sync with locker
+	compressorLocker.Lock()
+	defer compressorLocker.Unlock()
+	compressorCreators[name] = creator
+	return nil
+}
+
+//create compressor by name
+func CreateCompressor(name string) (iface.ICompressor, error) {
+	compressorLocker.RLock()
+	creator, ok := compressorCreators[name]
+	compressorLocker.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no such compressor %v", name)
+	}
+	return creator(), nil
+}
+
+//check codec carry kind or not, compress flag need kind
+func HasKind(packet iface.IPacket) bool {
+	kind := MakeKind(define.KindHello, 1) | define.KindFlagCompress
+	message := AcquireMessage()
+	defer ReleaseMessage(message)
+	message.SetKind(kind)
+	message.SetData([]byte{0})
+	data, err := packet.Pack(message)
+	if err != nil {
+		return false
+	}
+	decoded, _, err := packet.Decode(data)
+	return err == nil && decoded != nil && decoded.GetKind() == kind
+}
+
+///////////////////
+//api for packet
+///////////////////
+
+//get compressor name
+func (f *CompressPacket) GetName() string {
+	return f.name
+}
+
+//decode one message, decompress data if flag set
+func (f *CompressPacket) Decode(data []byte) (iface.IMessage, int, error) {
+	message, size, err := f.IPacket.Decode(data)
+	if err != nil || message == nil {
+		return message, size, err
+	}
+	kind := message.GetKind()
+	if kind & define.KindFlagCompress == 0 {
+		return message, size, nil
+	}
+
+	//decompress with max pack size
+	body, err := f.compressor.Decompress(message.GetData(), f.GetMaxPackSize())
+	if err != nil {
+		return nil, 0, err
+	}
+	message.SetKind(kind &^ define.KindFlagCompress)
+	message.SetData(body)
+	return message, size, nil
+}
+
+func (f *CompressPacket) Pack(message iface.IMessage) ([]byte, error) {
+	return f.AppendPack(nil, message)
+}
+
+//pack data, compressed if not less than threshold
+func (f *CompressPacket) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	//check
+	if message == nil {
+		return dst, errors.New("invalid parameter")
+	}
+	data := message.GetData()
+	if len(data) < f.threshold {
+		return f.IPacket.AppendPack(dst, message)
+	}
+
+	//compress, keep raw data if no gain
+	compressed, err := f.compressor.Compress(data)
+	if err != nil || len(compressed) >= len(data) {
+		return f.IPacket.AppendPack(dst, message)
+	}
+	packed := AcquireMessage()
+	defer ReleaseMessage(packed)
+	packed.SetKind(message.GetKind() | define.KindFlagCompress)
+	packed.SetId(message.GetId())
+	packed.SetData(compressed)
+	return f.IPacket.AppendPack(dst, packed)
+}
+
+////////////////////
+//api for flate
+////////////////////
+
+func (f *FlateCompressor) Compress(data []byte) ([]byte, error) {
+	buff := bytes.NewBuffer(make([]byte, 0, len(data) / 2))
+	w, _ := f.writers.Get().(*flate.Writer)
+	if w == nil {
+		var err error
+		if w, err = flate.NewWriter(buff, f.level); err != nil {
+			return nil, err
+		}
+	}else{
+		w.Reset(buff)
+	}
+	defer f.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (f *FlateCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	return readLimit(r, limit)
+}
+
+////////////////////
+//api for gzip
+////////////////////
+
+func (f *GzipCompressor) Compress(data []byte) ([]byte, error) {
+	buff := bytes.NewBuffer(make([]byte, 0, len(data) / 2))
+	w, _ := f.writers.Get().(*gzip.Writer)
+	if w == nil {
+		var err error
+		if w, err = gzip.NewWriterLevel(buff, f.level); err != nil {
+			return nil, err
+		}
+	}else{
+		w.Reset(buff)
+	}
+	defer f.writers.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+func (f *GzipCompressor) Decompress(data []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readLimit(r, limit)
+}
+
+///////////////
+//private func
+///////////////
+
+//read all data, failed if over limit
+func readLimit(r io.Reader, limit int) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, int64(limit) + 1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > limit {
+		return nil, fmt.Errorf("%w, decompressed length over %d", ErrPacketTooLarge, limit)
+	}
+	return data, nil
+}
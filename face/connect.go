@@ -1,13 +1,19 @@
 package face
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/andyzhou/cree/define"
 	"github.com/andyzhou/cree/iface"
 )
 
@@ -17,25 +23,56 @@ import (
  * @mail <diudiu8848@163.com>
  */
 
+//inter error define
+var (
+	ErrConnClosed    = errors.New("connect is nil")
+	ErrQueueFull     = errors.New("connect write queue is full")
+	ErrQueueTimeOut  = errors.New("connect write queue wait time out")
+	ErrSlowConsumer  = errors.New("connect closed as slow consumer")
+	ErrPeerClosed    = errors.New("connect closed by peer")
+	ErrRequestTimeOut = errors.New("connect request time out")
+	ErrHelloRejected  = errors.New("connect rejected by hello")
+	ErrConnHandedOff  = errors.New("connect handed off to other process")
+	ErrCallUnsupported = errors.New("request not supported by packet without kind")
+)
+
  //face info
 type Connect struct {
 	tcpServer   iface.IServer //parent tcp server reference
 	packet      iface.IPacket //parent packet interface reference
-	conn        *net.TCPConn  //socket tcp connect
+	conn        net.Conn      //socket connect, like tcp or tls
 	handler     iface.IHandler
 	tagMap      map[string]bool
 	propertyMap map[string]interface{}
 	connId      int64
-	groupId		int64
+	groupId		int64 //read by reader, written by group, atomic
+	listener    string //name of listener which accepted it
 	isClosed    bool
 	activeTime  int64 //last active timestamp
+	reader      *PacketReader //framing reader, keep read but not framed data
+	assembler   *Assembler    //reassemble fragments of large message
+	mux         *Mux          //streams of connect
+	secureRequired bool       //plain data frame refused
+	helloRequired  bool       //data frame refused before hello
+	capabilities *define.Capabilities //negotiated by hello
+	packLocker  sync.Mutex    //data queued in order of pack, like counter of sealed data
+
+	//write queue
+	writeConf   *define.WriteQueueConf
+	sendQueue   [][]byte      //queued data for writer
+	spaceChan   chan struct{} //closed when queue has space
+	idleChan    chan struct{} //closed when writer quit
+	writing     bool          //writer running or not
+	sendErr     error         //send closed, like handed off
+	dropCount   int64
+	sendLocker  sync.Mutex
 	sync.RWMutex
 }
 
  //construct
 func NewConnect(
 		server iface.IServer,
-		conn *net.TCPConn,
+		conn net.Conn,
 		connectId int64,
 		handler iface.IHandler,
 	) *Connect {
@@ -48,6 +85,17 @@ func NewConnect(
 		handler:handler,
 		tagMap: map[string]bool{},
 		propertyMap:make(map[string]interface{}),
+		assembler: NewAssembler(0),
+		writeConf: &define.WriteQueueConf{
+			Size: define.ConnectWriteChanSize,
+			Overflow: define.OverflowBlock,
+			BlockTimeOut: define.DefaultWriteBlockTimeOut * time.Second,
+			WriteTimeOut: define.DefaultTcpWriteTimeOut * time.Second,
+		},
+	}
+	this.mux = NewMux(this.SendFrame, this.packet, false)
+	if conn != nil {
+		this.mux.SetAddr(conn.LocalAddr(), conn.RemoteAddr())
 	}
 	return this
 }
@@ -67,84 +115,428 @@ func (c *Connect) Quit() {
 	//release memory
 	c.tagMap = nil
 	c.propertyMap = nil
+	c.assembler.Abort(ErrConnClosed)
+	c.mux.Close(ErrConnClosed)
+
+	//clean write queue
+	c.sendLocker.Lock()
+	c.sendQueue = nil
+	c.notifyQueueSpace()
+	c.sendLocker.Unlock()
+}
+
+//close send and streams of connect, like handed off to other process
+//frames packed before returned, later sends failed with err
+//queued data still written, see Flush
+func (c *Connect) CloseSend(err error) {
+	if err == nil {
+		err = ErrConnClosed
+	}
+	c.packLocker.Lock()
+	c.sendLocker.Lock()
+	if c.sendErr == nil {
+		c.sendErr = err
+	}
+	c.notifyQueueSpace()
+	c.sendLocker.Unlock()
+	c.packLocker.Unlock()
+	c.mux.Close(err)
+}
+
+//set max reassembled message size, default define.DefaultFragmentBudget
+//should be called before reader started
+func (c *Connect) SetFragmentBudget(budget int) {
+	c.assembler = NewAssembler(budget)
+}
+
+//set write queue config, should be called before send
+func (c *Connect) SetWriteConf(conf *define.WriteQueueConf) {
+	if conf == nil {
+		return
+	}
+	c.sendLocker.Lock()
+	defer c.sendLocker.Unlock()
+	c.writeConf = conf
+}
+
+//wait queued data written, used for graceful shutdown
+func (c *Connect) Flush(ctx context.Context) error {
+	c.sendLocker.Lock()
+	if !c.writing {
+		c.sendLocker.Unlock()
+		return nil
+	}
+	if c.idleChan == nil {
+		c.idleChan = make(chan struct{})
+	}
+	idleChan := c.idleChan
+	c.sendLocker.Unlock()
+
+	//wait writer quit
+	select {
+	case <- idleChan:
+		return nil
+	case <- ctx.Done():
+		return ctx.Err()
+	}
+}
+
+//get queued data size
+func (c *Connect) GetSendQueueLen() int {
+	c.sendLocker.Lock()
+	defer c.sendLocker.Unlock()
+	return len(c.sendQueue)
+}
+
+//get dropped data count
+func (c *Connect) GetDropCount() int64 {
+	return atomic.LoadInt64(&c.dropCount)
 }
 
 //get last active time
 func (c *Connect) GetActiveTime() int64 {
-	return c.activeTime
+	return atomic.LoadInt64(&c.activeTime)
 }
 
-//send message direct
+//send packed data
+//data queued and written by connect writer
+//data packed by shared codec sealed again if secure channel enabled
 func (c *Connect) SendData(byteData []byte) error {
 	//check
 	if byteData == nil {
 		return errors.New("invalid parameter")
 	}
-
-	//defer update active time
-	defer func() {
-		c.activeTime = time.Now().Unix()
-	}()
-
-	//direct send with locker
-	c.Lock()
-	defer c.Unlock()
-	_, err := (*c.conn).Write(byteData)
-	return err
+	if secure := GetSecurePacket(c.GetPacket()); secure != nil {
+		return c.sendSealed(secure, byteData)
+	}
+	return c.sendPacked(byteData)
 }
 
+//message id 0 for codec without id, like varint
 func (c *Connect) SendMessage(messageId uint32, data []byte) error {
+	return c.SendFrame(define.KindPush, messageId, data)
+}
+
+//send message with kind, like response or pong
+//kind made by frame type and seq, see MakeKind
+func (c *Connect) SendFrame(kind uint32, messageId uint32, data []byte) error {
 	//basic check
-	if messageId <= 0 || data == nil {
+	if data == nil {
 		return errors.New("invalid parameter")
 	}
-	if c.conn == nil {
-		return errors.New("connect is nil")
+
+	//create message packet, large data split into fragments
+	//queued in order of pack
+	c.packLocker.Lock()
+	defer c.packLocker.Unlock()
+	if err := c.getSendErr(); err != nil {
+		return err
+	}
+	byteData, err := AppendFrames(c.GetPacket(), nil, kind, messageId, data, PeerCaps(c))
+	if err != nil {
+		return err
 	}
 
-	//create message
-	message := NewMessage()
-	message.SetId(messageId)
-	message.SetData(data)
+	//send packed data
+	return c.sendPacked(byteData)
+}
+
+//send last frame by current codec and switch codec, like hello reply
+//frames of other senders packed by new codec after it
+func (c *Connect) SwitchPacket(
+	packet iface.IPacket,
+	kind uint32,
+	messageId uint32,
+	data []byte) error {
+	//check
+	if packet == nil || data == nil {
+		return errors.New("invalid parameter")
+	}
+	c.packLocker.Lock()
+	defer c.packLocker.Unlock()
+	if err := c.getSendErr(); err != nil {
+		return err
+	}
 
 	//create message packet
-	byteData, err := c.packet.Pack(message)
+	message := AcquireMessage()
+	message.SetKind(kind)
+	message.SetId(messageId)
+	message.SetData(data)
+	byteData, err := c.GetPacket().Pack(message)
+	ReleaseMessage(message)
 	if err != nil {
 		return err
 	}
 
-	//defer update active time
-	defer func() {
-		c.activeTime = time.Now().Unix()
-	}()
+	//send and switch
+	if err = c.sendPacked(byteData); err != nil {
+		return err
+	}
+	c.SetPacket(packet)
+	return nil
+}
 
-	//direct send with locker
-	c.Lock()
-	defer c.Unlock()
-	_, err = (*c.conn).Write(byteData)
-	return err
+//send request to client and wait reply of same seq
+//return ErrRequestTimeOut, ErrConnClosed or *FrameError of remote
+//codec without kind, like varint, return ErrCallUnsupported
+//should not be called in router of same connect, reader blocked
+func (c *Connect) Request(
+	ctx context.Context,
+	messageId uint32,
+	data []byte) (iface.IMessage, error) {
+	//check
+	if data == nil || c.handler == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	if !CarryKind(c.GetPacket()) {
+		return nil, ErrCallUnsupported
+	}
+
+	//add waiter before send
+	seq := c.handler.NextSeq()
+	waiter, err := c.handler.AddWaiter(c.connId, seq)
+	if err != nil {
+		return nil, err
+	}
+	defer c.handler.RemoveWaiter(c.connId, seq)
+
+	//send request
+	err = c.SendFrame(MakeKind(define.KindRequest, seq), messageId, data)
+	if err != nil {
+		return nil, err
+	}
+
+	//wait reply
+	select {
+	case message, ok := <- waiter:
+		if !ok {
+			return nil, ErrConnClosed
+		}
+		if KindType(message.GetKind()) == define.KindError {
+			return nil, DecodeFrameError(message)
+		}
+		return message, nil
+	case <- ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, ErrRequestTimeOut
+		}
+		return nil, ctx.Err()
+	}
 }
 
 //read message
+//should be called by one reader only
+//request pooled, put back by ReleaseRequest after handled
 func (c *Connect) ReadMessage() (iface.IRequest, error) {
-	//init header
-	header := make([]byte, c.packet.GetHeadLen())
+	return c.readOneMessage()
+}
+
+//handle one complete message
+//used for message framed outside, like event loop
+//request pooled, put back by ReleaseRequest after handled
+func (c *Connect) HandleMessage(message iface.IMessage) (iface.IRequest, error) {
+	//check
+	if message == nil {
+		return nil, errors.New("invalid parameter")
+	}
 
-	//read one message
-	req, err := c.readOneMessage(header)
+	//defer update active time
+	defer func() {
+		atomic.StoreInt64(&c.activeTime, time.Now().Unix())
+	}()
+
+	//reassemble fragments, fragment returned as control frame before done
+	whole, err := c.assembler.Add(message, c.openStream)
+	if err != nil {
+		if whole != nil {
+			return AcquireRequest(c, whole), err
+		}
+		return nil, err
+	}
+	if whole == nil {
+		return AcquireRequest(c, message), nil
+	}
+	message = whole
+
+	//init client request, released by reader after handled
+	req := AcquireRequest(c, message)
+
+	//data frame refused if hello or secure required
+	kind := message.GetKind()
+	if c.helloRequired && (IsDataFrame(kind) || IsStreamFrame(kind)) &&
+		c.GetCapabilities() == nil {
+		return c.refuseFrame(req, NewFrameError(define.FrameErrNoHello, "hello required"))
+	}
+	if c.secureRequired && (IsDataFrame(kind) || IsStreamFrame(kind)) &&
+		GetSecurePacket(c.GetPacket()) == nil {
+		return c.refuseFrame(req, NewFrameError(define.FrameErrInsecure, "secure channel required"))
+	}
+
+	//frame of stream handled by mux
+	if IsStreamFrame(kind) {
+		c.mux.HandleFrame(message)
+		return req, nil
+	}
+
+	//handle request message
+	err = c.handler.DoMessageHandle(req)
 	return req, err
 }
 
 //get remote client address
 func (c *Connect) GetRemoteAddr() net.Addr {
+	c.RLock()
+	defer c.RUnlock()
+	if c.conn == nil {
+		return nil
+	}
 	return c.conn.RemoteAddr()
 }
 
 //get connect
-func (c *Connect) GetConn() *net.TCPConn {
+func (c *Connect) GetConn() net.Conn {
+	c.RLock()
+	defer c.RUnlock()
 	return c.conn
 }
 
+//get peer leaf certificate of tls connect
+func (c *Connect) GetPeerCertificate() *x509.Certificate {
+	c.RLock()
+	defer c.RUnlock()
+	tlsConn, ok := c.conn.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) <= 0 {
+		return nil
+	}
+	return state.PeerCertificates[0]
+}
+
+//get peer identity of tls connect, like certificate common name
+func (c *Connect) GetPeerIdentity() string {
+	cert := c.GetPeerCertificate()
+	if cert == nil {
+		return ""
+	}
+	return cert.Subject.CommonName
+}
+
+//get proxy address if accepted with proxy protocol
+//remote address is real client address
+func (c *Connect) GetProxyAddr() net.Addr {
+	c.RLock()
+	defer c.RUnlock()
+	proxyConn := GetProxyConn(c.conn)
+	if proxyConn == nil {
+		return nil
+	}
+	return proxyConn.ProxyAddr()
+}
+
+//get tlvs of proxy protocol v2 header
+func (c *Connect) GetProxyTLVs() []define.ProxyTLV {
+	c.RLock()
+	defer c.RUnlock()
+	proxyConn := GetProxyConn(c.conn)
+	if proxyConn == nil {
+		return nil
+	}
+	return proxyConn.TLVs()
+}
+
+//set name of listener which accepted it
+func (c *Connect) SetListener(name string) {
+	c.listener = name
+}
+
+//get name of listener which accepted it
+func (c *Connect) GetListener() string {
+	return c.listener
+}
+
+//set packet codec of connect, like compress negotiated
+//should be called in reader, like hello handler
+func (c *Connect) SetPacket(packet iface.IPacket) {
+	if packet == nil {
+		return
+	}
+	c.Lock()
+	c.packet = packet
+	c.Unlock()
+	if c.reader != nil {
+		c.reader.SetPacket(packet)
+	}
+}
+
+//set hello required or not
+//should be called before reader started
+func (c *Connect) SetHelloRequired(required bool) {
+	c.helloRequired = required
+}
+
+//set capabilities negotiated by hello
+func (c *Connect) SetCapabilities(capabilities *define.Capabilities) {
+	c.Lock()
+	c.capabilities = capabilities
+	c.Unlock()
+	if capabilities != nil {
+		c.mux.SetCaps(capabilities.Caps)
+	}
+}
+
+//open stream to client, client should set cb for stream
+func (c *Connect) OpenStream() (iface.IStream, error) {
+	stream, err := c.mux.Open()
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+//set cb for stream opened by client, called in background
+func (c *Connect) SetStreamHandler(cb func(iface.IStream)) {
+	c.mux.SetCBForAccept(cb)
+}
+
+//get capabilities negotiated by hello, nil if not negotiated
+func (c *Connect) GetCapabilities() *define.Capabilities {
+	c.RLock()
+	defer c.RUnlock()
+	return c.capabilities
+}
+
+//set secure channel required or not
+//should be called before reader started
+func (c *Connect) SetSecureRequired(required bool) {
+	c.secureRequired = required
+}
+
+//get packet codec of connect
+func (c *Connect) GetPacket() iface.IPacket {
+	c.RLock()
+	defer c.RUnlock()
+	return c.packet
+}
+
+//get and clear pending data, read but not framed
+//should be called after reader stopped
+func (c *Connect) TakePending() []byte {
+	if c.reader == nil {
+		return nil
+	}
+	return c.reader.Pending()
+}
+
+//set pending data, consumed before connect data
+//should be called before reader started
+func (c *Connect) SetPending(pending []byte) {
+	c.getReader().SetPending(pending)
+}
+
 //get connect id
 func (c *Connect) GetConnId() int64 {
 	return c.connId
@@ -196,7 +588,7 @@ func (c *Connect) SetTag(tags ...string) error {
 
 //get group id
 func (c *Connect) GetGroupId() int64 {
-	return c.groupId
+	return atomic.LoadInt64(&c.groupId)
 }
 
 //set group id
@@ -204,7 +596,7 @@ func (c *Connect) SetGroupId(groupId int64) error {
 	if groupId < 0 {
 		return errors.New("invalid parameter")
 	}
-	c.groupId = groupId
+	atomic.StoreInt64(&c.groupId, groupId)
 	return nil
 }
 
@@ -237,6 +629,17 @@ func (c *Connect) SetProperty(key string, value interface{}) bool {
 	return true
 }
 
+//get snapshot of all properties
+func (c *Connect) GetProperties() map[string]interface{} {
+	c.Lock()
+	defer c.Unlock()
+	properties := make(map[string]interface{}, len(c.propertyMap))
+	for k, v := range c.propertyMap {
+		properties[k] = v
+	}
+	return properties
+}
+
 //get property
 func (c *Connect) GetProperty(key string) (interface{}, error) {
 	c.Lock()
@@ -252,67 +655,311 @@ func (c *Connect) GetProperty(key string) (interface{}, error) {
 //private func
 //////////////
 
-//read one message
-func (c *Connect) readOneMessage(header []byte) (iface.IRequest, error) {
-	//check
-	if header == nil {
-		return nil, errors.New("invalid header parameter")
+//refuse data frame with error frame, stream reset if opened
+func (c *Connect) refuseFrame(req iface.IRequest, frameErr *FrameError) (iface.IRequest, error) {
+	message := req.GetMessage()
+	if !IsStreamFrame(message.GetKind()) {
+		return req, frameErr
 	}
-	if c.conn == nil {
-		return nil, errors.New("connect is nil")
+	if KindType(message.GetKind()) == define.KindStreamOpen {
+		c.mux.sendReset(message.GetId(), frameErr)
 	}
+	return req, nil
+}
 
-	//read message head
-	_, err := io.ReadFull(c.conn, header)
+//read one message
+func (c *Connect) readOneMessage() (iface.IRequest, error) {
+	//get connect with locker
+	c.RLock()
+	conn := c.conn
+	c.RUnlock()
+	if conn == nil {
+		return nil, ErrConnClosed
+	}
+
+	//read and frame message
+	message, err := c.getReader().ReadMessage(conn)
 	if err != nil {
+		if errors.Is(err, ErrPacketTooLarge) || errors.Is(err, ErrCorruptFrame) {
+			errTip := fmt.Errorf("cree.connect.startRead, unpack message failed, err:%w", err)
+			return nil, errTip
+		}
 		return nil, err
 	}
 
-	//unpack header
-	message, subErr := c.packet.UnPack(header)
-	if subErr != nil {
-		errTip := fmt.Errorf("cree.connect.startRead, unpack message failed, err:%v", subErr.Error())
-		return nil, errTip
+	//handle message
+	return c.HandleMessage(message)
+}
+
+//open stream of stream router for fragmented data frame
+func (c *Connect) openStream(message iface.IMessage, reader *FragmentReader) bool {
+	if !IsDataFrame(message.GetKind()) {
+		return false
+	}
+	if c.secureRequired && GetSecurePacket(c.GetPacket()) == nil {
+		return false
+	}
+	if c.helloRequired && c.GetCapabilities() == nil {
+		return false
 	}
+	return c.handler.OpenStream(NewRequest(c, message), reader)
+}
+
+//push packed data into write queue
+func (c *Connect) sendPacked(byteData []byte) error {
+	//defer update active time
+	defer func() {
+		atomic.StoreInt64(&c.activeTime, time.Now().Unix())
+	}()
+
+	//push into write queue
+	return c.pushSendQueue(byteData)
+}
 
-	//read real data and storage into message object
-	if message.GetLen() > 0 {
-		data := make([]byte, message.GetLen())
-		_, err = io.ReadFull(c.conn, data)
+//seal data packed by shared codec, like broadcast
+//frames queued as one data, fragments not mixed with others
+func (c *Connect) sendSealed(secure *SecurePacket, byteData []byte) error {
+	var (
+		sealed []byte
+	)
+	c.packLocker.Lock()
+	defer c.packLocker.Unlock()
+	if err := c.getSendErr(); err != nil {
+		return err
+	}
+	packet := c.GetPacket()
+	for len(byteData) > 0 {
+		message, size, err := secure.IPacket.Decode(byteData)
 		if err != nil {
-			errTip := fmt.Errorf("cree.connect.startRead, read data failed, err:%v", err.Error())
-			return nil, errTip
+			return err
+		}
+		if message == nil {
+			return errors.New("incomplete packed data")
+		}
+		byteData = byteData[size:]
+		if sealed, err = packet.AppendPack(sealed, message); err != nil {
+			return err
 		}
-		message.SetData(data)
 	}
+	return c.sendPacked(sealed)
+}
 
-	//defer update active time
+//get framing reader, init if not exists
+func (c *Connect) getReader() *PacketReader {
+	if c.reader == nil {
+		c.reader = NewPacketReader(c.GetPacket(), 0)
+	}
+	return c.reader
+}
+
+//push data into write queue
+func (c *Connect) pushSendQueue(data []byte) error {
+	var (
+		timer *time.Timer
+	)
+
+	//loop until pushed
+	for {
+		c.RLock()
+		closed := c.conn == nil
+		c.RUnlock()
+		if closed {
+			return ErrConnClosed
+		}
+
+		c.sendLocker.Lock()
+		if c.sendErr != nil {
+			c.sendLocker.Unlock()
+			return c.sendErr
+		}
+		conf := c.writeConf
+		if conf.Size <= 0 || len(c.sendQueue) < conf.Size {
+			//push and wake up writer
+			c.sendQueue = append(c.sendQueue, data)
+			if !c.writing {
+				c.writing = true
+				go c.runWriteProcess()
+			}
+			c.sendLocker.Unlock()
+			return nil
+		}
+
+		//queue is full, check overflow policy
+		switch conf.Overflow {
+		case define.OverflowDropOldest:
+			c.sendQueue[0] = nil
+			c.sendQueue = append(c.sendQueue[1:], data)
+			c.sendLocker.Unlock()
+			atomic.AddInt64(&c.dropCount, 1)
+			return nil
+		case define.OverflowDropNewest:
+			c.sendLocker.Unlock()
+			atomic.AddInt64(&c.dropCount, 1)
+			return ErrQueueFull
+		case define.OverflowDisconnect:
+			c.sendLocker.Unlock()
+			atomic.AddInt64(&c.dropCount, 1)
+			c.Quit()
+			return ErrSlowConsumer
+		}
+
+		//block and wait queue space
+		if c.spaceChan == nil {
+			c.spaceChan = make(chan struct{})
+		}
+		spaceChan := c.spaceChan
+		c.sendLocker.Unlock()
+		if timer == nil {
+			timer = time.NewTimer(conf.BlockTimeOut)
+			defer timer.Stop()
+		}
+		select {
+		case <- spaceChan:
+		case <- timer.C:
+			atomic.AddInt64(&c.dropCount, 1)
+			return ErrQueueTimeOut
+		}
+	}
+}
+
+//get error of send closed
+func (c *Connect) getSendErr() error {
+	c.sendLocker.Lock()
+	defer c.sendLocker.Unlock()
+	return c.sendErr
+}
+
+//notify blocked senders, caller should hold send locker
+func (c *Connect) notifyQueueSpace() {
+	if c.spaceChan != nil {
+		close(c.spaceChan)
+		c.spaceChan = nil
+	}
+}
+
+//mark writer quit and notify flush waiters, caller should hold send locker
+func (c *Connect) quitWriter() {
+	c.writing = false
+	if c.idleChan != nil {
+		close(c.idleChan)
+		c.idleChan = nil
+	}
+}
+
+//run write process
+//spawned when queue has data, quit when queue is empty
+func (c *Connect) runWriteProcess() {
+	var (
+		m any = nil
+	)
+
+	//defer
 	defer func() {
-		c.activeTime = time.Now().Unix()
+		if err := recover(); err != m {
+			log.Printf("connect.runWriteProcess panic, err:%v\n", err)
+			c.sendLocker.Lock()
+			c.quitWriter()
+			c.sendLocker.Unlock()
+		}
 	}()
 
-	//init client request
-	req := NewRequest(c, message)
+	//loop
+	for {
+		//pop batch data
+		batch, size, conf := c.popSendBatch(nil, 0, true)
+		if len(batch) <= 0 {
+			return
+		}
 
-	//handle request message
-	err = c.handler.DoMessageHandle(req)
-	return req, err
+		//wait flush window for more data
+		if conf.FlushWindow > 0 && size < conf.FlushBytes {
+			time.Sleep(conf.FlushWindow)
+			batch, _, _ = c.popSendBatch(batch, size, false)
+		}
+
+		//write to connect
+		if err := c.writeData(batch, conf.WriteTimeOut); err != nil {
+			//connect broken, close it
+			c.Quit()
+		}
+	}
 }
 
-//cb for list consumer
-func (c *Connect) cbForConsumer(data interface{}) (interface{}, error) {
-	//check
-	if data == nil {
-		return nil, errors.New("invalid parameter")
+//pop batch data from write queue until flush bytes
+//if queue is empty and need quit, mark writer quit
+func (c *Connect) popSendBatch(
+	batch [][]byte,
+	size int,
+	quitIfEmpty bool) ([][]byte, int, *define.WriteQueueConf) {
+	c.sendLocker.Lock()
+	defer c.sendLocker.Unlock()
+	conf := c.writeConf
+	if len(c.sendQueue) <= 0 {
+		c.sendQueue = nil
+		if quitIfEmpty {
+			c.quitWriter()
+		}
+		return batch, size, conf
 	}
-	dataBytes, ok := data.([]byte)
-	if !ok || dataBytes == nil {
-		return nil, errors.New("data should be `[]byte` type")
+
+	//pop data
+	for len(c.sendQueue) > 0 {
+		data := c.sendQueue[0]
+		if len(batch) > 0 &&
+			(conf.FlushBytes <= 0 || size + len(data) > conf.FlushBytes) {
+			break
+		}
+		batch = append(batch, data)
+		size += len(data)
+		c.sendQueue[0] = nil
+		c.sendQueue = c.sendQueue[1:]
 	}
-	if c.conn == nil {
-		return nil, errors.New("connect is nil")
+	c.notifyQueueSpace()
+	return batch, size, conf
+}
+
+//write batch data with deadline
+//multi data written by one writev
+func (c *Connect) writeData(batch [][]byte, writeTimeOut time.Duration) error {
+	var (
+		err error
+	)
+	c.RLock()
+	conn := c.conn
+	c.RUnlock()
+	if conn == nil {
+		return ErrConnClosed
+	}
+	if writeTimeOut > 0 {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeOut))
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	if len(batch) == 1 {
+		_, err = conn.Write(batch[0])
+	}else{
+		buffers := net.Buffers(batch)
+		_, err = buffers.WriteTo(conn)
+	}
+	return err
+}
+
+//check error is broken connect or not
+func IsBrokenErr(err error) bool {
+	var (
+		netErr net.Error
+	)
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, net.ErrClosed) ||
+		errors.Is(err, ErrConnClosed) ||
+		errors.Is(err, ErrPeerClosed) ||
+		errors.Is(err, ErrSecureFrame) ||
+		errors.Is(err, ErrHelloRejected) ||
+		errors.Is(err, ErrCorruptFrame) {
+		return true
 	}
-	//send to connect
-	_, err := (*c.conn).Write(dataBytes)
-	return nil, err
+	return errors.As(err, &netErr)
 }
\ No newline at end of file
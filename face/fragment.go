@@ -0,0 +1,394 @@
+package face
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for message fragment
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - data over fragment size of codec split into fragment frames
+ * - fragments sent only if peer offered define.CapFragment by hello
+ * - fragment data = kind(4byte) + total(4byte) + offset(4byte) + chunk
+ * - fragments of one message sent in order, not mixed with others
+ * - reassembled with memory budget, or written into stream of router
+ * - stream data buffered up to budget, reader of connect waits router if over
+ * - stream reset with too large error if router not read in time
+ */
+
+//inter macro define
+const (
+	FragmentHeadSize = 12
+)
+
+//inter error define
+var (
+	ErrFragmentLost = errors.New("fragment lost or out of order")
+	ErrStreamClosed = errors.New("stream closed by handler")
+	ErrFragmentUnsupported = errors.New("data over fragment size, fragment not supported by peer")
+)
+
+//kind check result of codec, base codec shared by connects
+var kindCodecs sync.Map
+
+//reassemble fragments of one connect
+//add should be called by one reader
+type Assembler struct {
+	budget    int
+	kind      uint32
+	messageId uint32
+	total     int
+	size      int
+	buff      []byte
+	stream    *FragmentReader //reader of stream router
+	dropped   bool            //over budget, left fragments dropped
+	refused   bool            //stream over budget, error returned
+	active    bool
+	streamLocker sync.Mutex
+}
+
+//reader of fragmented message for stream router
+//buffered data charged against budget, event loop not blocked
+type FragmentReader struct {
+	budget int
+	chunks [][]byte
+	size   int   //buffered data size
+	err    error //returned after buffered data read
+	closed bool  //closed by router, left data dropped
+	cond   *sync.Cond
+	sync.Mutex
+}
+
+//construct
+func NewAssembler(budget int) *Assembler {
+	if budget <= 0 {
+		budget = define.DefaultFragmentBudget
+	}
+	this := &Assembler{
+		budget: budget,
+	}
+	return this
+}
+
+//construct
+func NewFragmentReader(budget int) *FragmentReader {
+	if budget <= 0 {
+		budget = define.DefaultFragmentBudget
+	}
+	this := &FragmentReader{
+		budget: budget,
+	}
+	this.cond = sync.NewCond(&this.Mutex)
+	return this
+}
+
+//get max data size of one fragment frame
+//0 if codec can not carry fragment, like without kind
+func FragmentSize(packet iface.IPacket) int {
+	size := packet.GetMaxPackSize() - define.FragmentReserve
+	if size <= FragmentHeadSize || !CarryKind(packet) {
+		return 0
+	}
+	return size - FragmentHeadSize
+}
+
+//check base codec carry kind or not, result of codec cached
+//request and fragment not supported if not carry kind
+func CarryKind(packet iface.IPacket) bool {
+	if compress, ok := packet.(*CompressPacket); ok {
+		packet = compress.IPacket
+	}
+	if secure, ok := packet.(*SecurePacket); ok {
+		packet = secure.IPacket
+	}
+	if _, ok := packet.(*Packet); ok {
+		return true
+	}
+	if v, ok := kindCodecs.Load(packet); ok {
+		return v.(bool)
+	}
+	hasKind := HasKind(packet)
+	kindCodecs.Store(packet, hasKind)
+	return hasKind
+}
+
+//check data of size can be sent to peer with capabilities
+//ErrFragmentUnsupported if data should be split but peer not support
+func CheckFragment(packet iface.IPacket, caps uint32, size int) error {
+	chunk := FragmentSize(packet)
+	if chunk <= 0 || size <= chunk || caps & define.CapFragment != 0 {
+		return nil
+	}
+	return ErrFragmentUnsupported
+}
+
+//get capabilities of peer, 0 if hello not done
+func PeerCaps(conn iface.IConnect) uint32 {
+	capabilities := conn.GetCapabilities()
+	if capabilities == nil {
+		return 0
+	}
+	return capabilities.Caps
+}
+
+//pack data and append into dst buffer
+//data split into fragments if over fragment size and caps of peer has fragment
+func AppendFrames(
+	packet iface.IPacket,
+	dst []byte,
+	kind uint32,
+	messageId uint32,
+	data []byte,
+	caps uint32) ([]byte, error) {
+	var (
+		err error
+	)
+	message := AcquireMessage()
+	defer ReleaseMessage(message)
+	message.SetId(messageId)
+
+	//pack whole data
+	chunk := FragmentSize(packet)
+	if chunk <= 0 || len(data) <= chunk {
+		message.SetKind(kind)
+		message.SetData(data)
+		return packet.AppendPack(dst, message)
+	}
+	if caps & define.CapFragment == 0 {
+		return dst, ErrFragmentUnsupported
+	}
+
+	//pack fragments in order
+	body := make([]byte, FragmentHeadSize + chunk)
+	binary.BigEndian.PutUint32(body[0:4], kind)
+	binary.BigEndian.PutUint32(body[4:8], uint32(len(data)))
+	message.SetKind(MakeKind(define.KindFragment, 0))
+	for offset := 0; offset < len(data); offset += chunk {
+		end := offset + chunk
+		if end > len(data) {
+			end = len(data)
+		}
+		binary.BigEndian.PutUint32(body[8:12], uint32(offset))
+		size := copy(body[FragmentHeadSize:], data[offset:end])
+		message.SetData(body[:FragmentHeadSize + size])
+		if dst, err = packet.AppendPack(dst, message); err != nil {
+			return dst, err
+		}
+	}
+	return dst, nil
+}
+
+//add one frame, whole message returned after last fragment
+//frame not fragment returned as it is
+//openStream called on first fragment, return false if message should be buffered
+func (f *Assembler) Add(
+	message iface.IMessage,
+	openStream func(iface.IMessage, *FragmentReader) bool) (iface.IMessage, error) {
+	//check
+	if message == nil || KindType(message.GetKind()) != define.KindFragment {
+		return message, nil
+	}
+	data := message.GetData()
+	if len(data) < FragmentHeadSize {
+		return nil, fmt.Errorf("%w, fragment data too short", ErrFragmentLost)
+	}
+	kind := binary.BigEndian.Uint32(data[0:4])
+	total := int(binary.BigEndian.Uint32(data[4:8]))
+	offset := int(binary.BigEndian.Uint32(data[8:12]))
+	chunk := data[FragmentHeadSize:]
+
+	//begin new message on first fragment, message not done dropped
+	if offset == 0 {
+		f.reset(ErrFragmentLost)
+		f.kind = kind
+		f.messageId = message.GetId()
+		f.total = total
+		f.active = true
+		if stream := NewFragmentReader(f.budget); openStream != nil &&
+			openStream(f.newMessage(nil), stream) {
+			f.streamLocker.Lock()
+			f.stream = stream
+			f.streamLocker.Unlock()
+		}
+		f.dropped = f.stream == nil && total > f.budget
+	}
+
+	//check order of fragment
+	if !f.active || offset != f.size || kind != f.kind ||
+		message.GetId() != f.messageId || total != f.total ||
+		offset + len(chunk) > total {
+		f.reset(ErrFragmentLost)
+		return nil, ErrFragmentLost
+	}
+	f.size += len(chunk)
+
+	//write into stream or buffer
+	if f.stream != nil {
+		//data not read by router in time, left fragments dropped
+		if !f.stream.write(chunk) {
+			tips := fmt.Sprintf("stream data buffered over budget %d", f.budget)
+			err := NewFrameError(define.FrameErrTooLarge, tips)
+			f.streamLocker.Lock()
+			f.stream.finish(err)
+			f.stream = nil
+			f.streamLocker.Unlock()
+			f.dropped = true
+			f.refused = true
+			if f.size >= f.total {
+				defer f.reset(nil)
+			}
+			return f.newMessage(nil), err
+		}
+	}else if !f.dropped {
+		f.buff = append(f.buff, chunk...)
+	}
+	if f.size < f.total {
+		return nil, nil
+	}
+
+	//message done
+	defer f.reset(nil)
+	if f.stream != nil || f.refused {
+		return nil, nil
+	}
+	if f.dropped {
+		tips := fmt.Sprintf("message length %d over budget %d", f.total, f.budget)
+		return f.newMessage(nil), NewFrameError(define.FrameErrTooLarge, tips)
+	}
+	return f.newMessage(f.buff), nil
+}
+
+//close stream not done with error, like connect closed
+//left fragments written into closed stream and dropped
+func (f *Assembler) Abort(err error) {
+	f.streamLocker.Lock()
+	defer f.streamLocker.Unlock()
+	if f.stream != nil {
+		f.stream.finish(err)
+	}
+}
+
+//read buffered data, blocked until data written or finished
+func (f *FragmentReader) Read(p []byte) (int, error) {
+	f.Lock()
+	defer f.Unlock()
+	for len(f.chunks) <= 0 && f.err == nil && !f.closed {
+		f.cond.Wait()
+	}
+	if f.closed {
+		return 0, ErrStreamClosed
+	}
+	if len(f.chunks) <= 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.chunks[0])
+	if n < len(f.chunks[0]) {
+		f.chunks[0] = f.chunks[0][n:]
+	}else{
+		f.chunks[0] = nil
+		f.chunks = f.chunks[1:]
+	}
+	f.size -= n
+	f.cond.Broadcast()
+	return n, nil
+}
+
+//close by router, left data dropped
+func (f *FragmentReader) Close() error {
+	f.Lock()
+	defer f.Unlock()
+	f.closed = true
+	f.chunks = nil
+	f.size = 0
+	f.cond.Broadcast()
+	return nil
+}
+
+///////////////
+//private func
+///////////////
+
+//reset state, stream closed with err
+func (f *Assembler) reset(err error) {
+	f.streamLocker.Lock()
+	if f.stream != nil {
+		f.stream.finish(err)
+	}
+	f.stream = nil
+	f.streamLocker.Unlock()
+	f.kind = 0
+	f.messageId = 0
+	f.total = 0
+	f.size = 0
+	f.buff = nil
+	f.dropped = false
+	f.refused = false
+	f.active = false
+}
+
+//init message of current fragments
+func (f *Assembler) newMessage(data []byte) iface.IMessage {
+	message := NewMessage()
+	message.SetKind(f.kind)
+	message.SetId(f.messageId)
+	message.SetData(data)
+	return message
+}
+
+//write chunk, wait router read if buffered data over budget
+//false if not read in time, chunk dropped if closed by router
+func (f *FragmentReader) write(chunk []byte) bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.isFull(chunk) && !f.closed && f.err == nil {
+		expired := false
+		timer := time.AfterFunc(time.Millisecond * define.FragmentStreamWait, func() {
+			f.Lock()
+			defer f.Unlock()
+			expired = true
+			f.cond.Broadcast()
+		})
+		defer timer.Stop()
+		for f.isFull(chunk) && !f.closed && f.err == nil && !expired {
+			f.cond.Wait()
+		}
+	}
+	if f.closed || f.err != nil {
+		return true
+	}
+	if f.isFull(chunk) {
+		return false
+	}
+	f.chunks = append(f.chunks, append([]byte(nil), chunk...))
+	f.size += len(chunk)
+	f.cond.Broadcast()
+	return true
+}
+
+//finish data, err returned after buffered data read, io.EOF if nil
+func (f *FragmentReader) finish(err error) {
+	f.Lock()
+	defer f.Unlock()
+	if err == nil {
+		err = io.EOF
+	}
+	if f.err == nil {
+		f.err = err
+	}
+	f.cond.Broadcast()
+}
+
+//check buffered data over budget with chunk
+//chunk always buffered if empty
+func (f *FragmentReader) isFull(chunk []byte) bool {
+	return f.size > 0 && f.size + len(chunk) > f.budget
+}
@@ -0,0 +1,152 @@
+package face
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for frame kind
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - kind = seq(high 24bit) + compress flag(1bit) + secure flag(1bit) + frame type(low 6bit)
+ * - codec without kind, like varint, only send push frame
+ */
+
+//typed error of error frame
+type FrameError struct {
+	Code      int    `json:"code"`
+	Message   string `json:"message"`
+	MessageId uint32 `json:"-"` //message id of failed request
+}
+
+//construct
+func NewFrameError(code int, message string) *FrameError {
+	this := &FrameError{
+		Code: code,
+		Message: message,
+	}
+	return this
+}
+
+//convert error to typed error, code used if not typed
+func ToFrameError(err error, code int) *FrameError {
+	var (
+		frameErr *FrameError
+	)
+	if errors.As(err, &frameErr) {
+		return frameErr
+	}
+	return NewFrameError(code, err.Error())
+}
+
+//get error string
+func (f *FrameError) Error() string {
+	return f.Message
+}
+
+//encode as data of error frame
+func (f *FrameError) Encode() []byte {
+	data, _ := json.Marshal(f)
+	return data
+}
+
+//data of hello frame
+//magic and version not set by legacy client, only compress negotiated
+type HelloInfo struct {
+	Magic      uint32 `json:"magic,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	App        string `json:"app,omitempty"`
+	AppVersion string `json:"app_version,omitempty"`
+	Caps       uint32 `json:"caps,omitempty"`          //capability bits, negotiated ones in reply
+	MaxPackSize int   `json:"max_pack_size,omitempty"`
+	Endian     string `json:"endian,omitempty"`        //byte order of codec, like define.EndianLittle
+	Compress  []string `json:"compress,omitempty"`   //codecs by preference, selected one in reply
+	Key       []byte   `json:"key,omitempty"`        //x25519 public key for secure channel
+	StaticKey []byte   `json:"static_key,omitempty"` //static public key of server in reply, if set
+}
+
+//get byte order of codec, empty if unknown
+func PacketEndian(packet iface.IPacket) string {
+	endian, ok := packet.(interface{ IsLittleEndian() bool })
+	if !ok {
+		return ""
+	}
+	if endian.IsLittleEndian() {
+		return define.EndianLittle
+	}
+	return define.EndianBig
+}
+
+//make kind by frame type and seq
+func MakeKind(frameType, seq uint32) uint32 {
+	return (seq & define.KindSeqMax) << define.KindSeqShift | frameType & define.KindTypeMask
+}
+
+//get frame type of kind
+func KindType(kind uint32) uint32 {
+	return kind & define.KindTypeMask
+}
+
+//get seq of kind
+func KindSeq(kind uint32) uint32 {
+	return kind >> define.KindSeqShift
+}
+
+//check kind is data frame, like push or request
+//data frame routed to handler, others are control frame
+func IsDataFrame(kind uint32) bool {
+	switch KindType(kind) {
+	case define.KindPush, define.KindRequest:
+		return true
+	default:
+		return false
+	}
+}
+
+//decode typed error from error frame
+func DecodeFrameError(message iface.IMessage) *FrameError {
+	frameErr := &FrameError{}
+	if message == nil {
+		return frameErr
+	}
+	if err := json.Unmarshal(message.GetData(), frameErr); err != nil {
+		//not typed, keep raw data as message
+		frameErr.Code = define.FrameErrInternal
+		frameErr.Message = string(message.GetData())
+	}
+	frameErr.MessageId = message.GetId()
+	return frameErr
+}
+
+//send error as error frame, with message id and seq of request
+//request may be nil if frame decode failed
+func SendFrameError(
+	conn iface.IConnect,
+	req iface.IRequest,
+	errMsgId uint32,
+	err error) error {
+	var (
+		code = define.FrameErrBadFrame
+		messageId = errMsgId
+		seq uint32
+	)
+	//check
+	if conn == nil || err == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//get message id and seq of request
+	if req != nil && req.GetMessage() != nil {
+		code = define.FrameErrInternal
+		messageId = req.GetMessage().GetId()
+		seq = KindSeq(req.GetMessage().GetKind())
+	}
+
+	//send error frame
+	data := ToFrameError(err, code).Encode()
+	return conn.SendFrame(MakeKind(define.KindError, seq), messageId, data)
+}
@@ -1,11 +1,11 @@
 package face
 
 import (
+	"context"
 	"errors"
 	"github.com/andyzhou/cree/define"
 	"github.com/andyzhou/cree/iface"
 	"github.com/andyzhou/tinylib/queue"
-	"io"
 	"log"
 	"math/rand"
 	"runtime"
@@ -18,8 +18,16 @@ import (
  * dynamic connect group
  * - dynamic create temp groups
  * - inter connections are all references
+ * - group messages dispatched by connect owner bucket,
+ *   read ticker polling is legacy mode only
  */
 
+//packed message of group
+type groupData struct {
+	byteData []byte
+	size     int //size of message data, check fragment of peer
+}
+
 //face info
 type Group struct {
 	groupId       int64
@@ -28,8 +36,10 @@ type Group struct {
 	packet        iface.IPacket            //packet interface
 	readMsgTicker *queue.Ticker            //ticker for read connect msg
 	readMsgRate   float64
-	sendChan      chan []byte
+	sendChan      chan groupData
 	closeChan     chan bool
+	doneChan      chan bool //closed when send process quit
+	closeOnce     sync.Once
 
 	//cb func
 	cbForReadMessage  func(int64, iface.IConnect, iface.IRequest) error
@@ -38,6 +48,7 @@ type Group struct {
 }
 
 //construct
+//if read msg rate > 0, use legacy ticker polling read mode
 func NewGroup(groupId int64, readMsgRates ...float64) *Group {
 	var (
 		readMsgRate float64
@@ -51,14 +62,16 @@ func NewGroup(groupId int64, readMsgRates ...float64) *Group {
 		readMsgRate: readMsgRate,
 		packet: NewPacket(),
 		connMap: map[int64]iface.IConnect{},
-		sendChan: make(chan []byte, define.DefaultSmallChanSize),
-		closeChan: make(chan bool, 1),
+		sendChan: make(chan groupData, define.DefaultSmallChanSize),
+		closeChan: make(chan bool),
+		doneChan: make(chan bool),
 	}
 	this.interInit()
 	return this
 }
 
 //clear
+//queued messages sent before send process quit
 func (f *Group) Clear() {
 	//stop read ticker
 	if f.readMsgTicker != nil {
@@ -66,6 +79,12 @@ func (f *Group) Clear() {
 		f.readMsgTicker = nil
 	}
 
+	//stop send process and wait
+	f.closeOnce.Do(func() {
+		close(f.closeChan)
+	})
+	<- f.doneChan
+
 	//inter data clean up
 	f.Lock()
 	defer f.Unlock()
@@ -113,7 +132,7 @@ func (f *Group) Quit(connections ...iface.IConnect) error {
 //send message to all
 func (f *Group) SendMessage(msgId uint32, msg []byte) error {
 	//check
-	if msgId <= 0 || msg == nil || len(msg) <= 0 {
+	if msg == nil || len(msg) <= 0 {
 		return errors.New("invalid parameter")
 	}
 
@@ -124,10 +143,55 @@ func (f *Group) SendMessage(msgId uint32, msg []byte) error {
 	}
 
 	//send to chan
-	f.sendChan <- byteData
+	select {
+	case f.sendChan <- groupData{byteData: byteData, size: len(msg)}:
+	case <- f.closeChan:
+		return errors.New("group is cleared")
+	}
 	return err
 }
 
+//send request to all members and gather replies until ctx done
+//replies and errors of members returned by connect id
+//member not replied before ctx done got ErrRequestTimeOut
+func (f *Group) Request(
+	ctx context.Context,
+	msgId uint32,
+	msg []byte) (map[int64]iface.IMessage, map[int64]error) {
+	var (
+		wg sync.WaitGroup
+		locker sync.Mutex
+	)
+	replies := map[int64]iface.IMessage{}
+	errs := map[int64]error{}
+
+	//get members
+	f.RLock()
+	members := make([]iface.IConnect, 0, len(f.connMap))
+	for _, conn := range f.connMap {
+		members = append(members, conn)
+	}
+	f.RUnlock()
+
+	//scatter and gather
+	for _, conn := range members {
+		wg.Add(1)
+		go func(conn iface.IConnect) {
+			defer wg.Done()
+			reply, err := conn.Request(ctx, msgId, msg)
+			locker.Lock()
+			defer locker.Unlock()
+			if err != nil {
+				errs[conn.GetConnId()] = err
+				return
+			}
+			replies[conn.GetConnId()] = reply
+		}(conn)
+	}
+	wg.Wait()
+	return replies, errs
+}
+
 //join group
 func (f *Group) Join(conn iface.IConnect) error {
 	//check
@@ -143,11 +207,31 @@ func (f *Group) Join(conn iface.IConnect) error {
 	return nil
 }
 
+//handle message read from group member
+func (f *Group) HandleMessage(conn iface.IConnect, req iface.IRequest) error {
+	//check
+	if conn == nil || req == nil {
+		return errors.New("invalid parameter")
+	}
+	if f.cbForReadMessage == nil {
+		return errors.New("no read message cb")
+	}
+	return f.cbForReadMessage(f.groupId, conn, req)
+}
+
 //set error message id
 func (f *Group) SetErrMsgId(msgId uint32) {
 	f.errMsgId = msgId
 }
 
+//set packet codec, should be called before send
+func (f *Group) SetPacket(packet iface.IPacket) {
+	if packet == nil {
+		return
+	}
+	f.packet = packet
+}
+
 //set cb for read message
 func (f *Group) SetCBForReadMessage(cb func(int64, iface.IConnect, iface.IRequest) error) {
 	f.cbForReadMessage = cb
@@ -223,7 +307,7 @@ func (f *Group) cbForReadConnData(inputs ...interface{}) error {
 		//read message
 		req, err = conn.ReadMessage()
 		if err != nil {
-			if err == io.EOF {
+			if IsBrokenErr(err) {
 				//io read failed
 				//close connect and remove it
 				f.closeConn(conn)
@@ -232,9 +316,14 @@ func (f *Group) cbForReadConnData(inputs ...interface{}) error {
 				log.Printf("group %v read conn %v data failed, err:%v\n",
 					f.groupId, conn.GetConnId(), err.Error())
 
-				//send error to client connect
-				conn.SendMessage(f.errMsgId, []byte(err.Error()))
+				//send error frame to client connect
+				SendFrameError(conn, req, f.errMsgId, err)
 			}
+			ReleaseRequest(req)
+			continue
+		}
+		if !IsDataFrame(req.GetMessage().GetKind()) {
+			ReleaseRequest(req)
 			continue
 		}
 
@@ -242,20 +331,16 @@ func (f *Group) cbForReadConnData(inputs ...interface{}) error {
 		if f.cbForReadMessage != nil {
 			f.cbForReadMessage(f.groupId, conn, req)
 		}
+		ReleaseRequest(req)
 	}
 	return nil
 }
 
 //pack message
+//large data split into fragments, sent to connects support fragment
 func (f *Group) packMessage(messageId uint32, data []byte) ([]byte, error) {
-	//create message
-	message := NewMessage()
-	message.SetId(messageId)
-	message.SetData(data)
-
-	//create message packet
-	byteData, err := f.packet.Pack(message)
-	return byteData, err
+	//create message packet, large data split into fragments
+	return AppendFrames(f.packet, nil, define.KindPush, messageId, data, define.CapFragment)
 }
 
 //init read message ticker
@@ -266,9 +351,9 @@ func (f *Group) initReadMsgTicker() {
 }
 
 //send real data
-func (f *Group) sendRealData(byteData []byte) {
+func (f *Group) sendRealData(data groupData) {
 	//check
-	if byteData == nil || len(f.connMap) <= 0 {
+	if data.byteData == nil || len(f.connMap) <= 0 {
 		return
 	}
 
@@ -276,14 +361,17 @@ func (f *Group) sendRealData(byteData []byte) {
 	f.Lock()
 	defer f.Unlock()
 	for _, conn := range f.connMap {
-		conn.SendData(byteData)
+		if CheckFragment(f.packet, PeerCaps(conn), data.size) != nil {
+			continue
+		}
+		conn.SendData(data.byteData)
 	}
 }
 
 //run send process
 func (f *Group) runSendProcess() {
 	var (
-		data []byte
+		data groupData
 		ok bool
 		m  any = nil
 	)
@@ -292,29 +380,36 @@ func (f *Group) runSendProcess() {
 		if err := recover(); err != m {
 			log.Printf("group.runSendProcess panic, err:%v\n", err)
 		}
-		close(f.sendChan)
+		close(f.doneChan)
 	}()
 
 	//loop
 	for {
 		select {
 		case data, ok = <- f.sendChan:
-			if ok && data != nil {
+			if ok {
 				f.sendRealData(data)
 			}
 		case <- f.closeChan:
-			return
+			//send left messages
+			for {
+				select {
+				case data = <- f.sendChan:
+					f.sendRealData(data)
+				default:
+					return
+				}
+			}
 		}
 	}
 }
 
 //inter init
 func (f *Group) interInit() {
-	//check and set read message rate
-	if f.readMsgRate <= 0 {
-		f.readMsgRate = define.DefaultBucketReadRate
+	//init read message ticker for legacy mode
+	if f.readMsgRate > 0 {
+		f.initReadMsgTicker()
 	}
-	f.initReadMsgTicker()
 
 	//run send process
 	go f.runSendProcess()
@@ -1,10 +1,15 @@
 package face
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
+	"log"
 	"sync"
+	"sync/atomic"
 
+	"github.com/andyzhou/cree/define"
 	"github.com/andyzhou/cree/iface"
 )
 
@@ -12,12 +17,26 @@ import (
  * face for message handler
  * @author <AndyZhou>
  * @mail <diudiu8848@163.com>
+ * - ping answered, response routed to waiter
+ * - hello handled by cb, like compress negotiation
+ * - push and request routed by message id
+ * - stream router read data as stream, large message read by fragments
  */
 
+//waiter key
+type waiterKey struct {
+	connId int64
+	seq    uint32
+}
+
 //face info
 type Handler struct {
 	redirectRouter iface.IRouter
 	handlerMap     map[uint32]iface.IRouter //msgId -> iRouter
+	waiterMap      map[waiterKey]chan iface.IMessage
+	cbForHello     func(iface.IConnect, iface.IRequest) error
+	seq            uint32
+	waiterLocker   sync.Mutex
 	sync.RWMutex
 }
 
@@ -26,14 +45,43 @@ func NewHandler() *Handler {
 	//self init
 	this := &Handler{
 		handlerMap: map[uint32]iface.IRouter{},
+		waiterMap: map[waiterKey]chan iface.IMessage{},
 	}
 	return this
 }
 
 //message handle
 func (f *Handler) DoMessageHandle(req iface.IRequest) error {
+	//check frame type
+	message := req.GetMessage()
+	switch KindType(message.GetKind()) {
+	case define.KindPing:
+		//answer pong with same seq and data
+		kind := MakeKind(define.KindPong, KindSeq(message.GetKind()))
+		data := message.GetData()
+		if data == nil {
+			data = []byte{}
+		}
+		return req.GetConnect().SendFrame(kind, message.GetId(), data)
+	case define.KindResponse, define.KindError, define.KindPong, define.KindAck:
+		//route to waiter, drop if waiter gone
+		f.NotifyWaiter(req.GetConnect().GetConnId(), message)
+		return nil
+	case define.KindClose:
+		return ErrPeerClosed
+	case define.KindHello:
+		//reply sent by cb, error sent as error frame
+		f.RLock()
+		cb := f.cbForHello
+		f.RUnlock()
+		if cb == nil {
+			return NewFrameError(define.FrameErrNoHandler, "hello not supported")
+		}
+		return cb(req.GetConnect(), req)
+	}
+
 	//get relate handler by message id
-	messageId := req.GetMessage().GetId()
+	messageId := message.GetId()
 	router := f.getRouter(messageId)
 	if router == nil {
 		//check redirect router
@@ -45,16 +93,36 @@ func (f *Handler) DoMessageHandle(req iface.IRequest) error {
 			return nil
 		}
 		tips := fmt.Sprintf("no handler for message id:%d", messageId)
-		return errors.New(tips)
+		return NewFrameError(define.FrameErrNoHandler, tips)
 	}
 
 	//call relate handle
 	router.PreHandle(req)
-	router.Handle(req)
+	if streamRouter, ok := router.(iface.IStreamRouter); ok {
+		streamRouter.HandleStream(req, bytes.NewReader(message.GetData()))
+	}else{
+		router.Handle(req)
+	}
 	router.PostHandle(req)
 	return nil
 }
 
+//open stream of fragmented request, if router is stream router
+//stream router run in background, data buffered by connect reader
+//return false if no stream router
+func (f *Handler) OpenStream(req iface.IRequest, reader io.ReadCloser) bool {
+	//check
+	if req == nil || req.GetMessage() == nil || reader == nil {
+		return false
+	}
+	router, ok := f.getRouter(req.GetMessage().GetId()).(iface.IStreamRouter)
+	if !ok {
+		return false
+	}
+	go f.handleStream(router, req, reader)
+	return true
+}
+
 //remove router
 func (f *Handler) RemoveRouter(messageId uint32) error {
 	//check
@@ -72,26 +140,94 @@ func (f *Handler) RemoveRouter(messageId uint32) error {
 	return nil
 }
 
-//add router
+//add router, error if router of message id exists
 func (f *Handler) AddRouter(messageId uint32, router iface.IRouter) error {
 	//basic check
 	if messageId <= 0 || router == nil {
 		return errors.New("invalid parameter")
 	}
 
-	//check
-	oldRouter := f.getRouter(messageId)
-	if oldRouter != nil {
-		return nil
-	}
-
-	//add into map
+	//add into map, old router kept
 	f.Lock()
 	defer f.Unlock()
+	if oldRouter, ok := f.handlerMap[messageId]; ok && oldRouter != nil {
+		return fmt.Errorf("router of message id %d exists", messageId)
+	}
 	f.handlerMap[messageId] = router
 	return nil
 }
 
+//set cb for hello frame, cb should send reply
+func (f *Handler) SetCBForHello(cb func(iface.IConnect, iface.IRequest) error) {
+	f.Lock()
+	defer f.Unlock()
+	f.cbForHello = cb
+}
+
+//get next seq of request, skip 0
+func (f *Handler) NextSeq() uint32 {
+	for {
+		seq := atomic.AddUint32(&f.seq, 1) & define.KindSeqMax
+		if seq > 0 {
+			return seq
+		}
+	}
+}
+
+//add waiter for response of connect and seq
+//response, error, pong or ack frame with same seq sent into chan
+func (f *Handler) AddWaiter(connId int64, seq uint32) (<-chan iface.IMessage, error) {
+	//check
+	if seq <= 0 {
+		return nil, errors.New("invalid parameter")
+	}
+
+	//add with locker
+	key := waiterKey{connId: connId, seq: seq}
+	f.waiterLocker.Lock()
+	defer f.waiterLocker.Unlock()
+	if _, ok := f.waiterMap[key]; ok {
+		return nil, errors.New("waiter of seq already exists")
+	}
+	waiter := make(chan iface.IMessage, 1)
+	f.waiterMap[key] = waiter
+	return waiter, nil
+}
+
+//remove waiter, like wait time out
+func (f *Handler) RemoveWaiter(connId int64, seq uint32) {
+	key := waiterKey{connId: connId, seq: seq}
+	f.waiterLocker.Lock()
+	defer f.waiterLocker.Unlock()
+	delete(f.waiterMap, key)
+}
+
+//close all waiters of connect, like connect closed
+func (f *Handler) CloseWaiters(connId int64) {
+	f.waiterLocker.Lock()
+	defer f.waiterLocker.Unlock()
+	for k, v := range f.waiterMap {
+		if k.connId == connId {
+			close(v)
+			delete(f.waiterMap, k)
+		}
+	}
+}
+
+//send message to waiter of seq and remove it
+//return false if no waiter, like wait time out
+func (f *Handler) NotifyWaiter(connId int64, message iface.IMessage) bool {
+	key := waiterKey{connId: connId, seq: KindSeq(message.GetKind())}
+	f.waiterLocker.Lock()
+	waiter, ok := f.waiterMap[key]
+	delete(f.waiterMap, key)
+	f.waiterLocker.Unlock()
+	if ok {
+		waiter <- message
+	}
+	return ok
+}
+
 //register redirect for unsupported message id
 //used for all requests redirect to handler
 func (f *Handler) RegisterRedirect(router iface.IRouter) error {
@@ -108,6 +244,25 @@ func (f *Handler) RegisterRedirect(router iface.IRouter) error {
 //private func
 ///////////////
 
+//run stream router, left data dropped after return
+func (f *Handler) handleStream(
+	router iface.IStreamRouter,
+	req iface.IRequest,
+	reader io.ReadCloser) {
+	var (
+		m any = nil
+	)
+	defer func() {
+		if err := recover(); err != m {
+			log.Printf("handler.handleStream panic, err:%v\n", err)
+		}
+		reader.Close()
+	}()
+	router.PreHandle(req)
+	router.HandleStream(req, reader)
+	router.PostHandle(req)
+}
+
 //get router of message id
 func (f *Handler) getRouter(msgId uint32) iface.IRouter {
 	if msgId < 0 {
@@ -12,6 +12,7 @@ type Message struct {
 	Kind uint32
 	Id   uint32
 	Data []byte
+	buff []byte //body buffer of decoded data, kept in pool
 }
 
 //construct
@@ -1,10 +1,12 @@
 package face
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
+	"sync"
+	"sync/atomic"
 
 	"github.com/andyzhou/cree/define"
 	"github.com/andyzhou/cree/iface"
@@ -14,42 +16,111 @@ import (
  * face for data packet
  * @author <AndyZhou>
  * @mail <diudiu8848@163.com>
+ * - default codec with fixed 12 bytes header
+ * - checksum header variant with crc32c of header and body, 16 bytes
+ * - codecs registered by name, selected by server and client conf
  */
 
 //inter macro define
 //DO NOT CHANGE THIS!!!
 const (
 	PacketHeadSize = 12 //dataLen(4byte) + messageKind(4byte) + messageId(4byte)
+	PacketCheckHeadSize = 16 //header + crc32c(4byte) of header and data
 )
 
-//face info
-type Packet struct {
+//inter error define
+var (
+	ErrPacketTooLarge = errors.New("too large message data received")
+	ErrCorruptFrame   = errors.New("corrupt frame received, checksum mismatch")
+)
+
+//crc32c table of checksum header
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+//registered codecs
+var (
+	packetCreators = map[string]func() iface.IPacket{
+		define.PacketDefault: func() iface.IPacket { return NewPacket() },
+		define.PacketCompact: func() iface.IPacket { return NewCompactPacket() },
+		define.PacketVarint: func() iface.IPacket { return NewVarintPacket() },
+		define.PacketLength: func() iface.IPacket { return NewLengthPacket() },
+		define.PacketDelimiter: func() iface.IPacket { return NewDelimiterPacket(nil) },
+	}
+	packetLocker sync.RWMutex
+)
+
+//general config of codec
+type packetConf struct {
 	maxPackSize  int
 	littleEndian bool
-	byteOrder    binary.ByteOrder
+	byteOrder    byteOrder
+}
+
+//byte order with append api
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+//face info
+type Packet struct {
+	packetConf
+	checksum     bool  //header with crc32c or not
+	corruptCount int64 //frames of checksum mismatch
 }
 
  //construct
 func NewPacket() *Packet {
 	//self init
 	this := &Packet{
-		maxPackSize: define.PacketMaxSize,
-		littleEndian: true,
-		byteOrder: binary.LittleEndian,
+		packetConf: newPacketConf(),
 	}
 	return this
 }
 
+//register codec creator by name, replace old one
+func RegisterPacket(name string, creator func() iface.IPacket) error {
+	//check
+	if name == "" || creator == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//sync with locker
+	packetLocker.Lock()
+	defer packetLocker.Unlock()
+	packetCreators[name] = creator
+	return nil
+}
+
+//create codec by name, default codec if name is empty
+func CreatePacket(name string) (iface.IPacket, error) {
+	if name == "" {
+		name = define.PacketDefault
+	}
+	packetLocker.RLock()
+	creator, ok := packetCreators[name]
+	packetLocker.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no such packet codec %v", name)
+	}
+	return creator(), nil
+}
+
 //set max pack size
-func (f *Packet) SetMaxPackSize(size int) {
+func (f *packetConf) SetMaxPackSize(size int) {
 	if size <= 0 {
 		return
 	}
 	f.maxPackSize = size
 }
 
+//get max pack size
+func (f *packetConf) GetMaxPackSize() int {
+	return f.maxPackSize
+}
+
 //set big or little endian
-func (f *Packet) SetLittleEndian(littleEndian bool) {
+func (f *packetConf) SetLittleEndian(littleEndian bool) {
 	f.littleEndian = littleEndian
 	if littleEndian {
 		f.byteOrder = binary.LittleEndian
@@ -58,100 +129,162 @@ func (f *Packet) SetLittleEndian(littleEndian bool) {
 	}
 }
 
-//unpack data, just for message length and id from header
-func (f *Packet) UnPack(data []byte) (iface.IMessage, error) {
-	var (
-		messageKind uint32
-		messageId uint32
-		messageLen uint32
-		err error
-	)
+//set checksum header or not, should be same as peer
+func (f *Packet) SetChecksum(checksum bool) {
+	f.checksum = checksum
+}
 
-	//basic check
-	if data == nil || len(data) <= 0 {
-		return nil, errors.New("invalid parameter")
-	}
+//get count of corrupt frames
+func (f *Packet) GetCorruptCount() int64 {
+	return atomic.LoadInt64(&f.corruptCount)
+}
 
-	//init data buff
-	dataBuff := bytes.NewReader(data)
+//check little endian or not
+func (f *packetConf) IsLittleEndian() bool {
+	return f.littleEndian
+}
 
-	//read length
-	err = binary.Read(dataBuff, f.byteOrder, &messageLen)
+//unpack data, just for message length and id from header
+//checksum verified if data has whole frame
+func (f *Packet) UnPack(data []byte) (iface.IMessage, error) {
+	message := NewMessage()
+	err := f.UnPackTo(data, message)
 	if err != nil {
 		return nil, err
 	}
+	return message, nil
+}
 
-	//read message kind
-	err = binary.Read(dataBuff, f.byteOrder, &messageKind)
-	if err != nil {
-		return nil, err
+//unpack header data into given message
+func (f *Packet) UnPackTo(data []byte, message iface.IMessage) error {
+	//basic check
+	headLen := int(f.GetHeadLen())
+	if len(data) < headLen || message == nil {
+		return errors.New("invalid parameter")
 	}
 
-	//read message id
-	err = binary.Read(dataBuff, f.byteOrder, &messageId)
-	if err != nil {
-		return nil, err
-	}
+	//read length, kind and id
+	messageLen := f.byteOrder.Uint32(data[0:4])
+	messageKind := f.byteOrder.Uint32(data[4:8])
+	messageId := f.byteOrder.Uint32(data[8:12])
 
-	//read data
+	//check data length
 	if messageLen > uint32(f.maxPackSize) {
-		tips := fmt.Sprintf("too large message data received, message length:%d", messageLen)
-		return nil, errors.New(tips)
+		return fmt.Errorf("%w, message length:%d", ErrPacketTooLarge, messageLen)
 	}
 
-	//init message data
-	message := NewMessage()
+	//verify checksum of whole frame
+	if f.checksum && len(data) >= headLen + int(messageLen) {
+		if err := f.verify(data[:headLen + int(messageLen)]); err != nil {
+			return err
+		}
+	}
+
+	//sync message data
 	message.SetKind(messageKind)
 	message.SetId(messageId)
 	message.SetLen(messageLen)
+	return nil
+}
 
-	return message, nil
+//decode one message from head of data
+func (f *Packet) Decode(data []byte) (iface.IMessage, int, error) {
+	headLen := int(f.GetHeadLen())
+	if len(data) < headLen {
+		return nil, 0, nil
+	}
+	message := AcquireMessage()
+	if err := f.UnPackTo(data, message); err != nil {
+		ReleaseMessage(message)
+		return nil, 0, err
+	}
+	return decodeBody(message, data, headLen)
 }
 
 //pack data
 func (f *Packet) Pack(message iface.IMessage) ([]byte, error) {
-	var (
-		err error
-	)
-
 	//basic check
 	if message == nil {
 		return nil, errors.New("invalid parameter")
 	}
+	dst := make([]byte, 0, int(f.GetHeadLen()) + len(message.GetData()))
+	return f.AppendPack(dst, message)
+}
 
-	//init data buff
-	dataBuff := bytes.NewBuffer(nil)
-
-	//write length
-	err = binary.Write(dataBuff, f.byteOrder, message.GetLen())
-	if err != nil {
-		return nil, err
-	}
-
-	//write kind
-	err = binary.Write(dataBuff, f.byteOrder, message.GetKind())
-	if err != nil {
-		return nil, err
+//pack data and append into dst buffer
+//if dst has enough capacity, no memory allocated
+func (f *Packet) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	//basic check
+	if message == nil {
+		return dst, errors.New("invalid parameter")
 	}
+	data := message.GetData()
 
-	//write message id
-	err = binary.Write(dataBuff, f.byteOrder, message.GetId())
-	if err != nil {
-		return nil, err
-	}
+	//write header
+	offset := len(dst)
+	dst = append(dst, make([]byte, f.GetHeadLen())...)
+	header := dst[offset:]
+	f.byteOrder.PutUint32(header[0:4], uint32(len(data)))
+	f.byteOrder.PutUint32(header[4:8], message.GetKind())
+	f.byteOrder.PutUint32(header[8:12], message.GetId())
 
 	//write data
-	err = binary.Write(dataBuff, f.byteOrder, message.GetData())
-	if err != nil {
-		return nil, err
-	}
+	dst = append(dst, data...)
 
-	return dataBuff.Bytes(), nil
+	//write checksum of header and data
+	if f.checksum {
+		frame := dst[offset:]
+		sum := crc32.Update(crc32.Checksum(frame[:PacketHeadSize], crcTable), crcTable, frame[PacketCheckHeadSize:])
+		f.byteOrder.PutUint32(frame[PacketHeadSize:PacketCheckHeadSize], sum)
+	}
+	return dst, nil
 }
 
 //get length
 func (f *Packet) GetHeadLen() uint32 {
+	if f.checksum {
+		return PacketCheckHeadSize
+	}
 	return PacketHeadSize
 }
 
+///////////////
+//private func
+///////////////
 
+//init default codec config
+func newPacketConf() packetConf {
+	return packetConf{
+		maxPackSize: define.PacketMaxSize,
+		littleEndian: true,
+		byteOrder: binary.LittleEndian,
+	}
+}
+
+//verify checksum of whole frame, counted if mismatch
+func (f *Packet) verify(frame []byte) error {
+	sum := crc32.Update(crc32.Checksum(frame[:PacketHeadSize], crcTable), crcTable, frame[PacketCheckHeadSize:])
+	if sum != f.byteOrder.Uint32(frame[PacketHeadSize:PacketCheckHeadSize]) {
+		atomic.AddInt64(&f.corruptCount, 1)
+		return ErrCorruptFrame
+	}
+	return nil
+}
+
+//copy body of message after header into buffer of pooled message
+//return 0 if body not enough, message released
+func decodeBody(
+	message *Message,
+	data []byte,
+	headLen int) (iface.IMessage, int, error) {
+	size := headLen + int(message.GetLen())
+	if len(data) < size {
+		ReleaseMessage(message)
+		return nil, 0, nil
+	}
+	if size > headLen {
+		message.buff = append(message.buff[:0], data[headLen:size]...)
+		message.SetData(message.buff)
+	}
+	return message, size, nil
+}
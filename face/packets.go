@@ -0,0 +1,345 @@
+package face
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for built-in packet codecs
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - compact: dataLen(2byte) + messageId(2byte)
+ * - varint: uvarint dataLen
+ * - length: dataLen(4byte)
+ * - delimiter: data end with delimiter, like \n
+ * - message id of codec without id is 0, handled by redirect router
+ */
+
+//inter macro define
+const (
+	CompactHeadSize = 4
+	LengthHeadSize  = 4
+)
+
+//compact codec
+type CompactPacket struct {
+	packetConf
+}
+
+//uvarint length codec
+type VarintPacket struct {
+	packetConf
+}
+
+//length only codec
+type LengthPacket struct {
+	packetConf
+}
+
+//delimiter codec
+type DelimiterPacket struct {
+	packetConf
+	delimiter []byte
+}
+
+//construct
+func NewCompactPacket() *CompactPacket {
+	this := &CompactPacket{
+		packetConf: newPacketConf(),
+	}
+	return this
+}
+
+func NewVarintPacket() *VarintPacket {
+	this := &VarintPacket{
+		packetConf: newPacketConf(),
+	}
+	return this
+}
+
+func NewLengthPacket() *LengthPacket {
+	this := &LengthPacket{
+		packetConf: newPacketConf(),
+	}
+	return this
+}
+
+//default delimiter is \n
+func NewDelimiterPacket(delimiter []byte) *DelimiterPacket {
+	if len(delimiter) <= 0 {
+		delimiter = []byte("\n")
+	}
+	this := &DelimiterPacket{
+		packetConf: newPacketConf(),
+		delimiter: delimiter,
+	}
+	return this
+}
+
+///////////////////
+//api for compact
+///////////////////
+
+func (f *CompactPacket) UnPack(data []byte) (iface.IMessage, error) {
+	message := NewMessage()
+	if err := f.UnPackTo(data, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (f *CompactPacket) UnPackTo(data []byte, message iface.IMessage) error {
+	//basic check
+	if len(data) < CompactHeadSize || message == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//read length and id
+	messageLen := uint32(f.byteOrder.Uint16(data[0:2]))
+	if messageLen > uint32(f.maxPackSize) {
+		return fmt.Errorf("%w, message length:%d", ErrPacketTooLarge, messageLen)
+	}
+	message.SetKind(0)
+	message.SetId(uint32(f.byteOrder.Uint16(data[2:4])))
+	message.SetLen(messageLen)
+	return nil
+}
+
+func (f *CompactPacket) Decode(data []byte) (iface.IMessage, int, error) {
+	if len(data) < CompactHeadSize {
+		return nil, 0, nil
+	}
+	message := AcquireMessage()
+	if err := f.UnPackTo(data, message); err != nil {
+		ReleaseMessage(message)
+		return nil, 0, err
+	}
+	return decodeBody(message, data, CompactHeadSize)
+}
+
+func (f *CompactPacket) Pack(message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	dst := make([]byte, 0, CompactHeadSize + len(message.GetData()))
+	return f.AppendPack(dst, message)
+}
+
+func (f *CompactPacket) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	//basic check
+	if message == nil {
+		return dst, errors.New("invalid parameter")
+	}
+	data := message.GetData()
+	if len(data) > 0xFFFF || message.GetId() > 0xFFFF {
+		return dst, errors.New("data length or message id out of compact range")
+	}
+
+	//write header and data
+	dst = f.byteOrder.AppendUint16(dst, uint16(len(data)))
+	dst = f.byteOrder.AppendUint16(dst, uint16(message.GetId()))
+	dst = append(dst, data...)
+	return dst, nil
+}
+
+func (f *CompactPacket) GetHeadLen() uint32 {
+	return CompactHeadSize
+}
+
+//////////////////
+//api for varint
+//////////////////
+
+//unpack header, data should begin with uvarint length
+func (f *VarintPacket) UnPack(data []byte) (iface.IMessage, error) {
+	message := NewMessage()
+	if err := f.UnPackTo(data, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (f *VarintPacket) UnPackTo(data []byte, message iface.IMessage) error {
+	if message == nil {
+		return errors.New("invalid parameter")
+	}
+	_, err := f.unPackHeader(data, message)
+	return err
+}
+
+func (f *VarintPacket) Decode(data []byte) (iface.IMessage, int, error) {
+	message := AcquireMessage()
+	headLen, err := f.unPackHeader(data, message)
+	if err != nil || headLen <= 0 {
+		ReleaseMessage(message)
+		return nil, 0, err
+	}
+	return decodeBody(message, data, headLen)
+}
+
+func (f *VarintPacket) Pack(message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	dst := make([]byte, 0, binary.MaxVarintLen32 + len(message.GetData()))
+	return f.AppendPack(dst, message)
+}
+
+func (f *VarintPacket) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return dst, errors.New("invalid parameter")
+	}
+	data := message.GetData()
+	dst = binary.AppendUvarint(dst, uint64(len(data)))
+	dst = append(dst, data...)
+	return dst, nil
+}
+
+//header not fixed
+func (f *VarintPacket) GetHeadLen() uint32 {
+	return 0
+}
+
+//unpack uvarint length, return header size, 0 if data not enough
+func (f *VarintPacket) unPackHeader(data []byte, message iface.IMessage) (int, error) {
+	messageLen, n := binary.Uvarint(data)
+	if n < 0 || (n == 0 && len(data) >= binary.MaxVarintLen64) {
+		return 0, errors.New("invalid uvarint length")
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	if messageLen > uint64(f.maxPackSize) {
+		return 0, fmt.Errorf("%w, message length:%d", ErrPacketTooLarge, messageLen)
+	}
+	message.SetKind(0)
+	message.SetId(0)
+	message.SetLen(uint32(messageLen))
+	return n, nil
+}
+
+//////////////////
+//api for length
+//////////////////
+
+func (f *LengthPacket) UnPack(data []byte) (iface.IMessage, error) {
+	message := NewMessage()
+	if err := f.UnPackTo(data, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+func (f *LengthPacket) UnPackTo(data []byte, message iface.IMessage) error {
+	//basic check
+	if len(data) < LengthHeadSize || message == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//read length
+	messageLen := f.byteOrder.Uint32(data[0:4])
+	if messageLen > uint32(f.maxPackSize) {
+		return fmt.Errorf("%w, message length:%d", ErrPacketTooLarge, messageLen)
+	}
+	message.SetKind(0)
+	message.SetId(0)
+	message.SetLen(messageLen)
+	return nil
+}
+
+func (f *LengthPacket) Decode(data []byte) (iface.IMessage, int, error) {
+	if len(data) < LengthHeadSize {
+		return nil, 0, nil
+	}
+	message := AcquireMessage()
+	if err := f.UnPackTo(data, message); err != nil {
+		ReleaseMessage(message)
+		return nil, 0, err
+	}
+	return decodeBody(message, data, LengthHeadSize)
+}
+
+func (f *LengthPacket) Pack(message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	dst := make([]byte, 0, LengthHeadSize + len(message.GetData()))
+	return f.AppendPack(dst, message)
+}
+
+func (f *LengthPacket) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return dst, errors.New("invalid parameter")
+	}
+	data := message.GetData()
+	dst = f.byteOrder.AppendUint32(dst, uint32(len(data)))
+	dst = append(dst, data...)
+	return dst, nil
+}
+
+func (f *LengthPacket) GetHeadLen() uint32 {
+	return LengthHeadSize
+}
+
+/////////////////////
+//api for delimiter
+/////////////////////
+
+//no header, unpack not supported
+func (f *DelimiterPacket) UnPack(data []byte) (iface.IMessage, error) {
+	return nil, errors.New("delimiter packet has no header")
+}
+
+func (f *DelimiterPacket) UnPackTo(data []byte, message iface.IMessage) error {
+	return errors.New("delimiter packet has no header")
+}
+
+//decode data before delimiter
+func (f *DelimiterPacket) Decode(data []byte) (iface.IMessage, int, error) {
+	idx := bytes.Index(data, f.delimiter)
+	if idx < 0 {
+		if len(data) > f.maxPackSize + len(f.delimiter) {
+			return nil, 0, fmt.Errorf("%w, no delimiter in %d bytes", ErrPacketTooLarge, len(data))
+		}
+		return nil, 0, nil
+	}
+	if idx > f.maxPackSize {
+		return nil, 0, fmt.Errorf("%w, message length:%d", ErrPacketTooLarge, idx)
+	}
+	message := AcquireMessage()
+	message.SetLen(uint32(idx))
+	decoded, _, _ := decodeBody(message, data, 0)
+	return decoded, idx + len(f.delimiter), nil
+}
+
+func (f *DelimiterPacket) Pack(message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	dst := make([]byte, 0, len(message.GetData()) + len(f.delimiter))
+	return f.AppendPack(dst, message)
+}
+
+//data should not contain delimiter
+func (f *DelimiterPacket) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	if message == nil {
+		return dst, errors.New("invalid parameter")
+	}
+	data := message.GetData()
+	if bytes.Contains(data, f.delimiter) {
+		return dst, errors.New("data contains delimiter")
+	}
+	dst = append(dst, data...)
+	dst = append(dst, f.delimiter...)
+	return dst, nil
+}
+
+//header not fixed
+func (f *DelimiterPacket) GetHeadLen() uint32 {
+	return 0
+}
@@ -0,0 +1,452 @@
+//go:build linux
+
+package face
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"syscall"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for epoll poller
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - batch event loops watch all tcp connects
+ * - no reader goroutine for idle connect
+ * - event loop for io only, complete message queued by connect
+ * - queued messages dispatched in order by worker of connect
+ * - read paused when queue full or hello not handled
+ */
+
+//message or read error wait dispatch
+type pollItem struct {
+	message iface.IMessage
+	err     error
+}
+
+//connect in event loop
+type pollConn struct {
+	fd      int
+	loop    *eventLoop
+	connect *Connect
+	rawConn syscall.RawConn
+	pending []byte     //un-framed data
+	queue   []pollItem //wait dispatch in order
+	running bool       //worker of queue running
+	paused  bool       //removed from epoll, framing stopped
+	closed  bool       //read failed, no more data
+	sync.Mutex
+}
+
+//one event loop
+type eventLoop struct {
+	poller  *Poller
+	epFd    int
+	buff    []byte
+	connMap map[int]*pollConn //fd -> pollConn
+	sync.RWMutex
+}
+
+//face info
+type Poller struct {
+	packet    iface.IPacket
+	loops     []*eventLoop
+	connMap   map[int64]*pollConn //connId -> pollConn
+	next      uint64
+	needQuit  bool
+	cbForRead func(*Connect, iface.IRequest, error) bool
+	loopWg    sync.WaitGroup
+	workWg    sync.WaitGroup
+	sync.Mutex
+}
+
+//construct
+//cb for read return false means connect closed
+func NewPoller(
+		loops int,
+		packet iface.IPacket,
+		cbForRead func(*Connect, iface.IRequest, error) bool,
+	) (*Poller, error) {
+	//check
+	if packet == nil || cbForRead == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	if loops <= 0 {
+		loops = runtime.NumCPU()
+	}
+
+	//self init
+	this := &Poller{
+		packet: packet,
+		loops: make([]*eventLoop, 0, loops),
+		connMap: map[int64]*pollConn{},
+		cbForRead: cbForRead,
+	}
+
+	//init event loops
+	for i := 0; i < loops; i++ {
+		epFd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+		if err != nil {
+			this.Quit()
+			return nil, err
+		}
+		loop := &eventLoop{
+			poller: this,
+			epFd: epFd,
+			buff: make([]byte, define.DefaultEventLoopReadSize),
+			connMap: map[int]*pollConn{},
+		}
+		this.loops = append(this.loops, loop)
+		this.loopWg.Add(1)
+		go loop.run()
+	}
+	return this, nil
+}
+
+//quit
+func (p *Poller) Quit() {
+	p.Lock()
+	defer p.Unlock()
+	p.needQuit = true
+}
+
+//wait event loops quit, queued messages handled
+func (p *Poller) Wait(ctx context.Context) error {
+	if err := WaitWithContext(ctx, &p.loopWg); err != nil {
+		return err
+	}
+	return WaitWithContext(ctx, &p.workWg)
+}
+
+//add connect into event loop
+func (p *Poller) AddConnect(connect *Connect) error {
+	var (
+		fd int
+	)
+	//check
+	if connect == nil {
+		return errors.New("invalid parameter")
+	}
+	conn, ok := connect.GetConn().(syscall.Conn)
+	if !ok || conn == nil {
+		return errors.New("connect not support raw fd")
+	}
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	err = rawConn.Control(func(sysFd uintptr) {
+		fd = int(sysFd)
+	})
+	if err != nil {
+		return err
+	}
+
+	//pick event loop
+	p.Lock()
+	loop := p.loops[p.next % uint64(len(p.loops))]
+	pc := &pollConn{
+		fd: fd,
+		loop: loop,
+		connect: connect,
+		rawConn: rawConn,
+		pending: connect.TakePending(),
+	}
+	p.connMap[connect.GetConnId()] = pc
+	p.next++
+	p.Unlock()
+
+	//sync into event loop
+	loop.Lock()
+	loop.connMap[fd] = pc
+	loop.Unlock()
+
+	//watch read event
+	if err = loop.watch(fd, true); err != nil {
+		p.RemoveConnect(connect)
+		return err
+	}
+	return nil
+}
+
+//remove connect from event loop
+//closed fd removed from epoll by kernel
+func (p *Poller) RemoveConnect(connect *Connect) {
+	if connect == nil {
+		return
+	}
+
+	//get poll connect
+	p.Lock()
+	pc, ok := p.connMap[connect.GetConnId()]
+	if ok && pc.connect == connect {
+		delete(p.connMap, connect.GetConnId())
+	}
+	p.Unlock()
+	if !ok || pc.connect != connect {
+		return
+	}
+
+	//remove from event loop
+	//fd may be reused by new connect
+	pc.loop.Lock()
+	defer pc.loop.Unlock()
+	if v, subOk := pc.loop.connMap[pc.fd]; subOk && v == pc {
+		delete(pc.loop.connMap, pc.fd)
+	}
+}
+
+//get and clear un-framed data of connect
+//should be called after event loops quit
+func (p *Poller) TakePending(connect *Connect) []byte {
+	p.Lock()
+	pc, ok := p.connMap[connect.GetConnId()]
+	p.Unlock()
+	if !ok || pc.connect != connect {
+		return nil
+	}
+	pc.Lock()
+	defer pc.Unlock()
+	pending := pc.pending
+	pc.pending = nil
+	return pending
+}
+
+///////////////
+//private func
+///////////////
+
+//check quit or not
+func (p *Poller) isQuit() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.needQuit
+}
+
+//run event loop
+func (l *eventLoop) run() {
+	var (
+		events = make([]syscall.EpollEvent, 128)
+		m any = nil
+	)
+
+	//defer
+	defer func() {
+		if err := recover(); err != m {
+			log.Printf("cree.poller, event loop panic err:%v, trace:%v\n",
+				err, string(debug.Stack()))
+		}
+		l.Lock()
+		syscall.Close(l.epFd)
+		l.epFd = -1
+		l.Unlock()
+		l.poller.loopWg.Done()
+	}()
+
+	//loop
+	for !l.poller.isQuit() {
+		n, err := syscall.EpollWait(l.epFd, events, define.DefaultEventLoopWaitMs)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			log.Printf("cree.poller, epoll wait failed, err:%v\n", err.Error())
+			return
+		}
+		for i := 0; i < n; i++ {
+			l.readConn(int(events[i].Fd))
+		}
+	}
+}
+
+//read and frame data of one connect
+func (l *eventLoop) readConn(fd int) {
+	var (
+		n int
+		readErr error
+	)
+	//get connect
+	l.RLock()
+	pc, ok := l.connMap[fd]
+	l.RUnlock()
+	if !ok || pc == nil {
+		return
+	}
+	pc.Lock()
+	defer pc.Unlock()
+	if pc.paused || pc.closed {
+		return
+	}
+
+	//read raw data, fd kept alive during read
+	err := pc.rawConn.Read(func(sysFd uintptr) bool {
+		n, readErr = syscall.Read(int(sysFd), l.buff)
+		return true
+	})
+	if err == nil {
+		err = readErr
+	}
+	if err == syscall.EAGAIN {
+		return
+	}
+	if err == nil && n <= 0 {
+		err = io.EOF
+	}
+	if err != nil {
+		//dispatched after queued messages
+		l.poller.RemoveConnect(pc.connect)
+		l.watch(fd, false)
+		pc.closed = true
+		l.poller.enqueue(pc, pollItem{err: err})
+		return
+	}
+
+	//frame data
+	data := l.buff[:n]
+	if len(pc.pending) > 0 {
+		data = append(pc.pending, data...)
+	}
+	data = l.poller.frameData(pc, data)
+
+	//keep un-framed data
+	if len(data) <= 0 {
+		pc.pending = nil
+	}else{
+		pc.pending = append([]byte(nil), data...)
+	}
+}
+
+//frame packets and queue them, return left data
+//framing paused by hello, packet may be changed by it
+//should be called with locker of connect
+func (p *Poller) frameData(pc *pollConn, data []byte) []byte {
+	for len(data) > 0 && !pc.paused {
+		//decode one message
+		message, size, err := pc.connect.GetPacket().Decode(data)
+		if err != nil {
+			//framing lost, drop left data
+			p.enqueue(pc, pollItem{err: err})
+			return nil
+		}
+		if message == nil {
+			break
+		}
+		data = data[size:]
+
+		//queue message, pause if queue full or hello
+		p.enqueue(pc, pollItem{message: message})
+		if len(pc.queue) >= define.DefaultEventLoopQueueSize ||
+			KindType(message.GetKind()) == define.KindHello {
+			pc.paused = true
+			pc.loop.watch(pc.fd, false)
+		}
+	}
+	return data
+}
+
+//queue item and run worker of connect if not running
+//should be called with locker of connect
+func (p *Poller) enqueue(pc *pollConn, item pollItem) {
+	pc.queue = append(pc.queue, item)
+	if pc.running {
+		return
+	}
+	pc.running = true
+	p.workWg.Add(1)
+	go p.runQueue(pc)
+}
+
+//dispatch queued items of connect in order
+//framing and read resumed after queue drained
+func (p *Poller) runQueue(pc *pollConn) {
+	var (
+		m any = nil
+	)
+	//defer
+	defer func() {
+		if err := recover(); err != m {
+			log.Printf("cree.poller, dispatch panic err:%v, trace:%v\n",
+				err, string(debug.Stack()))
+			pc.Lock()
+			pc.running = false
+			pc.Unlock()
+		}
+		p.workWg.Done()
+	}()
+
+	//loop
+	for {
+		//pick first item
+		pc.Lock()
+		if len(pc.queue) <= 0 {
+			pc.running = false
+			pc.Unlock()
+			return
+		}
+		item := pc.queue[0]
+		pc.queue[0] = pollItem{}
+		pc.queue = pc.queue[1:]
+		pc.Unlock()
+
+		//handle message
+		var (
+			req iface.IRequest
+			err = item.err
+		)
+		if item.message != nil {
+			req, err = pc.connect.HandleMessage(item.message)
+		}
+		ok := p.cbForRead(pc.connect, req, err)
+		ReleaseRequest(req)
+
+		//drop left items if connect closed
+		pc.Lock()
+		if !ok {
+			pc.queue = nil
+			pc.closed = true
+		}
+		if pc.paused && !pc.closed && len(pc.queue) <= 0 {
+			p.resume(pc)
+		}
+		pc.Unlock()
+	}
+}
+
+//frame left data and watch read event again
+//should be called with locker of connect
+func (p *Poller) resume(pc *pollConn) {
+	pc.paused = false
+	pc.pending = p.frameData(pc, pc.pending)
+	if len(pc.pending) <= 0 {
+		pc.pending = nil
+	}
+	if !pc.paused {
+		pc.loop.watch(pc.fd, true)
+	}
+}
+
+//add or remove read event of fd
+func (l *eventLoop) watch(fd int, enable bool) error {
+	l.RLock()
+	defer l.RUnlock()
+	if l.epFd < 0 {
+		return errors.New("event loop quit")
+	}
+	if !enable {
+		return syscall.EpollCtl(l.epFd, syscall.EPOLL_CTL_DEL, fd, nil)
+	}
+	event := &syscall.EpollEvent{
+		Events: syscall.EPOLLIN | syscall.EPOLLRDHUP,
+		Fd: int32(fd),
+	}
+	return syscall.EpollCtl(l.epFd, syscall.EPOLL_CTL_ADD, fd, event)
+}
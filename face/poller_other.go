@@ -0,0 +1,53 @@
+//go:build !linux
+
+package face
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for epoll poller
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - only supported on linux
+ */
+
+//face info
+type Poller struct {
+}
+
+//construct
+func NewPoller(
+		loops int,
+		packet iface.IPacket,
+		cbForRead func(*Connect, iface.IRequest, error) bool,
+	) (*Poller, error) {
+	return nil, errors.New("epoll engine only supported on linux")
+}
+
+//quit
+func (p *Poller) Quit() {
+}
+
+//wait event loops quit
+func (p *Poller) Wait(ctx context.Context) error {
+	return nil
+}
+
+//add connect into event loop
+func (p *Poller) AddConnect(connect *Connect) error {
+	return errors.New("epoll engine only supported on linux")
+}
+
+//remove connect from event loop
+func (p *Poller) RemoveConnect(connect *Connect) {
+}
+
+//get and clear un-framed data of connect
+func (p *Poller) TakePending(connect *Connect) []byte {
+	return nil
+}
@@ -0,0 +1,93 @@
+package face
+
+import (
+	"sync"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for object pool
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - reuse buffer, message and request
+ * - request and message of data frame released after router returned
+ * - released object should not be used any more, router should not keep request
+ */
+
+//inter pool
+var (
+	bufferPool = sync.Pool{
+		New: func() any {
+			buff := make([]byte, 0, define.DefaultPoolBufferSize)
+			return &buff
+		},
+	}
+	messagePool = sync.Pool{
+		New: func() any {
+			return NewMessage()
+		},
+	}
+	requestPool = sync.Pool{
+		New: func() any {
+			return &Request{}
+		},
+	}
+)
+
+//get buffer from pool
+func AcquireBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+//put buffer back into pool
+//too large buffer not keep
+func ReleaseBuffer(buff *[]byte) {
+	if buff == nil || cap(*buff) > define.DefaultPoolBufferMaxSize {
+		return
+	}
+	*buff = (*buff)[:0]
+	bufferPool.Put(buff)
+}
+
+//get message from pool
+func AcquireMessage() *Message {
+	return messagePool.Get().(*Message)
+}
+
+//put message back into pool
+//body buffer of decoded data kept, too large buffer not keep
+func ReleaseMessage(message *Message) {
+	if message == nil {
+		return
+	}
+	buff := message.buff[:0]
+	*message = Message{}
+	if cap(buff) <= define.DefaultPoolBufferMaxSize {
+		message.buff = buff
+	}
+	messagePool.Put(message)
+}
+
+//get request from pool
+func AcquireRequest(conn iface.IConnect, message iface.IMessage) *Request {
+	req := requestPool.Get().(*Request)
+	req.conn = conn
+	req.message = message
+	return req
+}
+
+//put request back into pool, called after router returned
+//message of data frame released too, control frame may be kept by waiter
+func ReleaseRequest(req iface.IRequest) {
+	request, ok := req.(*Request)
+	if !ok || request == nil {
+		return
+	}
+	if message, ok := request.message.(*Message); ok && IsDataFrame(message.GetKind()) {
+		ReleaseMessage(message)
+	}
+	*request = Request{}
+	requestPool.Put(request)
+}
@@ -0,0 +1,352 @@
+package face
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/andyzhou/cree/define"
+)
+
+/*
+ * face for proxy protocol connect
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - parse proxy protocol v1 and v2 header before first read
+ * - header read exactly, no data of connect buffered
+ */
+
+//inter macro define
+const (
+	proxyV1Prefix  = "PROXY "
+	proxySigSize   = 12
+	proxyV2Version = 0x2
+)
+
+//inter error define
+var (
+	ErrProxyHeader = errors.New("invalid proxy protocol header")
+)
+
+//v2 signature
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+//face info
+type ProxyConn struct {
+	net.Conn
+	srcAddr  net.Addr //real client address
+	dstAddr  net.Addr //address connected by client
+	tlvs     []define.ProxyTLV
+	parsed   bool
+	err      error
+	locker   sync.Mutex
+}
+
+//proxy listener
+type ProxyListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+//construct
+func NewProxyConn(conn net.Conn) *ProxyConn {
+	this := &ProxyConn{
+		Conn: conn,
+	}
+	return this
+}
+
+//construct with parsed header, like connect handed off by hot restart
+func RestoreProxyConn(
+		conn net.Conn,
+		srcAddr, dstAddr net.Addr,
+		tlvs []define.ProxyTLV,
+	) *ProxyConn {
+	this := &ProxyConn{
+		Conn: conn,
+		srcAddr: srcAddr,
+		dstAddr: dstAddr,
+		tlvs: tlvs,
+		parsed: true,
+	}
+	return this
+}
+
+//construct listener
+//if trusted is empty, no source trusted and header not parsed
+func NewProxyListener(listener net.Listener, trusted []string) (*ProxyListener, error) {
+	this := &ProxyListener{
+		Listener: listener,
+	}
+	for _, v := range trusted {
+		_, ipNet, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+		this.trusted = append(this.trusted, ipNet)
+	}
+	return this, nil
+}
+
+//accept new connect, only trusted source need proxy header
+func (l *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return NewProxyConn(conn), nil
+}
+
+//get proxy connect from wrapped connect, like tls or websocket
+func GetProxyConn(conn net.Conn) *ProxyConn {
+	for conn != nil {
+		switch v := conn.(type) {
+		case *ProxyConn:
+			return v
+		case *tls.Conn:
+			conn = v.NetConn()
+		case *WsConn:
+			conn = v.Conn
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+//read header, should be called before read
+func (c *ProxyConn) Handshake() error {
+	c.locker.Lock()
+	defer c.locker.Unlock()
+	if !c.parsed {
+		c.err = c.readHeader()
+		c.parsed = true
+	}
+	return c.err
+}
+
+//read data after header
+func (c *ProxyConn) Read(p []byte) (int, error) {
+	if err := c.Handshake(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+//get real client address
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	if c.srcAddr != nil {
+		return c.srcAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+//get address connected by client
+func (c *ProxyConn) LocalAddr() net.Addr {
+	if c.dstAddr != nil {
+		return c.dstAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+//get proxy address
+func (c *ProxyConn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+//get tlvs of v2 header
+func (c *ProxyConn) TLVs() []define.ProxyTLV {
+	return c.tlvs
+}
+
+//get raw connect, used by epoll engine
+func (c *ProxyConn) SyscallConn() (syscall.RawConn, error) {
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("connect not support syscall")
+	}
+	return sc.SyscallConn()
+}
+
+///////////////
+//private func
+///////////////
+
+//check source is trusted
+func (l *ProxyListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, v := range l.trusted {
+		if v.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+//read v1 or v2 header
+func (c *ProxyConn) readHeader() error {
+	//read signature size, less than min v1 header
+	head := make([]byte, proxySigSize, define.ProxyV1MaxSize)
+	if _, err := io.ReadFull(c.Conn, head); err != nil {
+		return err
+	}
+	if bytes.Equal(head, proxyV2Sig) {
+		return c.readV2Header()
+	}
+	if bytes.HasPrefix(head, []byte(proxyV1Prefix)) {
+		return c.readV1Header(head)
+	}
+	return ErrProxyHeader
+}
+
+//read v1 text header
+//like PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func (c *ProxyConn) readV1Header(head []byte) error {
+	//read byte by byte until line end
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(head, []byte("\r\n")) {
+		if len(head) >= define.ProxyV1MaxSize {
+			return ErrProxyHeader
+		}
+		if _, err := io.ReadFull(c.Conn, b); err != nil {
+			return err
+		}
+		head = append(head, b[0])
+	}
+
+	//parse fields
+	fields := strings.Split(string(head[:len(head) - 2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrProxyHeader
+	}
+	srcAddr, err := parseProxyV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dstAddr, err := parseProxyV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	c.srcAddr, c.dstAddr = srcAddr, dstAddr
+	return nil
+}
+
+//read v2 binary header
+func (c *ProxyConn) readV2Header() error {
+	//read rest of fixed header
+	head := make([]byte, define.ProxyV2HeaderSize - proxySigSize)
+	if _, err := io.ReadFull(c.Conn, head); err != nil {
+		return err
+	}
+	if head[0] >> 4 != proxyV2Version {
+		return ErrProxyHeader
+	}
+	command := head[0] & 0x0F
+	family := head[1]
+	body := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(c.Conn, body); err != nil {
+		return err
+	}
+
+	//local command, keep origin address
+	switch command {
+	case define.ProxyV2CmdLocal:
+		return nil
+	case define.ProxyV2CmdProxy:
+	default:
+		return ErrProxyHeader
+	}
+
+	//parse address by family
+	var addrSize int
+	switch family >> 4 {
+	case 0x1: //inet
+		addrSize = 12
+		if len(body) < addrSize {
+			return ErrProxyHeader
+		}
+		c.srcAddr = proxyV2Addr(family, body[0:4], body[8:10])
+		c.dstAddr = proxyV2Addr(family, body[4:8], body[10:12])
+	case 0x2: //inet6
+		addrSize = 36
+		if len(body) < addrSize {
+			return ErrProxyHeader
+		}
+		c.srcAddr = proxyV2Addr(family, body[0:16], body[32:34])
+		c.dstAddr = proxyV2Addr(family, body[16:32], body[34:36])
+	case 0x3: //unix
+		addrSize = 216
+		if len(body) < addrSize {
+			return ErrProxyHeader
+		}
+		c.srcAddr = &net.UnixAddr{Name: string(bytes.TrimRight(body[:108], "\x00")), Net: "unix"}
+		c.dstAddr = &net.UnixAddr{Name: string(bytes.TrimRight(body[108:216], "\x00")), Net: "unix"}
+	}
+
+	//parse tlvs
+	tlvs, err := parseProxyTLVs(body[addrSize:])
+	if err != nil {
+		return err
+	}
+	c.tlvs = tlvs
+	return nil
+}
+
+//parse v1 ip and port
+func parseProxyV1Addr(ip, port string) (net.Addr, error) {
+	addr := net.ParseIP(ip)
+	portVal, err := strconv.Atoi(port)
+	if addr == nil || err != nil || portVal < 0 || portVal > 65535 {
+		return nil, ErrProxyHeader
+	}
+	return &net.TCPAddr{IP: addr, Port: portVal}, nil
+}
+
+//gen v2 address
+func proxyV2Addr(family byte, ip, port []byte) net.Addr {
+	ipVal := make(net.IP, len(ip))
+	copy(ipVal, ip)
+	portVal := int(binary.BigEndian.Uint16(port))
+	if family & 0x0F == 0x2 {
+		return &net.UDPAddr{IP: ipVal, Port: portVal}
+	}
+	return &net.TCPAddr{IP: ipVal, Port: portVal}
+}
+
+//parse v2 tlvs
+func parseProxyTLVs(data []byte) ([]define.ProxyTLV, error) {
+	var (
+		tlvs []define.ProxyTLV
+	)
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, ErrProxyHeader
+		}
+		size := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3 + size {
+			return nil, ErrProxyHeader
+		}
+		tlvs = append(tlvs, define.ProxyTLV{
+			Type: data[0],
+			Value: data[3:3 + size],
+		})
+		data = data[3 + size:]
+	}
+	return tlvs, nil
+}
@@ -0,0 +1,127 @@
+package face
+
+import (
+	"errors"
+	"io"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for packet reader
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - frame messages from stream by packet codec
+ * - header length not assumed, read data buffered
+ */
+
+//face info
+type PacketReader struct {
+	packet iface.IPacket
+	buff   []byte
+	start  int
+	end    int
+	err    error //read error kept until buffered data framed
+}
+
+//construct
+func NewPacketReader(packet iface.IPacket, buffSize int) *PacketReader {
+	if buffSize <= 0 {
+		buffSize = define.DefaultTcpReadBuffSize
+	}
+	this := &PacketReader{
+		packet: packet,
+		buff: make([]byte, buffSize),
+	}
+	return this
+}
+
+//read one message from reader
+//should be called by one reader only
+func (f *PacketReader) ReadMessage(r io.Reader) (iface.IMessage, error) {
+	//check
+	if f.packet == nil || r == nil {
+		return nil, errors.New("invalid parameter")
+	}
+
+	//loop until one message framed
+	for {
+		//decode buffered data first
+		if f.end > f.start {
+			message, size, err := f.packet.Decode(f.buff[f.start:f.end])
+			if err != nil {
+				//framing lost, drop buffered data
+				f.start, f.end = 0, 0
+				return nil, err
+			}
+			if message != nil {
+				f.start += size
+				if f.start >= f.end {
+					f.start, f.end = 0, 0
+				}
+				return message, nil
+			}
+		}
+
+		//return kept read error
+		if f.err != nil {
+			err := f.err
+			f.err = nil
+			return nil, err
+		}
+
+		//make room and read more
+		f.makeRoom()
+		n, err := r.Read(f.buff[f.end:])
+		f.end += n
+		f.err = err
+	}
+}
+
+//set packet codec, buffered data framed by it
+func (f *PacketReader) SetPacket(packet iface.IPacket) {
+	if packet == nil {
+		return
+	}
+	f.packet = packet
+}
+
+//get and clear buffered data, read but not framed
+func (f *PacketReader) Pending() []byte {
+	if f.end <= f.start {
+		return nil
+	}
+	pending := make([]byte, f.end - f.start)
+	copy(pending, f.buff[f.start:f.end])
+	f.start, f.end = 0, 0
+	return pending
+}
+
+//set buffered data, framed before reader data
+func (f *PacketReader) SetPending(pending []byte) {
+	f.start, f.end = 0, 0
+	if len(pending) > len(f.buff) {
+		f.buff = make([]byte, len(pending))
+	}
+	f.end = copy(f.buff, pending)
+}
+
+///////////////
+//private func
+///////////////
+
+//move buffered data to head or grow buffer
+func (f *PacketReader) makeRoom() {
+	if f.end < len(f.buff) {
+		return
+	}
+	if f.start > 0 {
+		f.end = copy(f.buff, f.buff[f.start:f.end])
+		f.start = 0
+		return
+	}
+	buff := make([]byte, len(f.buff) * 2)
+	copy(buff, f.buff[:f.end])
+	f.buff = buff
+}
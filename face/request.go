@@ -1,6 +1,9 @@
 package face
 
-import "github.com/andyzhou/cree/iface"
+import (
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
 
 /*
  * face for request
@@ -35,3 +38,18 @@ func (r *Request) GetConnect() iface.IConnect {
 	return r.conn
 }
 
+//reply data with same message id
+//response frame with same seq for request, push message for others
+func (r *Request) Reply(data []byte) error {
+	kind := uint32(define.KindPush)
+	if KindType(r.message.GetKind()) == define.KindRequest {
+		kind = MakeKind(define.KindResponse, KindSeq(r.message.GetKind()))
+	}
+	return r.conn.SendFrame(kind, r.message.GetId(), data)
+}
+
+//reply error frame with same message id and seq
+//*FrameError sent as it is, others as internal error
+func (r *Request) ReplyError(err error) error {
+	return SendFrameError(r.conn, r, 0, err)
+}
@@ -0,0 +1,295 @@
+package face
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for secure channel
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - x25519 keys exchanged by hello frame, aes-gcm keys derived per connect
+ * - sealed data = counter(8byte) + cipher data, kind and id authenticated
+ * - counter of peer must be increased, replayed frame refused
+ * - static key of server mixed in if set, pinned by client
+ */
+
+//inter error define
+var (
+	ErrSecureFrame = errors.New("secure frame open failed")
+	ErrSecureKey   = errors.New("invalid secure key")
+)
+
+//keys and counters of secure channel
+//exported for connect handoff
+type SecureState struct {
+	SendKey []byte
+	RecvKey []byte
+	SendSeq uint64 //counter of last sealed frame
+	RecvSeq uint64 //counter of last opened frame
+}
+
+//packet with payload sealed
+//wrap codec with kind, decode should be called by one reader
+type SecurePacket struct {
+	iface.IPacket
+	sendAead cipher.AEAD
+	recvAead cipher.AEAD
+	sendKey  []byte
+	recvKey  []byte
+	sendSeq  uint64
+	recvSeq  uint64
+}
+
+//construct
+func NewSecurePacket(packet iface.IPacket, state *SecureState) (*SecurePacket, error) {
+	//check
+	if packet == nil || state == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	sendAead, err := newAead(state.SendKey)
+	if err != nil {
+		return nil, err
+	}
+	recvAead, err := newAead(state.RecvKey)
+	if err != nil {
+		return nil, err
+	}
+	this := &SecurePacket{
+		IPacket: packet,
+		sendAead: sendAead,
+		recvAead: recvAead,
+		sendKey: state.SendKey,
+		recvKey: state.RecvKey,
+		sendSeq: state.SendSeq,
+		recvSeq: state.RecvSeq,
+	}
+	return this, nil
+}
+
+//gen x25519 key for hello
+func NewSecureKey() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+//parse x25519 private key, like static key of server
+func ParseSecureKey(key []byte) (*ecdh.PrivateKey, error) {
+	privateKey, err := ecdh.X25519().NewPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrSecureKey, err)
+	}
+	return privateKey, nil
+}
+
+//server side key exchange by public key of client
+//static key is optional, return state and public key of server
+func AcceptSecure(clientKey []byte, staticKey *ecdh.PrivateKey) (*SecureState, []byte, error) {
+	privateKey, err := NewSecureKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := exchangeKey(privateKey, clientKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	serverKey := privateKey.PublicKey().Bytes()
+	var staticPublic []byte
+	if staticKey != nil {
+		staticSecret, subErr := exchangeKey(staticKey, clientKey)
+		if subErr != nil {
+			return nil, nil, subErr
+		}
+		secret = append(secret, staticSecret...)
+		staticPublic = staticKey.PublicKey().Bytes()
+	}
+	c2s, s2c := deriveKeys(secret, clientKey, serverKey, staticPublic)
+	state := &SecureState{
+		SendKey: s2c,
+		RecvKey: c2s,
+	}
+	return state, serverKey, nil
+}
+
+//client side key exchange by public keys of server
+//static key should be same as server, empty if not set
+func DialSecure(privateKey *ecdh.PrivateKey, serverKey, staticKey []byte) (*SecureState, error) {
+	//check
+	if privateKey == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	secret, err := exchangeKey(privateKey, serverKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(staticKey) > 0 {
+		staticSecret, subErr := exchangeKey(privateKey, staticKey)
+		if subErr != nil {
+			return nil, subErr
+		}
+		secret = append(secret, staticSecret...)
+	}
+	c2s, s2c := deriveKeys(secret, privateKey.PublicKey().Bytes(), serverKey, staticKey)
+	state := &SecureState{
+		SendKey: c2s,
+		RecvKey: s2c,
+	}
+	return state, nil
+}
+
+//get secure packet of codec, nil if not sealed
+func GetSecurePacket(packet iface.IPacket) *SecurePacket {
+	if compress, ok := packet.(*CompressPacket); ok {
+		packet = compress.IPacket
+	}
+	secure, _ := packet.(*SecurePacket)
+	return secure
+}
+
+///////////////////
+//api for packet
+///////////////////
+
+//get keys and counters
+//should be called after reader stopped
+func (f *SecurePacket) GetState() *SecureState {
+	state := &SecureState{
+		SendKey: f.sendKey,
+		RecvKey: f.recvKey,
+		SendSeq: atomic.LoadUint64(&f.sendSeq),
+		RecvSeq: f.recvSeq,
+	}
+	return state
+}
+
+//decode one message, open data if flag set
+//plain control frame allowed until first sealed frame, like pong sent before hello reply
+func (f *SecurePacket) Decode(data []byte) (iface.IMessage, int, error) {
+	message, size, err := f.IPacket.Decode(data)
+	if err != nil || message == nil {
+		return message, size, err
+	}
+	kind := message.GetKind()
+	if kind & define.KindFlagSecure == 0 {
+		if f.recvSeq > 0 || IsDataFrame(kind) || IsStreamFrame(kind) ||
+			KindType(kind) == define.KindFragment || KindType(kind) == define.KindHello {
+			return nil, 0, fmt.Errorf("%w, plain frame of kind %x", ErrSecureFrame, kind)
+		}
+		return message, size, nil
+	}
+
+	//check counter, should be increased
+	body := message.GetData()
+	if len(body) < 8 + f.recvAead.Overhead() {
+		return nil, 0, fmt.Errorf("%w, sealed data too short", ErrSecureFrame)
+	}
+	seq := binary.BigEndian.Uint64(body)
+	if seq <= f.recvSeq {
+		return nil, 0, fmt.Errorf("%w, replayed counter %d", ErrSecureFrame, seq)
+	}
+
+	//open data with kind and id
+	plain, err := f.recvAead.Open(nil, makeNonce(seq), body[8:], makeAad(kind, message.GetId()))
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w, %v", ErrSecureFrame, err)
+	}
+	f.recvSeq = seq
+	message.SetKind(kind &^ define.KindFlagSecure)
+	message.SetData(plain)
+	return message, size, nil
+}
+
+func (f *SecurePacket) Pack(message iface.IMessage) ([]byte, error) {
+	return f.AppendPack(nil, message)
+}
+
+//pack data sealed by next counter
+func (f *SecurePacket) AppendPack(dst []byte, message iface.IMessage) ([]byte, error) {
+	//check
+	if message == nil {
+		return dst, errors.New("invalid parameter")
+	}
+	kind := message.GetKind() | define.KindFlagSecure
+	seq := atomic.AddUint64(&f.sendSeq, 1)
+	data := message.GetData()
+
+	//seal data with kind and id
+	body := make([]byte, 8, 8 + len(data) + f.sendAead.Overhead())
+	binary.BigEndian.PutUint64(body, seq)
+	body = f.sendAead.Seal(body, makeNonce(seq), data, makeAad(kind, message.GetId()))
+	packed := AcquireMessage()
+	defer ReleaseMessage(packed)
+	packed.SetKind(kind)
+	packed.SetId(message.GetId())
+	packed.SetData(body)
+	return f.IPacket.AppendPack(dst, packed)
+}
+
+///////////////
+//private func
+///////////////
+
+//init aes-gcm by key
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrSecureKey, err)
+	}
+	return cipher.NewGCM(block)
+}
+
+//get shared secret by public key of peer
+func exchangeKey(privateKey *ecdh.PrivateKey, peerKey []byte) ([]byte, error) {
+	publicKey, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrSecureKey, err)
+	}
+	secret, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w, %v", ErrSecureKey, err)
+	}
+	return secret, nil
+}
+
+//derive keys of both directions by hkdf-sha256, public keys as info
+func deriveKeys(secret []byte, publicKeys ...[]byte) (c2s, s2c []byte) {
+	extract := hmac.New(sha256.New, []byte("cree secure"))
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+	expand := func(label string) []byte {
+		h := hmac.New(sha256.New, prk)
+		for _, key := range publicKeys {
+			h.Write(key)
+		}
+		h.Write([]byte(label))
+		h.Write([]byte{1})
+		return h.Sum(nil)
+	}
+	return expand("c2s"), expand("s2c")
+}
+
+//nonce of counter, key differ by direction
+func makeNonce(seq uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], seq)
+	return nonce
+}
+
+//additional data of kind and id
+func makeAad(kind, messageId uint32) []byte {
+	aad := make([]byte, 8)
+	binary.BigEndian.PutUint32(aad, kind)
+	binary.BigEndian.PutUint32(aad[4:], messageId)
+	return aad
+}
@@ -0,0 +1,569 @@
+package face
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for stream multiplexing
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - streams opened by both sides, id odd for client and even for server
+ * - data split into small frames, interleaved with other frames of connect
+ * - writer blocked if window granted by peer used up, granted again after read
+ * - close frame end write side, reset frame abort both sides
+ * - streams not kept by upgrade handoff
+ */
+
+//inter error define
+var (
+	ErrStreamReset       = errors.New("stream reset")
+	ErrStreamUnsupported = errors.New("stream not supported by codec or peer")
+)
+
+//streams of one connect
+//frames should be handled by one reader
+type Mux struct {
+	send       func(kind, messageId uint32, data []byte) error
+	chunkSize  int
+	caps       uint32 //capabilities of peer, stream opened if has define.CapStream
+	nextId     uint32
+	streams    map[uint32]*Stream
+	cbForAccept func(iface.IStream)
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	closeErr   error //connect closed
+	sync.RWMutex
+}
+
+//one stream, like net.Conn
+type Stream struct {
+	mux         *Mux
+	streamId    uint32
+	readBuff    bytes.Buffer
+	readErr     error //io.EOF after peer closed, or reset error
+	readClosed  bool  //closed by local, data of peer refused
+	writeErr    error //closed by local or reset
+	writeDone   bool  //close frame sent
+	remoteDone  bool  //close frame of peer received
+	sendWindow  int   //left window granted by peer
+	recvWindow  int   //left window granted to peer
+	unacked     int   //read data not granted again
+	readChan    chan struct{}
+	writeChan   chan struct{}
+	readDeadline  time.Time
+	writeDeadline time.Time
+	writeLocker sync.Mutex //frames of one write not mixed
+	sync.Mutex
+}
+
+//construct
+//send should be safe for concurrent use, data of frame not kept
+func NewMux(
+	send func(kind, messageId uint32, data []byte) error,
+	packet iface.IPacket,
+	client bool) *Mux {
+	chunkSize := define.StreamChunkSize
+	if size := FragmentSize(packet); size < chunkSize {
+		chunkSize = size
+	}
+	this := &Mux{
+		send: send,
+		chunkSize: chunkSize,
+		nextId: 2,
+		streams: map[uint32]*Stream{},
+	}
+	if client {
+		this.nextId = 1
+	}
+	return this
+}
+
+//check frame of stream or not
+func IsStreamFrame(kind uint32) bool {
+	switch KindType(kind) {
+	case define.KindStreamOpen, define.KindStreamData, define.KindStreamWindow,
+		define.KindStreamClose, define.KindStreamReset:
+		return true
+	default:
+		return false
+	}
+}
+
+///////////////////
+//api for mux
+///////////////////
+
+//set addresses of streams
+func (f *Mux) SetAddr(localAddr, remoteAddr net.Addr) {
+	f.Lock()
+	defer f.Unlock()
+	f.localAddr = localAddr
+	f.remoteAddr = remoteAddr
+}
+
+//set capabilities of peer negotiated by hello
+func (f *Mux) SetCaps(caps uint32) {
+	f.Lock()
+	defer f.Unlock()
+	f.caps = caps
+}
+
+//set cb for stream opened by peer, called in background
+//stream refused if not set
+func (f *Mux) SetCBForAccept(cb func(iface.IStream)) {
+	f.Lock()
+	defer f.Unlock()
+	f.cbForAccept = cb
+}
+
+//open new stream, data can be written at once
+func (f *Mux) Open() (*Stream, error) {
+	//check
+	if f.chunkSize <= 0 {
+		return nil, ErrStreamUnsupported
+	}
+
+	//init stream with next id
+	f.Lock()
+	if f.caps & define.CapStream == 0 {
+		f.Unlock()
+		return nil, ErrStreamUnsupported
+	}
+	if f.closeErr != nil {
+		f.Unlock()
+		return nil, f.closeErr
+	}
+	if len(f.streams) >= define.StreamMaxOpen || f.nextId + 2 < f.nextId {
+		f.Unlock()
+		return nil, errors.New("too many streams")
+	}
+	stream := newStream(f, f.nextId)
+	f.streams[stream.streamId] = stream
+	f.nextId += 2
+	f.Unlock()
+
+	//notify peer
+	kind := MakeKind(define.KindStreamOpen, 0)
+	if err := f.send(kind, stream.streamId, []byte{}); err != nil {
+		f.remove(stream.streamId)
+		return nil, err
+	}
+	return stream, nil
+}
+
+//handle frame of stream, should not be blocked
+func (f *Mux) HandleFrame(message iface.IMessage) {
+	//open by peer
+	kind := KindType(message.GetKind())
+	streamId := message.GetId()
+	if kind == define.KindStreamOpen {
+		f.accept(streamId)
+		return
+	}
+
+	//get stream, data of unknown stream refused
+	f.RLock()
+	stream := f.streams[streamId]
+	f.RUnlock()
+	if stream == nil {
+		if kind == define.KindStreamData {
+			f.sendReset(streamId, NewFrameError(define.FrameErrBadFrame, "stream not exists"))
+		}
+		return
+	}
+
+	data := message.GetData()
+	switch kind {
+	case define.KindStreamData:
+		stream.receive(data)
+	case define.KindStreamWindow:
+		if len(data) >= 4 {
+			stream.grant(int(binary.BigEndian.Uint32(data)))
+		}
+	case define.KindStreamClose:
+		stream.remoteClose()
+	case define.KindStreamReset:
+		frameErr := DecodeFrameError(message)
+		stream.abort(fmt.Errorf("%w, %v", ErrStreamReset, frameErr.Message))
+	}
+}
+
+//abort all streams, like connect closed
+func (f *Mux) Close(err error) {
+	f.Lock()
+	if f.closeErr != nil {
+		f.Unlock()
+		return
+	}
+	f.closeErr = err
+	streams := f.streams
+	f.streams = map[uint32]*Stream{}
+	f.Unlock()
+	for _, stream := range streams {
+		stream.abort(err)
+	}
+}
+
+//get count of opened streams
+func (f *Mux) GetStreamCount() int {
+	f.RLock()
+	defer f.RUnlock()
+	return len(f.streams)
+}
+
+///////////////////
+//api for stream
+///////////////////
+
+func (s *Stream) GetStreamId() uint32 {
+	return s.streamId
+}
+
+//read data of peer, io.EOF after peer closed
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.Lock()
+		if s.readClosed {
+			s.Unlock()
+			return 0, io.ErrClosedPipe
+		}
+		if s.readBuff.Len() > 0 {
+			size, _ := s.readBuff.Read(p)
+			grant := s.consume(size)
+			s.Unlock()
+			if grant > 0 {
+				data := make([]byte, 4)
+				binary.BigEndian.PutUint32(data, uint32(grant))
+				s.mux.send(MakeKind(define.KindStreamWindow, 0), s.streamId, data)
+			}
+			return size, nil
+		}
+		if s.readErr != nil {
+			err := s.readErr
+			s.Unlock()
+			return 0, err
+		}
+		deadline := s.readDeadline
+		s.Unlock()
+		if len(p) <= 0 {
+			return 0, nil
+		}
+
+		//wait data of peer
+		if err := waitSignal(s.readChan, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+//write data in frames, blocked if window used up
+func (s *Stream) Write(p []byte) (int, error) {
+	s.writeLocker.Lock()
+	defer s.writeLocker.Unlock()
+	written := 0
+	for written < len(p) {
+		s.Lock()
+		if s.writeErr != nil {
+			err := s.writeErr
+			s.Unlock()
+			return written, err
+		}
+		if s.sendWindow <= 0 {
+			deadline := s.writeDeadline
+			s.Unlock()
+			if err := waitSignal(s.writeChan, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		size := len(p) - written
+		if size > s.sendWindow {
+			size = s.sendWindow
+		}
+		if size > s.mux.chunkSize {
+			size = s.mux.chunkSize
+		}
+		s.sendWindow -= size
+		s.Unlock()
+
+		//send one frame
+		data := append([]byte{}, p[written:written + size]...)
+		if err := s.mux.send(MakeKind(define.KindStreamData, 0), s.streamId, data); err != nil {
+			return written, err
+		}
+		written += size
+	}
+	return written, nil
+}
+
+//close write side, peer read io.EOF after left data
+func (s *Stream) CloseWrite() error {
+	s.Lock()
+	if s.writeErr != nil {
+		s.Unlock()
+		return nil
+	}
+	s.writeErr = io.ErrClosedPipe
+	s.Unlock()
+	notifySignal(s.writeChan)
+
+	//send after frames of writing
+	s.writeLocker.Lock()
+	err := s.mux.send(MakeKind(define.KindStreamClose, 0), s.streamId, []byte{})
+	s.writeLocker.Unlock()
+	s.Lock()
+	s.writeDone = true
+	s.Unlock()
+	s.checkDone()
+	return err
+}
+
+//close both sides, data of peer refused after closed
+func (s *Stream) Close() error {
+	err := s.CloseWrite()
+	s.Lock()
+	s.readClosed = true
+	s.readBuff.Reset()
+	s.Unlock()
+	notifySignal(s.readChan)
+	return err
+}
+
+//abort both sides, peer got reset error
+func (s *Stream) Reset() error {
+	s.abort(io.ErrClosedPipe)
+	frameErr := NewFrameError(define.FrameErrCanceled, "reset by peer")
+	return s.mux.sendReset(s.streamId, frameErr)
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.mux.localAddr
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.mux.remoteAddr
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+//set read deadline, os.ErrDeadlineExceeded returned after it
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.Lock()
+	s.readDeadline = t
+	s.Unlock()
+	notifySignal(s.readChan)
+	return nil
+}
+
+//set write deadline, os.ErrDeadlineExceeded returned after it
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.Lock()
+	s.writeDeadline = t
+	s.Unlock()
+	notifySignal(s.writeChan)
+	return nil
+}
+
+///////////////
+//private func
+///////////////
+
+//init stream with default windows
+func newStream(mux *Mux, streamId uint32) *Stream {
+	this := &Stream{
+		mux: mux,
+		streamId: streamId,
+		sendWindow: define.StreamWindowSize,
+		recvWindow: define.StreamWindowSize,
+		readChan: make(chan struct{}, 1),
+		writeChan: make(chan struct{}, 1),
+	}
+	return this
+}
+
+//accept stream opened by peer
+func (f *Mux) accept(streamId uint32) {
+	var (
+		frameErr *FrameError
+	)
+	//check stream id and limit
+	f.Lock()
+	_, ok := f.streams[streamId]
+	switch {
+	case f.closeErr != nil:
+		f.Unlock()
+		return
+	case ok || streamId == 0 || streamId % 2 == f.nextId % 2:
+		frameErr = NewFrameError(define.FrameErrBadFrame, "invalid stream id")
+	case len(f.streams) >= define.StreamMaxOpen:
+		frameErr = NewFrameError(define.FrameErrRejected, "too many streams")
+	case f.cbForAccept == nil:
+		frameErr = NewFrameError(define.FrameErrNoHandler, "stream not supported")
+	}
+	if frameErr != nil {
+		f.Unlock()
+		f.sendReset(streamId, frameErr)
+		return
+	}
+	stream := newStream(f, streamId)
+	f.streams[streamId] = stream
+	cb := f.cbForAccept
+	f.Unlock()
+
+	//call cb in background
+	go func() {
+		var (
+			m any = nil
+		)
+		defer func() {
+			if err := recover(); err != m {
+				log.Printf("mux.accept panic, err:%v\n", err)
+				stream.Reset()
+			}
+		}()
+		cb(stream)
+	}()
+}
+
+//remove stream, frames of it dropped
+func (f *Mux) remove(streamId uint32) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.streams, streamId)
+}
+
+//send reset frame with error
+func (f *Mux) sendReset(streamId uint32, frameErr *FrameError) error {
+	return f.send(MakeKind(define.KindStreamReset, 0), streamId, frameErr.Encode())
+}
+
+//buffer data of peer, reset if over window or read closed
+func (s *Stream) receive(data []byte) {
+	s.Lock()
+	switch {
+	case s.readClosed:
+		s.Unlock()
+		s.abort(io.ErrClosedPipe)
+		s.mux.sendReset(s.streamId, NewFrameError(define.FrameErrCanceled, "stream closed"))
+		return
+	case s.readErr != nil:
+		//after close frame, dropped
+		s.Unlock()
+		return
+	case len(data) > s.recvWindow:
+		s.Unlock()
+		s.abort(io.ErrClosedPipe)
+		s.mux.sendReset(s.streamId, NewFrameError(define.FrameErrTooLarge, "stream window overflow"))
+		return
+	}
+	s.recvWindow -= len(data)
+	s.readBuff.Write(data)
+	s.Unlock()
+	notifySignal(s.readChan)
+}
+
+//add window granted by peer
+func (s *Stream) grant(size int) {
+	s.Lock()
+	s.sendWindow += size
+	s.Unlock()
+	notifySignal(s.writeChan)
+}
+
+//peer write side closed
+func (s *Stream) remoteClose() {
+	s.Lock()
+	if s.readErr == nil {
+		s.readErr = io.EOF
+	}
+	s.remoteDone = true
+	s.Unlock()
+	notifySignal(s.readChan)
+	s.checkDone()
+}
+
+//abort both sides with error, left data can be read
+func (s *Stream) abort(err error) {
+	s.Lock()
+	if s.readErr == nil || s.readErr == io.EOF {
+		s.readErr = err
+	}
+	s.writeErr = err
+	s.writeDone = true
+	s.remoteDone = true
+	s.Unlock()
+	notifySignal(s.readChan)
+	notifySignal(s.writeChan)
+	s.mux.remove(s.streamId)
+}
+
+//remove from mux if both sides closed
+func (s *Stream) checkDone() {
+	s.Lock()
+	done := s.writeDone && s.remoteDone
+	s.Unlock()
+	if done {
+		s.mux.remove(s.streamId)
+	}
+}
+
+//count read data, return size should be granted
+//granted after half of window read
+func (s *Stream) consume(size int) int {
+	s.unacked += size
+	if s.unacked < define.StreamWindowSize / 2 || s.readErr != nil {
+		return 0
+	}
+	grant := s.unacked
+	s.recvWindow += grant
+	s.unacked = 0
+	return grant
+}
+
+//wait signal until deadline
+func waitSignal(signal chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		<- signal
+		return nil
+	}
+	timeOut := time.Until(deadline)
+	if timeOut <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(timeOut)
+	defer timer.Stop()
+	select {
+	case <- signal:
+		return nil
+	case <- timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+//notify waiter without block
+func notifySignal(signal chan struct{}) {
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,27 @@
+package face
+
+import (
+	"context"
+	"sync"
+)
+
+/*
+ * util for face
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ */
+
+//wait group done or context done
+func WaitWithContext(ctx context.Context, wg *sync.WaitGroup) error {
+	doneChan := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(doneChan)
+	}()
+	select {
+	case <- doneChan:
+		return nil
+	case <- ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,377 @@
+package face
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * face for websocket connect
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - rfc 6455 handshake and framing
+ * - cree packet carried in binary message
+ * - read as stream, one write as one binary message
+ */
+
+//inter macro define
+const (
+	wsGUID            = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsOpContinuation  = 0x0
+	wsOpText          = 0x1
+	wsOpBinary        = 0x2
+	wsOpClose         = 0x8
+	wsOpPing          = 0x9
+	wsOpPong          = 0xA
+	wsMaxControlSize  = 125
+	wsMaxHeaderSize   = 14
+)
+
+//inter error define
+var (
+	ErrWsProtocol  = errors.New("websocket protocol error")
+	ErrWsHandshake = errors.New("websocket handshake failed")
+)
+
+//face info
+type WsConn struct {
+	net.Conn
+	reader      *bufio.Reader
+	isServer    bool
+	host        string //client side, host header
+	path        string //request path
+	remain      int64  //payload left of current frame
+	masked      bool
+	maskKey     [4]byte
+	maskPos     int
+	readClosed  bool
+	writeClosed bool
+	writeLocker sync.Mutex
+}
+
+//websocket listener
+type WsListener struct {
+	net.Listener
+	path string
+}
+
+//construct
+//handshake should be called before read and write
+func NewWsConn(conn net.Conn, isServer bool, host, path string) *WsConn {
+	if path == "" {
+		path = "/"
+	}
+	this := &WsConn{
+		Conn: conn,
+		reader: bufio.NewReader(conn),
+		isServer: isServer,
+		host: host,
+		path: path,
+	}
+	return this
+}
+
+//construct listener, accepted connect need handshake
+func NewWsListener(listener net.Listener, path string) *WsListener {
+	this := &WsListener{
+		Listener: listener,
+		path: path,
+	}
+	return this
+}
+
+//accept new websocket connect
+func (l *WsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return NewWsConn(conn, true, "", l.path), nil
+}
+
+//handshake
+func (c *WsConn) Handshake() error {
+	if c.isServer {
+		return c.serverHandshake()
+	}
+	return c.clientHandshake()
+}
+
+//get tls connect state, used for wss
+func (c *WsConn) ConnectionState() tls.ConnectionState {
+	if tlsConn, ok := c.Conn.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		return tlsConn.ConnectionState()
+	}
+	return tls.ConnectionState{}
+}
+
+//read payload data of data frames
+func (c *WsConn) Read(p []byte) (int, error) {
+	//read next data frame
+	for c.remain <= 0 {
+		if c.readClosed {
+			return 0, io.EOF
+		}
+		if err := c.readFrameHeader(); err != nil {
+			return 0, err
+		}
+	}
+
+	//read payload
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.reader.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.maskKey[c.maskPos % 4]
+			c.maskPos++
+		}
+	}
+	c.remain -= int64(n)
+	return n, err
+}
+
+//write data as one binary message
+func (c *WsConn) Write(p []byte) (int, error) {
+	if err := c.writeFrame(wsOpBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//close with close frame
+func (c *WsConn) Close() error {
+	c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(wsOpClose, nil)
+	return c.Conn.Close()
+}
+
+///////////////
+//private func
+///////////////
+
+//read one frame header, process control frames
+func (c *WsConn) readFrameHeader() error {
+	var (
+		head [2]byte
+	)
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return err
+	}
+	opCode := head[0] & 0x0F
+	masked := head[1] & 0x80 != 0
+	payloadLen := int64(head[1] & 0x7F)
+
+	//client frame must be masked, server frame must not
+	if masked != c.isServer {
+		return ErrWsProtocol
+	}
+
+	//read extend length
+	switch payloadLen {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		payloadLen = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return err
+		}
+		payloadLen = int64(binary.BigEndian.Uint64(ext[:]))
+		if payloadLen < 0 {
+			return ErrWsProtocol
+		}
+	}
+
+	//read mask key
+	if masked {
+		if _, err := io.ReadFull(c.reader, c.maskKey[:]); err != nil {
+			return err
+		}
+	}
+
+	//data frame
+	switch opCode {
+	case wsOpContinuation, wsOpText, wsOpBinary:
+		c.remain = payloadLen
+		c.masked = masked
+		c.maskPos = 0
+		return nil
+	}
+
+	//control frame
+	if payloadLen > wsMaxControlSize {
+		return ErrWsProtocol
+	}
+	payload := make([]byte, payloadLen)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return err
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= c.maskKey[i % 4]
+		}
+	}
+	switch opCode {
+	case wsOpPing:
+		return c.writeFrame(wsOpPong, payload)
+	case wsOpPong:
+		return nil
+	case wsOpClose:
+		c.readClosed = true
+		c.writeFrame(wsOpClose, payload)
+		return io.EOF
+	}
+	return ErrWsProtocol
+}
+
+//write one frame
+func (c *WsConn) writeFrame(opCode byte, payload []byte) error {
+	var (
+		header [wsMaxHeaderSize]byte
+		headLen = 2
+	)
+	c.writeLocker.Lock()
+	defer c.writeLocker.Unlock()
+	if c.writeClosed {
+		return net.ErrClosed
+	}
+	if opCode == wsOpClose {
+		c.writeClosed = true
+	}
+
+	//init header
+	header[0] = 0x80 | opCode
+	payloadLen := len(payload)
+	switch {
+	case payloadLen <= wsMaxControlSize:
+		header[1] = byte(payloadLen)
+	case payloadLen <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(payloadLen))
+		headLen += 2
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(payloadLen))
+		headLen += 8
+	}
+
+	//server frame not masked
+	if c.isServer {
+		buffers := net.Buffers{header[:headLen], payload}
+		_, err := buffers.WriteTo(c.Conn)
+		return err
+	}
+
+	//client frame masked
+	header[1] |= 0x80
+	maskKey := header[headLen:headLen + 4]
+	if _, err := rand.Read(maskKey); err != nil {
+		return err
+	}
+	headLen += 4
+	frame := make([]byte, headLen + payloadLen)
+	copy(frame, header[:headLen])
+	for i, b := range payload {
+		frame[headLen + i] = b ^ maskKey[i % 4]
+	}
+	_, err := c.Conn.Write(frame)
+	return err
+}
+
+//server side handshake
+func (c *WsConn) serverHandshake() error {
+	//read upgrade request
+	req, err := http.ReadRequest(c.reader)
+	if err != nil {
+		return err
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if req.Method != http.MethodGet ||
+		!headerContains(req.Header, "Connection", "upgrade") ||
+		!headerContains(req.Header, "Upgrade", "websocket") ||
+		req.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		c.Conn.Write([]byte("HTTP/1.1 400 Bad Request\r\nConnection: close\r\n\r\n"))
+		return ErrWsHandshake
+	}
+	if c.path != "/" && req.URL.Path != c.path {
+		c.Conn.Write([]byte("HTTP/1.1 404 Not Found\r\nConnection: close\r\n\r\n"))
+		return ErrWsHandshake
+	}
+
+	//write switch response
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n\r\n"
+	_, err = c.Conn.Write([]byte(resp))
+	return err
+}
+
+//client side handshake
+func (c *WsConn) clientHandshake() error {
+	//gen key
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	//write upgrade request
+	req := fmt.Sprintf("GET %s HTTP/1.1\r\n" +
+		"Host: %s\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: %s\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n", c.path, c.host, key)
+	if _, err := c.Conn.Write([]byte(req)); err != nil {
+		return err
+	}
+
+	//read switch response
+	resp, err := http.ReadResponse(c.reader, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != wsAcceptKey(key) {
+		return ErrWsHandshake
+	}
+	return nil
+}
+
+//gen accept key
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+//check header contains token
+func headerContains(header http.Header, name, token string) bool {
+	for _, v := range header.Values(name) {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
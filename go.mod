@@ -1,5 +1,5 @@
 module github.com/andyzhou/cree
 
-go 1.19
+go 1.20
 
 require github.com/andyzhou/tinylib v0.0.0-20251020070222-281548875d75 // indirect
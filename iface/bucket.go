@@ -1,6 +1,10 @@
 package iface
 
-import "github.com/andyzhou/cree/define"
+import (
+	"context"
+
+	"github.com/andyzhou/cree/define"
+)
 
 /*
  * interface for bucket
@@ -11,19 +15,28 @@ import "github.com/andyzhou/cree/define"
 type IBucket interface {
 	//gen opt
 	Quit()
+	StopRead()
+	WaitRead(ctx context.Context) error
+	Flush(ctx context.Context) error
 
 	//conn opt
 	GetConnect(connId int64) (IConnect, error)
+	GetConnects() []IConnect
 	RemoveConnect(connId int64) error
+	DetachConnect(connId int64) error
 	AddConnect(conn IConnect) error
 
 	//msg opt
 	SendMessage(req *define.SendMsgReq) error
+	DispatchRead(conn IConnect, req IRequest, err error) bool
 
 	//general
+	SetReadMode(mode int)
 	SetErrMsgId(id uint32) error
+	SetPacket(packet IPacket)
 
 	//set cb
 	SetCBForReadMessage(cb func(IConnect, IRequest) error)
+	SetCBForGroupMessage(cb func(IConnect, IRequest) error)
 	SetCBForDisconnected(cb func(IConnect))
 }
@@ -0,0 +1,12 @@
+package iface
+
+/*
+ * interface for message data codec
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ */
+
+type ICodec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(data []byte, v any) error
+}
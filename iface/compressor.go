@@ -0,0 +1,12 @@
+package iface
+
+/*
+ * interface for payload compressor
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ */
+
+type ICompressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte, limit int) ([]byte, error) //failed if decompressed size over limit
+}
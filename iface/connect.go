@@ -1,6 +1,12 @@
 package iface
 
-import "net"
+import (
+	"context"
+	"crypto/x509"
+	"net"
+
+	"github.com/andyzhou/cree/define"
+)
 
 /*
  * interface for connect
@@ -12,15 +18,35 @@ import "net"
 	//base
 	Quit()
  	SendMessage(uint32, []byte) error
+	SendFrame(kind uint32, messageId uint32, data []byte) error
+	Request(ctx context.Context, messageId uint32, data []byte) (IMessage, error)
 	SendData([]byte) error
 	ReadMessage() (IRequest, error)
+	OpenStream() (IStream, error)
+
+	//for write queue
+	Flush(ctx context.Context) error
+	GetSendQueueLen() int
+	GetDropCount() int64
 
 	//get base
 	GetActiveTime() int64
- 	GetConn() *net.TCPConn
+ 	GetConn() net.Conn
  	GetConnId() int64
+	GetListener() string
  	GetRemoteAddr() net.Addr
 
+	//for tls
+	GetPeerCertificate() *x509.Certificate
+	GetPeerIdentity() string
+
+	//for hello, nil before negotiated
+	GetCapabilities() *define.Capabilities
+
+	//for proxy protocol
+	GetProxyAddr() net.Addr
+	GetProxyTLVs() []define.ProxyTLV
+
 	//for tag
 	RemoveTags(tags ...string) error
 	GetTags() map[string]bool
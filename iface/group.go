@@ -1,5 +1,6 @@
 package iface
 
+import "context"
 
 /*
  * interface for group
@@ -10,7 +11,10 @@ package iface
 type IGroup interface {
 	Clear()
 	SendMessage(msgId uint32, msg []byte) error
+	Request(ctx context.Context, msgId uint32, msg []byte) (map[int64]IMessage, map[int64]error)
 	Quit(connections ...IConnect) error
 	Join(conn IConnect) error
+	HandleMessage(conn IConnect, req IRequest) error
 	SetErrMsgId(msgId uint32)
+	SetPacket(packet IPacket)
 }
@@ -1,5 +1,7 @@
 package iface
 
+import "io"
+
 /*
  * interface for message handler
  * @author <AndyZhou>
@@ -10,4 +12,12 @@ package iface
  	DoMessageHandle(IRequest) error
  	AddRouter(uint32,IRouter) error
  	RegisterRedirect(IRouter) error
+	SetCBForHello(cb func(IConnect, IRequest) error)
+	OpenStream(req IRequest, reader io.ReadCloser) bool
+
+	//for response waiter
+	NextSeq() uint32
+	AddWaiter(connId int64, seq uint32) (<-chan IMessage, error)
+	RemoveWaiter(connId int64, seq uint32)
+	CloseWaiters(connId int64)
  }
@@ -3,8 +3,12 @@ package iface
 //interface of packet
 type IPacket interface {
 	UnPack(data []byte) (IMessage, error)
+	UnPackTo(data []byte, message IMessage) error
+	Decode(data []byte) (IMessage, int, error) //return consumed size, 0 if data not enough
 	Pack(message IMessage) ([]byte, error)
-	GetHeadLen() uint32
+	AppendPack(dst []byte, message IMessage) ([]byte, error)
+	GetHeadLen() uint32 //0 if header not fixed
 	SetLittleEndian(littleEndian bool)
 	SetMaxPackSize(size int)
+	GetMaxPackSize() int
 }
@@ -9,4 +9,6 @@ package iface
  type IRequest interface {
  	GetConnect() IConnect
  	GetMessage() IMessage
+	Reply(data []byte) error
+	ReplyError(err error) error
  }
\ No newline at end of file
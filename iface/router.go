@@ -1,9 +1,12 @@
 package iface
 
+import "io"
+
 /*
  * interface for request router
  * @author <AndyZhou>
  * @mail <diudiu8848@163.com>
+ * - request and message data reused after handle returned, copy data if kept
  */
 
  //interface info
@@ -12,3 +15,10 @@ package iface
  	Handle(IRequest)
  	PostHandle(IRequest)
  }
+
+//interface of stream router
+//data read from reader instead of request message, fragments of large message read as they arrive
+type IStreamRouter interface {
+	IRouter
+	HandleStream(req IRequest, reader io.Reader)
+}
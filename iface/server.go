@@ -1,5 +1,7 @@
 package iface
 
+import "context"
+
 /*
  * server interface
  * @author <AndyZhou>
@@ -11,6 +13,7 @@ package iface
 	//general
  	Start()
  	Stop()
+	Shutdown(ctx context.Context) error
 	GetPacket()IPacket
 
 	//setup
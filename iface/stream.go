@@ -0,0 +1,16 @@
+package iface
+
+import "net"
+
+/*
+ * interface for multiplexed stream
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ */
+
+type IStream interface {
+	net.Conn
+	GetStreamId() uint32
+	CloseWrite() error //peer read io.EOF, read side kept
+	Reset() error      //abort both sides, peer got reset error
+}
@@ -0,0 +1,359 @@
+package cree
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+)
+
+/*
+ * listener for server
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - all listeners share routers, groups and buckets
+ * - each listener has its own max connects and tls
+ */
+
+//listener config
+type ListenerConf struct {
+	Name        string //unique name, recorded on accepted connect
+	Host        string
+	Port        int
+	Address     string //like unix:///tmp/cree.sock or ws://0.0.0.0:5301/cree, used instead of host and port
+	TcpVersion  string //like tcp, tcp4, tcp6, default as server conf
+	MaxConnects int32  //max connects of this listener, 0 means no limit
+	Acceptors   int    //accept loops on same port with SO_REUSEPORT, default 1
+	TLS         *define.TLSConf //enable tls if not nil
+
+	//for proxy protocol
+	ProxyProtocol  bool     //parse proxy protocol v1 or v2 header of trusted source
+	TrustedProxies []string //cidr of trusted proxy, like 10.0.0.0/8, empty means trust none
+}
+
+//listener info
+type listenerInfo struct {
+	conf      *ListenerConf
+	listeners []net.Listener //one accept loop per listener
+	raws      []net.Listener //listeners before wrapped, handed off on upgrade
+	connects  int32
+}
+
+//add new listener
+//if called before listen, listened when server listen
+func (s *Server) AddListener(conf *ListenerConf) error {
+	//check
+	if conf == nil || conf.Name == "" {
+		return errors.New("invalid parameter")
+	}
+	if conf.TcpVersion == "" {
+		conf.TcpVersion = s.conf.TcpVersion
+	}
+
+	//get network and address
+	info, err := parseAddress(conf.Address,
+		conf.TcpVersion, conf.Host, conf.Port)
+	if err != nil {
+		log.Printf("cree.server, parse address failed, err:%v", err.Error())
+		return err
+	}
+
+	//keep until server listen
+	s.listenerLocker.Lock()
+	if !s.listening {
+		defer s.listenerLocker.Unlock()
+		if s.isQuit() {
+			return ErrServerClosed
+		}
+		s.pendingConfs = append(s.pendingConfs, conf)
+		return nil
+	}
+	s.listenerLocker.Unlock()
+
+	//inherit listeners of parent process, or begin listen
+	listeners := s.takeInherited(conf.Name)
+	switch {
+	case listeners != nil:
+	case conf.Acceptors > 1:
+		if info.network == networkUnix {
+			return errors.New("cree.server, unix socket not support multi acceptors")
+		}
+		listeners, err = listenReusePort(info.network, info.address, conf.Acceptors)
+	default:
+		var listener net.Listener
+		listener, err = listen(info.network, info.address)
+		listeners = []net.Listener{listener}
+	}
+	if err != nil {
+		log.Printf("cree.server, listen on %v failed, err:%v", info.address, err.Error())
+		return err
+	}
+
+	//wrap and watch
+	if err = s.serveListener(conf, listeners, info); err != nil {
+		for _, v := range listeners {
+			v.Close()
+		}
+		return err
+	}
+	return nil
+}
+
+//get connect count of listener
+func (s *Server) GetListenerConnects(name string) int32 {
+	li := s.getListener(name)
+	if li == nil {
+		return 0
+	}
+	return atomic.LoadInt32(&li.connects)
+}
+
+//get bound address of listener, like real port of port 0
+//nil if not listened
+func (s *Server) GetListenerAddr(name string) net.Addr {
+	li := s.getListener(name)
+	if li == nil || len(li.raws) <= 0 {
+		return nil
+	}
+	return li.raws[0].Addr()
+}
+
+///////////////
+//private func
+///////////////
+
+//wrap listeners and watch new connect
+func (s *Server) serveListener(
+	conf *ListenerConf,
+	listeners []net.Listener,
+	info *addressInfo) error {
+	//wrap proxy protocol, tls and websocket listener
+	wrapped := make([]net.Listener, 0, len(listeners))
+	for _, listener := range listeners {
+		v, err := s.wrapListener(listener, info, conf)
+		if err != nil {
+			return err
+		}
+		wrapped = append(wrapped, v)
+	}
+
+	//sync into listener map with locker
+	s.listenerLocker.Lock()
+	defer s.listenerLocker.Unlock()
+	if s.isQuit() {
+		return errors.New("cree.server, server is quit")
+	}
+	if _, ok := s.listenerMap[conf.Name]; ok {
+		return fmt.Errorf("cree.server, listener %v already exists", conf.Name)
+	}
+	li := &listenerInfo{
+		conf: conf,
+		listeners: wrapped,
+		raws: listeners,
+	}
+	s.listenerMap[conf.Name] = li
+
+	//watch new connect, one accept loop per listener
+	for _, listener := range wrapped {
+		s.acceptWg.Add(1)
+		go s.watchConn(li, listener)
+	}
+	return nil
+}
+
+//wrap listener with proxy protocol, tls and websocket
+func (s *Server) wrapListener(
+	listener net.Listener,
+	info *addressInfo,
+	conf *ListenerConf) (net.Listener, error) {
+	//check
+	tlsConf := conf.TLS
+	if info.secure && tlsConf == nil {
+		return nil, errors.New("cree.server, wss address need tls config")
+	}
+	if s.conf.Engine == define.EngineEpoll &&
+		(tlsConf != nil || info.webSocket) {
+		return nil, errors.New("cree.server, epoll engine not support tls or websocket")
+	}
+
+	//wrap proxy protocol listener, header before tls
+	if conf.ProxyProtocol {
+		proxyListener, err := face.NewProxyListener(listener, conf.TrustedProxies)
+		if err != nil {
+			log.Printf("cree.server, parse trusted proxies failed, err:%v", err.Error())
+			return nil, err
+		}
+		listener = proxyListener
+	}
+
+	//wrap tls listener
+	if tlsConf != nil {
+		tlsConfig, err := buildTLSConfig(tlsConf, true)
+		if err != nil {
+			log.Printf("cree.server, init tls config failed, err:%v", err.Error())
+			return nil, err
+		}
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	//wrap websocket listener
+	if info.webSocket {
+		listener = face.NewWsListener(listener, info.path)
+	}
+	return listener, nil
+}
+
+//close all listeners and handshaking connects
+func (s *Server) closeListeners() {
+	s.listenerLocker.RLock()
+	for _, li := range s.listenerMap {
+		for _, listener := range li.listeners {
+			listener.Close()
+		}
+	}
+	s.listenerLocker.RUnlock()
+	s.handshakeConns.Range(func(key, value any) bool {
+		key.(net.Conn).Close()
+		return true
+	})
+}
+
+//take inherited listeners of parent process by name
+func (s *Server) takeInherited(name string) []net.Listener {
+	s.listenerLocker.Lock()
+	defer s.listenerLocker.Unlock()
+	listeners, ok := s.inheritMap[name]
+	if !ok {
+		return nil
+	}
+	delete(s.inheritMap, name)
+	return listeners
+}
+
+//get listener by name
+func (s *Server) getListener(name string) *listenerInfo {
+	s.listenerLocker.RLock()
+	defer s.listenerLocker.RUnlock()
+	return s.listenerMap[name]
+}
+
+//watch new connect of listener
+func (s *Server) watchConn(li *listenerInfo, listener net.Listener) {
+	var (
+		delay time.Duration
+		m any = nil
+	)
+
+	//defer
+	defer func() {
+		if subErr := recover(); subErr != m {
+			log.Printf("cree.server, watch connect panic err:%v, trace:%v\n",
+						subErr, string(debug.Stack()))
+		}
+		s.acceptWg.Done()
+	}()
+
+	//loop
+	for {
+		//get new connect
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) || s.isQuit() {
+				return
+			}
+
+			//retry temporary error with backoff, like too many open files
+			if v, ok := err.(interface{ Temporary() bool }); ok && v.Temporary() {
+				delay = acceptDelay(delay)
+				log.Printf("cree.server, accept connect failed, err:%v, retry in %v", err.Error(), delay)
+				time.Sleep(delay)
+				continue
+			}
+			log.Printf("cree.server, listener %v accept failed, err:%v", li.conf.Name, err.Error())
+			s.reportFatal(fmt.Errorf("cree.server, listener %v accept failed, err:%w", li.conf.Name, err))
+			return
+		}
+		delay = 0
+
+		//check max connects
+		if !s.reserveConn(li) {
+			log.Printf("cree.server, connect up to max count of listener %v\n", li.conf.Name)
+			conn.Close()
+			continue
+		}
+
+		//proxy, tls or websocket handshake without blocking accept
+		if hsConn, ok := conn.(connHandshaker); ok {
+			s.acceptWg.Add(1)
+			s.handshakeConns.Store(conn, true)
+			go s.handshakeConn(hsConn, li)
+			continue
+		}
+
+		//process new connect
+		s.addConn(conn, li)
+	}
+}
+
+//handshake for new connect, like proxy header, tls or websocket
+func (s *Server) handshakeConn(conn connHandshaker, li *listenerInfo) {
+	defer func() {
+		s.handshakeConns.Delete(conn)
+		s.acceptWg.Done()
+	}()
+	if s.isQuit() {
+		//shutdown begin after accepted
+		conn.Close()
+		s.releaseConn(li)
+		return
+	}
+	conn.SetDeadline(time.Now().Add(define.DefaultHandshakeTimeOut * time.Second))
+	if err := conn.Handshake(); err != nil {
+		log.Println("cree.server, handshake failed, err:", err.Error())
+		conn.Close()
+		s.releaseConn(li)
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	s.addConn(conn, li)
+}
+
+//get next delay of accept retry
+func acceptDelay(delay time.Duration) time.Duration {
+	if delay <= 0 {
+		return define.AcceptRetryMinDelay * time.Millisecond
+	}
+	delay *= 2
+	if delay > define.AcceptRetryMaxDelay * time.Millisecond {
+		delay = define.AcceptRetryMaxDelay * time.Millisecond
+	}
+	return delay
+}
+
+//reserve connect count of server and listener
+//return false if up to max count
+func (s *Server) reserveConn(li *listenerInfo) bool {
+	connects := atomic.AddInt32(&s.connects, 1)
+	listenerConnects := atomic.AddInt32(&li.connects, 1)
+	if (s.conf.MaxConnects > 0 && connects > s.conf.MaxConnects) ||
+		(li.conf.MaxConnects > 0 && listenerConnects > li.conf.MaxConnects) {
+		s.releaseConn(li)
+		return false
+	}
+	return true
+}
+
+//release connect count of server and listener
+func (s *Server) releaseConn(li *listenerInfo) {
+	atomic.AddInt32(&s.connects, -1)
+	if li != nil {
+		atomic.AddInt32(&li.connects, -1)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package cree
+
+import (
+	"errors"
+	"net"
+)
+
+/*
+ * reuse port listen for server
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - not supported on this os
+ */
+
+//listen on same address with SO_REUSEPORT
+func listenReusePort(network, address string, size int) ([]net.Listener, error) {
+	return nil, errors.New("cree.server, reuse port not supported on this os")
+}
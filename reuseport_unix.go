@@ -0,0 +1,65 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package cree
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+/*
+ * reuse port listen for server
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - listeners on same port, kernel balance new connect
+ */
+
+//listen on same address with SO_REUSEPORT
+func listenReusePort(network, address string, size int) ([]net.Listener, error) {
+	var (
+		listeners []net.Listener
+	)
+	lc := net.ListenConfig{
+		Control: controlReusePort,
+	}
+	for i := 0; i < size; i++ {
+		listener, err := lc.Listen(context.Background(), network, address)
+		if err != nil {
+			for _, v := range listeners {
+				v.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+
+		//bind real port of first listener, like port 0
+		address = listener.Addr().String()
+	}
+	return listeners, nil
+}
+
+//set SO_REUSEPORT before bind
+func controlReusePort(network, address string, rawConn syscall.RawConn) error {
+	var (
+		optErr error
+	)
+	err := rawConn.Control(func(fd uintptr) {
+		optErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, soReusePort(), 1)
+	})
+	if err != nil {
+		return err
+	}
+	return optErr
+}
+
+//get SO_REUSEPORT option value
+//syscall of linux not define it
+func soReusePort() int {
+	if runtime.GOOS == "linux" && !strings.HasPrefix(runtime.GOARCH, "mips") {
+		return 0xf
+	}
+	return 0x200
+}
@@ -1,14 +1,17 @@
 package cree
 
 import (
+	"context"
+	"crypto/ecdh"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"net"
 	"runtime"
-	"runtime/debug"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/andyzhou/cree/define"
 	"github.com/andyzhou/cree/face"
@@ -25,15 +28,42 @@ import (
 type ServerConf struct {
 	Host           string
 	Port           int
+	Address        string //like unix:///tmp/cree.sock, used instead of host and port
+	WsAddress      string //like ws://0.0.0.0:5301/cree, extra websocket listener
 	TcpVersion     string //like tcp, tcp4, tcp6
+	Engine         string //io engine, like routine, epoll
+	EventLoops     int    //event loop size for epoll engine, default cpu num
 	MaxConnects    int32
+	Acceptors      int //accept loops on same port with SO_REUSEPORT, default 1
 	MaxPackSize    int //pack data max size
-	ErrMsgId       uint32
+	FragmentBudget int //max reassembled message size of one connect, default define.DefaultFragmentBudget
+	Packet         string //packet codec name, like define.PacketVarint, default define.PacketDefault
+	Checksum       bool   //crc32c in header of default codec, corrupt frame close connect
+	Codec          string //data codec of typed router, like define.CodecGob, default define.CodecJson
+	Compress       []string //compress codecs allowed in negotiation, like define.CompressGzip, empty means disabled
+	CompressThreshold int   //data less than it not compressed, default define.DefaultCompressThreshold
+	HelloRequired  bool     //data frame refused before hello
+	Secure         bool     //require secure channel negotiated by hello, plain data frame refused
+	SecureKey      []byte   //static x25519 private key, public key pinned by client, optional
+	ErrMsgId       uint32 //message id of error frame without request, like decode failed
+	GoAwayMsgId    uint32 //message id sent to all connects on shutdown, 0 means not send
 	Buckets        int //bucket size for tcp connect
-	ReadTickerRate float64
+	ReadTickerRate float64 //legacy bucket polling read rate, 0 means one reader per connect
+	// Deprecated: ignored, send message consumed by queue without ticker, will be removed.
 	SendTickerRate float64
 	LittleEndian   bool
 	GCRate         int //xx seconds
+	TLS            *define.TLSConf //enable tls if not nil
+	ProxyProtocol  bool     //parse proxy protocol header on accept
+	TrustedProxies []string //cidr of trusted proxy, empty means trust none
+
+	//for connect write queue
+	WriteQueueSize    int           //max queued data of one connect
+	WriteOverflow     int           //overflow policy, like define.OverflowBlock
+	WriteBlockTimeOut time.Duration //wait time for block policy
+	WriteTimeOut      int           //xx seconds
+	WriteFlushBytes   int           //max coalesced bytes of one write, -1 means one frame per write
+	WriteFlushWindow  time.Duration //wait time for more frames before write
 }
 
 //face info
@@ -42,29 +72,63 @@ type Server struct {
 	conf         *ServerConf
 	connId       int64
 	connects     int32
-	needQuit     bool
 	littleEndian bool
 	packet       iface.IPacket
+	codec        iface.ICodec //data codec of typed router
+	compressible bool //compress allowed and codec carry kind
+	secureKey    *ecdh.PrivateKey //static key of secure channel
 	handler      iface.IHandler
+	poller       *face.Poller
+	writeConf    *define.WriteQueueConf
 	bucketMap    map[int]iface.IBucket  //idx -> IBucket
 	groupMap     map[int64]iface.IGroup //groupId -> IGroup
+	listenerMap  map[string]*listenerInfo //name -> listener
 
 	//hook
 	cbOfReadMessage   func(iface.IConnect, iface.IRequest) error
 	cbOfConnected     func(iface.IConnect)
+	cbOfHello         func(iface.IConnect, *define.Capabilities) error
+	cbOfStream        func(iface.IConnect, iface.IStream)
 	cbForDisconnected func(iface.IConnect)
 	cbOfGenConnId     func() int64
 
+	//for lifecycle
+	started        int32         //listen called or not
+	listening      bool          //listener added directly if true
+	pendingConfs   []*ListenerConf //listeners added before listen
+	customListener net.Listener  //outside listener for default
+	fatalChan      chan error    //fatal error of listener
+	quitChan       chan struct{} //closed when shutdown begin
+	doneChan       chan struct{} //closed when shutdown done
+	quitOnce       sync.Once
+	acceptWg       sync.WaitGroup //for accept and handshake
+	handshakeConns sync.Map       //handshaking connects, closed on shutdown
+
+	//for hot restart of child process
+	upgradeSock *net.UnixConn             //handoff socket with parent process
+	inheritMap  map[string][]net.Listener //name -> listeners of parent process
+
 	//others
-	wg          sync.WaitGroup
 	groupLocker sync.RWMutex
+	listenerLocker sync.RWMutex
 	sync.RWMutex
 }
 
+//connect need handshake, like tls or websocket
+type connHandshaker interface {
+	net.Conn
+	Handshake() error
+}
+
+//inter error define
+var (
+	ErrServerClosed = errors.New("cree.server, server closed")
+)
+
 //global variable
 var (
 	_server *Server
-	_once   *sync.Once
+	_once   sync.Once
 )
 
 //get single instance
@@ -75,7 +139,7 @@ func GetServer(configs ...*ServerConf) *Server {
 	return _server
 }
 
- //construct
+//construct and listen, panic if failed
 func NewServer(configs ...*ServerConf) *Server {
 	var (
 		conf *ServerConf
@@ -83,7 +147,53 @@ func NewServer(configs ...*ServerConf) *Server {
 	if configs != nil && len(configs) > 0 {
 		conf = configs[0]
 	}
+	this, err := New(conf)
+	if err != nil {
+		panic(any(err))
+	}
+	if err = this.Listen(); err != nil {
+		panic(any(err))
+	}
+	return this
+}
+
+//construct with outside listener, like unix or custom listener
+func NewServerWithListener(listener net.Listener, configs ...*ServerConf) *Server {
+	var (
+		conf *ServerConf
+	)
+	//check
+	if listener == nil {
+		panic(any(errors.New("cree.server, listener is nil")))
+	}
+	if configs != nil && len(configs) > 0 {
+		conf = configs[0]
+	}
 
+	//init and listen
+	this, err := New(conf)
+	if err != nil {
+		panic(any(err))
+	}
+	this.customListener = listener
+	if err = this.Listen(); err != nil {
+		panic(any(err))
+	}
+	return this
+}
+
+//construct without side effects
+//call Listen and Serve to run
+func New(conf *ServerConf) (*Server, error) {
+	this := newServer(conf)
+	if err := this.checkConf(); err != nil {
+		return nil, err
+	}
+	return this, nil
+}
+
+//init server with default conf
+func newServer(conf *ServerConf) *Server {
 	//check and set default conf
 	if conf == nil {
 		conf = &ServerConf{
@@ -94,6 +204,9 @@ func NewServer(configs ...*ServerConf) *Server {
 	if conf.TcpVersion == "" {
 		conf.TcpVersion = define.DefaultTcpVersion
 	}
+	if conf.Engine == "" {
+		conf.Engine = define.EngineRoutine
+	}
 	if conf.Port <= 0 {
 		conf.Port = define.DefaultPort
 	}
@@ -104,34 +217,129 @@ func NewServer(configs ...*ServerConf) *Server {
 	if conf.Buckets <= 0 {
 		conf.Buckets = define.DefaultBuckets
 	}
+	if conf.WriteQueueSize <= 0 {
+		conf.WriteQueueSize = define.ConnectWriteChanSize
+	}
+	if conf.WriteBlockTimeOut <= 0 {
+		conf.WriteBlockTimeOut = define.DefaultWriteBlockTimeOut * time.Second
+	}
+	if conf.WriteTimeOut <= 0 {
+		conf.WriteTimeOut = define.DefaultTcpWriteTimeOut
+	}
+	if conf.WriteFlushBytes == 0 {
+		conf.WriteFlushBytes = define.DefaultWriteFlushBytes
+	}
 
 	//self init
 	this := &Server{
 		conf: conf,
 		bucketMap: map[int]iface.IBucket{},
-		packet: face.NewPacket(),
+		groupMap: map[int64]iface.IGroup{},
+		listenerMap: map[string]*listenerInfo{},
+		fatalChan: make(chan error, 1),
+		quitChan: make(chan struct{}),
+		doneChan: make(chan struct{}),
+		packet: newPacket(conf.Packet, conf.MaxPackSize, conf.Checksum),
+		codec: newCodec(conf.Codec),
 		handler: face.NewHandler(),
+		writeConf: &define.WriteQueueConf{
+			Size: conf.WriteQueueSize,
+			Overflow: conf.WriteOverflow,
+			BlockTimeOut: conf.WriteBlockTimeOut,
+			WriteTimeOut: time.Duration(conf.WriteTimeOut) * time.Second,
+			FlushBytes: conf.WriteFlushBytes,
+			FlushWindow: conf.WriteFlushWindow,
+		},
 	}
-
-	//inter init
-	this.interInit()
+	this.compressible = len(conf.Compress) > 0 && face.HasKind(this.packet)
+	if len(conf.SecureKey) > 0 {
+		this.secureKey, _ = face.ParseSecureKey(conf.SecureKey)
+	}
+	this.handler.SetCBForHello(this.cbForHello)
 	return this
 }
 
-//start
+//listen on conf address, added listeners and extra websocket address
+//buckets and event loops started, server stopped if failed
+func (s *Server) Listen() error {
+	//check
+	if s.isQuit() {
+		return ErrServerClosed
+	}
+	if !atomic.CompareAndSwapInt32(&s.started, 0, 1) {
+		return errors.New("cree.server, server is listening")
+	}
+
+	//inherit listeners if started by upgrade of parent process
+	s.inheritListeners()
+
+	//init buckets and listeners
+	err := s.interInit()
+	if err == nil {
+		err = s.listenAll()
+	}
+	if err != nil {
+		s.Stop()
+		return err
+	}
+	return nil
+}
+
+//serve until ctx done or listener failed, listen first if not listened
+//server stopped before return, if shutdown by others return ErrServerClosed
+func (s *Server) Serve(ctx context.Context) error {
+	//listen first
+	if atomic.LoadInt32(&s.started) == 0 {
+		if err := s.Listen(); err != nil {
+			return err
+		}
+	}
+
+	//wait
+	select {
+	case <- ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	case err := <- s.fatalChan:
+		s.Stop()
+		return err
+	case <- s.doneChan:
+		return ErrServerClosed
+	}
+}
+
+//start, blocked until server stopped
 func (s *Server) Start() {
-	s.wg.Add(1)
-	s.wg.Wait()
+	<- s.doneChan
 }
 
-//stop
+//stop, close all connects without draining
 func (s *Server) Stop() {
-	s.needQuit = true
-	s.wg.Done()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Shutdown(ctx)
 }
 
+//same as stop, kept for compatible
 func (s *Server) StopSkipWg() {
-	s.needQuit = true
+	s.Stop()
+}
+
+//graceful shutdown
+//stop accept, send going away message, wait in-flight handlers,
+//flush outbound queues, then close connects and inter goroutines.
+//if ctx done before drained, connects closed directly.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if !s.beginQuit() {
+		//wait running shutdown
+		select {
+		case <- s.doneChan:
+			return nil
+		case <- ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return s.shutdown(ctx, nil)
 }
 
 //add router for one message id
@@ -145,6 +353,28 @@ func (s *Server) RegisterRedirect(router iface.IRouter) {
 	s.handler.RegisterRedirect(router)
 }
 
+//send message to connects of all buckets
+//filtered by connect ids or tags of request
+func (s *Server) SendMessage(req *define.SendMsgReq) error {
+	var (
+		err error
+	)
+	//check
+	if req == nil || req.Data == nil {
+		return errors.New("invalid parameter")
+	}
+
+	//send by buckets
+	s.RLock()
+	defer s.RUnlock()
+	for _, bucket := range s.bucketMap {
+		if subErr := bucket.SendMessage(req); subErr != nil {
+			err = subErr
+		}
+	}
+	return err
+}
+
 //del dynamic group
 func (s *Server) DelGroup(groupId int64) error {
 	//get group
@@ -187,6 +417,7 @@ func (s *Server) GetGroup(groupId int64) (iface.IGroup, error) {
 
 //create dynamic group
 //hookOfReadMsg -> func(groupId, IConnect, IRequest) error
+//request reused after hook returned, should not be kept
 func (s *Server) CreateGroup(
 		groupId int64,
 		hookOfReadMsg func(int64, iface.IConnect, iface.IRequest) error,
@@ -197,11 +428,24 @@ func (s *Server) CreateGroup(
 		return nil, errors.New("invalid parameter")
 	}
 
+	//group member read by owner bucket if not legacy read mode
+	//read by ticker of group in legacy mode, default rate if not set
+	if s.conf.ReadTickerRate <= 0 || s.poller != nil {
+		readMsgRates = nil
+	}else if len(readMsgRates) <= 0 || readMsgRates[0] <= 0 {
+		readMsgRates = []float64{define.DefaultBucketReadRate}
+	}
+
 	//create new group
 	group := face.NewGroup(groupId, readMsgRates...)
 	group.SetErrMsgId(s.conf.ErrMsgId)
+	group.SetPacket(s.packet)
 	group.SetCBForReadMessage(hookOfReadMsg)
-	group.SetCBForDisconnect(s.cbForDisconnected)
+	group.SetCBForDisconnect(func(conn iface.IConnect) {
+		if hook := s.getDisconnectedHook(); hook != nil {
+			hook(conn)
+		}
+	})
 
 	//sync with locker
 	s.groupLocker.Lock()
@@ -214,6 +458,16 @@ func (s *Server) GetPacket() iface.IPacket {
 	return s.packet
 }
 
+//get data codec of typed router
+func (s *Server) GetCodec() iface.ICodec {
+	return s.codec
+}
+
+//get count of corrupt frames, checksum enabled
+func (s *Server) GetCorruptCount() int64 {
+	return getCorruptCount(s.packet)
+}
+
 //set max pack size
 func (s *Server) SetMaxPackSize(size int) {
 	s.packet.SetMaxPackSize(size)
@@ -221,6 +475,7 @@ func (s *Server) SetMaxPackSize(size int) {
 
 func (s *Server) SetLittleEndian(littleEndian bool) {
 	s.littleEndian = littleEndian
+	s.packet.SetLittleEndian(littleEndian)
 }
 
 //set max connections
@@ -233,12 +488,14 @@ func (s *Server) SetMaxConnects(maxConnects int32) {
 
 //set hook
 //hook for read message for buckets
+//request reused after hook returned, should not be kept
 func (s *Server) SetReadMessage(hook func(iface.IConnect, iface.IRequest) error) {
 	if hook == nil {
 		return
 	}
 	s.Lock()
 	defer s.Unlock()
+	s.cbOfReadMessage = hook
 	for _, v := range s.bucketMap {
 		v.SetCBForReadMessage(hook)
 	}
@@ -249,21 +506,38 @@ func (s *Server) SetDisconnected(hook func(iface.IConnect)) {
 	if hook == nil {
 		return
 	}
-	//apply to sub buckets
 	s.Lock()
 	defer s.Unlock()
-	for _, v := range s.bucketMap {
-		v.SetCBForDisconnected(hook)
-	}
+	s.cbForDisconnected = hook
 }
 
 //hook for new connected for server
 func (s *Server) SetConnected(hook func(iface.IConnect)) {
+	s.Lock()
+	defer s.Unlock()
 	s.cbOfConnected = hook
 }
 
+//hook for hello of connect, with capabilities offered by client
+//returned error sent as reject frame and connect closed
+func (s *Server) SetHello(hook func(iface.IConnect, *define.Capabilities) error) {
+	s.Lock()
+	defer s.Unlock()
+	s.cbOfHello = hook
+}
+
+//hook for stream opened by client, called in background
+//stream should be closed by hook, reset if hook not set
+func (s *Server) SetStream(hook func(iface.IConnect, iface.IStream)) {
+	s.Lock()
+	defer s.Unlock()
+	s.cbOfStream = hook
+}
+
 //hook for gen new connect id for server
 func (s *Server) SetGenConnId(hook func()int64) {
+	s.Lock()
+	defer s.Unlock()
 	s.cbOfGenConnId = hook
 }
 
@@ -271,68 +545,360 @@ func (s *Server) SetGenConnId(hook func()int64) {
 //private func
 ////////////////
 
-//watch new tcp connect
-func (s *Server) watchConn(listener *net.TCPListener) error {
+//cb for read message of connect which joined group
+func (s *Server) cbForGroupMessage(conn iface.IConnect, req iface.IRequest) error {
+	group, err := s.GetGroup(conn.GetGroupId())
+	if err != nil || group == nil {
+		return err
+	}
+	return group.HandleMessage(conn, req)
+}
+
+//cb for hello frame of connect, check protocol and negotiate capabilities
+//compress and secure channel enabled after reply sent
+func (s *Server) cbForHello(conn iface.IConnect, req iface.IRequest) error {
+	//decode hello
+	message := req.GetMessage()
+	hello := &face.HelloInfo{}
+	if len(message.GetData()) > 0 {
+		if err := json.Unmarshal(message.GetData(), hello); err != nil {
+			return face.NewFrameError(define.FrameErrBadFrame, "invalid hello data")
+		}
+	}
+
+	//negotiated once, secure channel not downgraded by later hello
+	connect, _ := conn.(*face.Connect)
+	if connect != nil && face.GetSecurePacket(connect.GetPacket()) != nil {
+		err := face.NewFrameError(define.FrameErrRejected, "secure channel enabled already")
+		return s.rejectHello(conn, message, err)
+	}
+	if conn.GetCapabilities() != nil {
+		err := face.NewFrameError(define.FrameErrRejected, "capabilities negotiated already")
+		return s.rejectHello(conn, message, err)
+	}
+
+	//check protocol of client, refused by hook
+	if err := s.checkHello(conn, hello); err != nil {
+		return s.rejectHello(conn, message, err)
+	}
+
+	//select compress codec by client preference
+	reply := &face.HelloInfo{}
+	compress := ""
+	if connect != nil {
+		compress = s.selectCompress(hello.Compress)
+	}
+	if compress != "" {
+		reply.Compress = []string{compress}
+	}
+
+	//derive secure keys by client key, once for one connect
 	var (
-		connId int64
-		m any = nil
+		secure *face.SecureState
+		err error
 	)
+	if connect != nil && s.conf.Secure && len(hello.Key) > 0 {
+		secure, reply.Key, err = face.AcceptSecure(hello.Key, s.secureKey)
+		if err != nil {
+			return face.NewFrameError(define.FrameErrBadFrame, err.Error())
+		}
+		if s.secureKey != nil {
+			reply.StaticKey = s.secureKey.PublicKey().Bytes()
+		}
+	}
 
-	//check
-	if listener == nil {
-		return errors.New("cree.server, listener not init")
+	//negotiated capabilities, replied if client has magic
+	capabilities := &define.Capabilities{
+		Version: hello.Version,
+		App: hello.App,
+		AppVersion: hello.AppVersion,
+		Caps: hello.Caps & (define.CapFragment | define.CapStream),
+		Compress: compress,
+		MaxPackSize: s.packet.GetMaxPackSize(),
+	}
+	if compress != "" {
+		capabilities.Caps |= define.CapCompress
+	}
+	if s.conf.Checksum {
+		capabilities.Caps |= define.CapChecksum
+	}
+	if secure != nil {
+		capabilities.Caps |= define.CapSecure
+	}
+	if hello.Magic == define.HelloMagic {
+		reply.Magic = define.HelloMagic
+		reply.Version = hello.Version
+		reply.Caps = capabilities.Caps
+		reply.MaxPackSize = capabilities.MaxPackSize
+		reply.Endian = face.PacketEndian(s.packet)
+	}
+	if connect != nil {
+		connect.SetCapabilities(capabilities)
+	}
+
+	//send reply with same seq
+	data, _ := json.Marshal(reply)
+	kind := face.MakeKind(define.KindHello, face.KindSeq(message.GetKind()))
+	if compress == "" && secure == nil {
+		return conn.SendFrame(kind, message.GetId(), data)
 	}
 
-	//defer
-	defer func() {
-		if subErr := recover(); subErr != m {
-			log.Printf("cree.server, watch connect panic err:%v, trace:%v\n",
-						subErr, string(debug.Stack()))
+	//enable compress and secure channel of connect after reply
+	packet, err := s.newConnPacket(connect.GetPacket(), compress, secure)
+	if err != nil {
+		return err
+	}
+	return connect.SwitchPacket(packet, kind, message.GetId(), data)
+}
+
+//cb for stream opened by client
+func (s *Server) cbForStream(conn iface.IConnect, stream iface.IStream) {
+	s.RLock()
+	cbOfStream := s.cbOfStream
+	s.RUnlock()
+	if cbOfStream == nil {
+		stream.Reset()
+		return
+	}
+	cbOfStream(conn, stream)
+}
+
+//cb for connect disconnected from bucket
+func (s *Server) cbForConnDisconnected(conn iface.IConnect) {
+	//wake up response waiters
+	s.handler.CloseWaiters(conn.GetConnId())
+
+	//remove from group
+	if groupId := conn.GetGroupId(); groupId > 0 {
+		group, _ := s.GetGroup(groupId)
+		if group != nil {
+			group.Quit(conn)
 		}
-	}()
+	}
 
-	//loop
-	for {
-		//check max connects
-		if s.conf.MaxConnects > 0 &&
-			s.connects >= s.conf.MaxConnects {
-			log.Printf("cree.server, connect up to max count, config max conn:%v, real conn:%v\n",
-				s.conf.MaxConnects, s.connects)
-			continue
+	//remove from event loop
+	if s.poller != nil {
+		if connect, ok := conn.(*face.Connect); ok {
+			s.poller.RemoveConnect(connect)
 		}
+	}
 
-		//get tcp connect
-		conn, err := listener.AcceptTCP()
-		if err != nil {
-			log.Println("cree.server, accept connect failed, err:", err.Error())
-			continue
+	//release connect count
+	s.releaseConn(s.getListener(conn.GetListener()))
+
+	//call hook
+	if hook := s.getDisconnectedHook(); hook != nil {
+		hook(conn)
+	}
+}
+
+//cb for read result from event loop
+func (s *Server) cbForPollRead(
+	conn *face.Connect,
+	req iface.IRequest,
+	err error) bool {
+	bucket := s.getBucket(conn.GetConnId())
+	if bucket == nil {
+		conn.Quit()
+		return false
+	}
+	return bucket.DispatchRead(conn, req, err)
+}
+
+//add new connect accepted by listener
+func (s *Server) addConn(conn net.Conn, li *listenerInfo) {
+	var (
+		connId int64
+	)
+	//check server quit
+	if s.isQuit() {
+		conn.Close()
+		s.releaseConn(li)
+		return
+	}
+
+	//gen new connect id
+	s.RLock()
+	genConnId := s.cbOfGenConnId
+	s.RUnlock()
+	if genConnId != nil {
+		connId = genConnId()
+	}else{
+		connId = atomic.AddInt64(&s.connId, 1)
+	}
+	if connId <= 0 {
+		log.Println("can't gen new connect id")
+		conn.Close()
+		s.releaseConn(li)
+		return
+	}
+
+	//init new connect obj
+	connect := face.NewConnect(s, conn, connId, s.handler)
+	s.serveConn(connect, li)
+}
+
+//serve new connect, push into bucket and event loop
+func (s *Server) serveConn(connect *face.Connect, li *listenerInfo) {
+	connId := connect.GetConnId()
+	connect.SetWriteConf(s.writeConf)
+	connect.SetSecureRequired(s.conf.Secure)
+	connect.SetHelloRequired(s.conf.HelloRequired)
+	connect.SetFragmentBudget(s.conf.FragmentBudget)
+	connect.SetStreamHandler(func(stream iface.IStream) {
+		s.cbForStream(connect, stream)
+	})
+	if li != nil {
+		connect.SetListener(li.conf.Name)
+	}
+
+	//call cb for new connected
+	s.RLock()
+	cbOfConnected := s.cbOfConnected
+	s.RUnlock()
+	if cbOfConnected != nil {
+		cbOfConnected(connect)
+	}
+
+	//push into target bucket
+	bucket := s.getBucket(connId)
+	if err := bucket.AddConnect(connect); err != nil {
+		log.Println("cree.server, add connect into bucket failed, err:", err.Error())
+		connect.Quit()
+		s.releaseConn(li)
+		return
+	}
+
+	//watch by event loop
+	if s.poller != nil {
+		if err := s.poller.AddConnect(connect); err != nil {
+			log.Println("cree.server, add connect into event loop failed, err:", err.Error())
+			bucket.RemoveConnect(connId)
+		}
+	}
+}
+
+//begin quit, return false if began already
+func (s *Server) beginQuit() bool {
+	var (
+		first bool
+	)
+	s.quitOnce.Do(func() {
+		first = true
+		close(s.quitChan)
+	})
+	return first
+}
+
+//shutdown after quit began
+//if handoff not nil, connects handed off to child process after drained
+func (s *Server) shutdown(ctx context.Context, handoff *connHandoff) error {
+	var (
+		err error
+	)
+	defer close(s.doneChan)
+
+	//stop accept
+	s.closeListeners()
+	if subErr := face.WaitWithContext(ctx, &s.acceptWg); subErr != nil {
+		err = subErr
+	}
+
+	//send going away message, skip connects handed off
+	buckets := s.getBuckets()
+	if s.conf.GoAwayMsgId > 0 {
+		req := &define.SendMsgReq{
+			MsgId: s.conf.GoAwayMsgId,
+			Data: []byte(define.ServerGoingAway),
 		}
+		if handoff == nil || !handoff.enabled {
+			s.SendMessage(req)
+		}else if req.ConnIds = handoff.goAwayIds(buckets); len(req.ConnIds) > 0 {
+			s.SendMessage(req)
+		}
+	}
 
-		//process new connect
-		//gen new connect id
-		if s.cbOfGenConnId != nil {
-			connId = s.cbOfGenConnId()
-		}else{
-			connId = atomic.AddInt64(&s.connId, 1)
+	//stop read and wait in-flight handlers
+	for _, bucket := range buckets {
+		bucket.StopRead()
+	}
+	if s.poller != nil {
+		s.poller.Quit()
+		if subErr := s.poller.Wait(ctx); subErr != nil {
+			err = subErr
 		}
-		if connId <= 0 {
-			log.Println("can't gen new connect id")
-			continue
+	}
+	for _, bucket := range buckets {
+		if subErr := bucket.WaitRead(ctx); subErr != nil {
+			err = subErr
 		}
+	}
 
-		//init new connect obj
-		connect := face.NewConnect(s, conn, connId, s.handler)
+	//collect connects for handoff before groups cleared
+	if handoff != nil && err == nil {
+		handoff.collect(s, buckets)
+	}
 
-		//call cb for new connected
-		if s.cbOfConnected != nil {
-			s.cbOfConnected(connect)
+	//clear groups, queued group messages sent
+	s.clearGroups()
+
+	//flush outbound queues
+	for _, bucket := range buckets {
+		if subErr := bucket.Flush(ctx); subErr != nil {
+			err = subErr
 		}
+	}
 
-		//push into target bucket
-		bucket := s.getBucket(connId)
-		bucket.AddConnect(connect)
+	//hand off connects, detached from buckets after child ack
+	if handoff != nil {
+		if subErr := handoff.send(ctx, s); subErr != nil {
+			err = subErr
+		}
 	}
-	return nil
+
+	//close connects and stop buckets
+	for _, bucket := range buckets {
+		bucket.Quit()
+	}
+	return err
+}
+
+//get hook for disconnected with locker
+func (s *Server) getDisconnectedHook() func(iface.IConnect) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.cbForDisconnected
+}
+
+//check server is quit or not
+func (s *Server) isQuit() bool {
+	select {
+	case <- s.quitChan:
+		return true
+	default:
+		return false
+	}
+}
+
+//get all buckets
+func (s *Server) getBuckets() []iface.IBucket {
+	s.RLock()
+	defer s.RUnlock()
+	buckets := make([]iface.IBucket, 0, len(s.bucketMap))
+	for _, v := range s.bucketMap {
+		buckets = append(buckets, v)
+	}
+	return buckets
+}
+
+//clear all groups
+func (s *Server) clearGroups() {
+	s.groupLocker.Lock()
+	defer s.groupLocker.Unlock()
+	for _, group := range s.groupMap {
+		group.Clear()
+	}
+	s.groupMap = map[int64]iface.IGroup{}
 }
 
 //get bucket by connect id
@@ -355,32 +921,279 @@ func (s *Server) getBucket(connId int64) iface.IBucket {
 	return nil
 }
 
-//inter init
-func (s *Server) interInit() bool {
-	//get tcp addr
-	address := fmt.Sprintf("%s:%d", s.conf.Host, s.conf.Port)
-	addr, err := net.ResolveTCPAddr(s.conf.TcpVersion, address)
+//get default listener conf from server conf
+func (s *Server) defaultListenerConf() *ListenerConf {
+	conf := &ListenerConf{
+		Name: define.ListenerDefault,
+		Host: s.conf.Host,
+		Port: s.conf.Port,
+		Address: s.conf.Address,
+		TcpVersion: s.conf.TcpVersion,
+		Acceptors: s.conf.Acceptors,
+		TLS: s.conf.TLS,
+		ProxyProtocol: s.conf.ProxyProtocol,
+		TrustedProxies: s.conf.TrustedProxies,
+	}
+	return conf
+}
+
+//check conf, engine and addresses
+func (s *Server) checkConf() error {
+	switch s.conf.Engine {
+	case define.EngineRoutine, define.EngineEpoll:
+	default:
+		return fmt.Errorf("cree.server, unsupported engine %v", s.conf.Engine)
+	}
+	if _, err := face.CreatePacket(s.conf.Packet); err != nil {
+		return fmt.Errorf("cree.server, %v", err)
+	}
+	if _, err := face.CreateCodec(s.conf.Codec); err != nil {
+		return fmt.Errorf("cree.server, %v", err)
+	}
+	for _, name := range s.conf.Compress {
+		if _, err := face.CreateCompressor(name); err != nil {
+			return fmt.Errorf("cree.server, %v", err)
+		}
+	}
+	if s.conf.Checksum && !canChecksum(s.conf.Packet) {
+		return fmt.Errorf("cree.server, packet %v not support checksum", s.conf.Packet)
+	}
+	if s.conf.Secure && !face.HasKind(s.packet) {
+		return fmt.Errorf("cree.server, packet %v not support secure channel", s.conf.Packet)
+	}
+	if len(s.conf.SecureKey) > 0 {
+		if _, err := face.ParseSecureKey(s.conf.SecureKey); err != nil {
+			return fmt.Errorf("cree.server, %v", err)
+		}
+	}
+	_, err := parseAddress(s.conf.Address,
+		s.conf.TcpVersion, s.conf.Host, s.conf.Port)
 	if err != nil {
-		log.Printf("cree.server, resolve tcp addr failed, err:%v", err.Error())
-		panic(any(err))
-		return false
+		return err
 	}
+	if s.conf.WsAddress != "" {
+		_, err = parseAddress(s.conf.WsAddress, s.conf.TcpVersion, "", 0)
+	}
+	return err
+}
 
-	//begin listen
-	listener, subErr := net.ListenTCP(s.conf.TcpVersion, addr)
-	if subErr != nil {
-		log.Printf("cree.server, listen on %v failed, err:%v", address, subErr.Error())
-		panic(any(subErr))
-		return false
+//listen on conf address, extra websocket address and added listeners
+func (s *Server) listenAll() error {
+	var (
+		err error
+	)
+	//listeners added before listen
+	s.listenerLocker.Lock()
+	s.listening = true
+	confs := s.pendingConfs
+	s.pendingConfs = nil
+	s.listenerLocker.Unlock()
+
+	//default listener
+	if s.customListener != nil {
+		err = s.serveListener(s.defaultListenerConf(),
+			[]net.Listener{s.customListener}, &addressInfo{})
+	}else{
+		err = s.AddListener(s.defaultListenerConf())
+	}
+	if err != nil {
+		return err
+	}
+
+	//extra websocket listener
+	if s.conf.WsAddress != "" {
+		err = s.AddListener(&ListenerConf{
+			Name: define.ListenerWebSocket,
+			Address: s.conf.WsAddress,
+			TLS: s.conf.TLS,
+			ProxyProtocol: s.conf.ProxyProtocol,
+			TrustedProxies: s.conf.TrustedProxies,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	//listeners added before listen
+	for _, conf := range confs {
+		if err = s.AddListener(conf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//report fatal error of listener, served by Serve
+func (s *Server) reportFatal(err error) {
+	select {
+	case s.fatalChan <- err:
+	default:
+	}
+}
+
+//inter init
+func (s *Server) interInit() error {
+	//init event loops for epoll engine
+	readTickerRate := s.conf.ReadTickerRate
+	if s.conf.Engine == define.EngineEpoll {
+		poller, err := face.NewPoller(s.conf.EventLoops, s.packet, s.cbForPollRead)
+		if err != nil {
+			log.Printf("cree.server, init event loops failed, err:%v", err.Error())
+			return err
+		}
+		s.poller = poller
+		readTickerRate = 0
 	}
 
 	//init inter buckets
+	s.Lock()
+	defer s.Unlock()
 	for i := 0; i < s.conf.Buckets; i++ {
-		bucket := face.NewBucket(i, s.conf.ErrMsgId)
+		bucket := face.NewBucket(i, s.conf.ErrMsgId, readTickerRate)
+		if s.poller != nil {
+			bucket.SetReadMode(define.ReadModeEvent)
+		}
+		if s.cbOfReadMessage != nil {
+			bucket.SetCBForReadMessage(s.cbOfReadMessage)
+		}
+		bucket.SetPacket(s.packet)
+		bucket.SetCBForGroupMessage(s.cbForGroupMessage)
+		bucket.SetCBForDisconnected(s.cbForConnDisconnected)
 		s.bucketMap[i] = bucket
 	}
+	return nil
+}
 
-	//watch tcp connect
-	go s.watchConn(listener)
-	return true
-}
\ No newline at end of file
+//create packet codec by name, default codec if not registered
+func newPacket(name string, maxPackSize int, checksum bool) iface.IPacket {
+	packet, err := face.CreatePacket(name)
+	if err != nil {
+		packet = face.NewPacket()
+	}
+	packet.SetMaxPackSize(maxPackSize)
+	if defaultPacket, ok := packet.(*face.Packet); ok {
+		defaultPacket.SetChecksum(checksum)
+	}
+	return packet
+}
+
+//create data codec, json codec used if not exists
+func newCodec(name string) iface.ICodec {
+	codec, err := face.CreateCodec(name)
+	if err != nil {
+		codec = face.NewJsonCodec()
+	}
+	return codec
+}
+
+//check codec support checksum header or not
+func canChecksum(name string) bool {
+	packet, _ := face.CreatePacket(name)
+	_, ok := packet.(*face.Packet)
+	return ok
+}
+
+//get count of corrupt frames of default codec
+func getCorruptCount(packet iface.IPacket) int64 {
+	if defaultPacket, ok := packet.(*face.Packet); ok {
+		return defaultPacket.GetCorruptCount()
+	}
+	return 0
+}
+
+//check hello of client, magic not set by legacy client
+//settings should be same as server, hook called at last
+func (s *Server) checkHello(conn iface.IConnect, hello *face.HelloInfo) error {
+	if hello.Magic != 0 {
+		if hello.Magic != define.HelloMagic {
+			return fmt.Errorf("invalid hello magic %x", hello.Magic)
+		}
+		if hello.Version <= 0 || hello.Version > define.ProtocolVersion {
+			return fmt.Errorf("unsupported protocol version %d, server %d",
+				hello.Version, define.ProtocolVersion)
+		}
+		endian := face.PacketEndian(s.packet)
+		if hello.Endian != "" && endian != "" && hello.Endian != endian {
+			return fmt.Errorf("byte order mismatch, client %v, server %v", hello.Endian, endian)
+		}
+		if hello.MaxPackSize > 0 && hello.MaxPackSize != s.packet.GetMaxPackSize() {
+			return fmt.Errorf("max pack size mismatch, client %d, server %d",
+				hello.MaxPackSize, s.packet.GetMaxPackSize())
+		}
+		if (hello.Caps & define.CapChecksum != 0) != s.conf.Checksum {
+			return fmt.Errorf("checksum mismatch, server %v", s.conf.Checksum)
+		}
+		if s.conf.Secure && len(hello.Key) <= 0 {
+			return errors.New("secure channel required")
+		}
+	}
+
+	//call hook with offered capabilities
+	s.RLock()
+	cbOfHello := s.cbOfHello
+	s.RUnlock()
+	if cbOfHello == nil {
+		return nil
+	}
+	offer := &define.Capabilities{
+		Version: hello.Version,
+		App: hello.App,
+		AppVersion: hello.AppVersion,
+		Caps: hello.Caps,
+		MaxPackSize: hello.MaxPackSize,
+	}
+	if len(hello.Compress) > 0 {
+		offer.Compress = hello.Compress[0]
+	}
+	return cbOfHello(conn, offer)
+}
+
+//send reject frame of hello and close connect
+//code of typed error kept, like define.FrameErrRejected
+func (s *Server) rejectHello(conn iface.IConnect, message iface.IMessage, err error) error {
+	frameErr := face.ToFrameError(err, define.FrameErrRejected)
+	kind := face.MakeKind(define.KindError, face.KindSeq(message.GetKind()))
+	if subErr := conn.SendFrame(kind, message.GetId(), frameErr.Encode()); subErr == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), define.HelloRejectTimeOut * time.Millisecond)
+		conn.Flush(ctx)
+		cancel()
+	}
+	return fmt.Errorf("%w, %v", face.ErrHelloRejected, frameErr.Message)
+}
+
+//select first compress codec of client allowed by server
+func (s *Server) selectCompress(names []string) string {
+	if !s.compressible {
+		return ""
+	}
+	for _, name := range names {
+		for _, allowed := range s.conf.Compress {
+			if name == allowed {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+//init codec of connect with compress and secure channel, wrap current codec
+//data compressed before sealed
+func (s *Server) newConnPacket(
+	packet iface.IPacket,
+	compress string,
+	secure *face.SecureState) (iface.IPacket, error) {
+	var (
+		err error
+	)
+	if secure != nil {
+		if packet, err = face.NewSecurePacket(packet, secure); err != nil {
+			return nil, err
+		}
+	}
+	if compress != "" {
+		if packet, err = face.NewCompressPacket(packet, compress, s.conf.CompressThreshold); err != nil {
+			return nil, err
+		}
+	}
+	return packet, nil
+}
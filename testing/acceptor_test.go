@@ -0,0 +1,80 @@
+package testing
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/iface"
+)
+
+var (
+	acceptServerMap    = map[int]*cree.Server{}
+	acceptServerLocker = sync.Mutex{}
+)
+
+//get server which greets new connect, kept for rounds of benchmark
+func getAcceptServer(b *testing.B, acceptors int) *cree.Server {
+	acceptServerLocker.Lock()
+	defer acceptServerLocker.Unlock()
+	if v, ok := acceptServerMap[acceptors]; ok {
+		return v
+	}
+	server := newTestServer(&cree.ServerConf{
+		Host: host,
+		Acceptors: acceptors,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		conn.SendData([]byte{1})
+	})
+	acceptServerMap[acceptors] = server
+	return server
+}
+
+//connect and wait greet of server
+func dialAndWaitGreet(address string) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = io.ReadFull(conn, make([]byte, 1))
+	return err
+}
+
+//test multi acceptors on same port
+func TestReusePortAcceptors(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Acceptors: 4,
+	})
+	for i := 0; i < 8; i++ {
+		echoOnce(t, &cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+		})
+	}
+}
+
+//benchmark connect establishment
+func benchmarkAccept(b *testing.B, acceptors int) {
+	address := serverAddr(getAcceptServer(b, acceptors))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := dialAndWaitGreet(address); err != nil {
+				b.Fatalf("dial failed, err:%v", err)
+			}
+		}
+	})
+}
+
+func BenchmarkAcceptSingle(b *testing.B) {
+	benchmarkAccept(b, 1)
+}
+
+func BenchmarkAcceptReusePort(b *testing.B) {
+	benchmarkAccept(b, 4)
+}
@@ -0,0 +1,105 @@
+package testing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/iface"
+)
+
+//test silent connect not delay messages of same bucket
+func TestSilentConnNotBlockBucket(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Buckets: 1,
+	})
+
+	//init silent client
+	silent := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	if subErr := silent.ConnServer(); subErr != nil {
+		t.Fatalf("connect silent client failed, err:%v", subErr)
+	}
+	defer silent.Close()
+
+	//init active client
+	readChan := make(chan iface.IMessage, 8)
+	active := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	active.SetCBForRead(func(msg iface.IMessage) error {
+		readChan <- msg
+		return nil
+	})
+	if subErr := active.ConnServer(); subErr != nil {
+		t.Fatalf("connect active client failed, err:%v", subErr)
+	}
+	defer active.Close()
+
+	//send and wait echo
+	for i := 0; i < 3; i++ {
+		if subErr := active.SendPacket(1, []byte("hello")); subErr != nil {
+			t.Fatalf("send packet failed, err:%v", subErr)
+		}
+		select {
+		case msg := <-readChan:
+			if string(msg.GetData()) != "hello" {
+				t.Fatalf("unexpected echo data:%v", string(msg.GetData()))
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("echo delayed by silent connect")
+		}
+	}
+}
+
+//test member of group read by group ticker in legacy read mode
+func TestGroupLegacyRead(t *testing.T) {
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		ReadTickerRate: 0.01,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	readChan := make(chan string, 1)
+	group, subErr := server.CreateGroup(1,
+		func(groupId int64, conn iface.IConnect, req iface.IRequest) error {
+			readChan <- string(req.GetMessage().GetData())
+			return nil
+		})
+	if subErr != nil {
+		t.Fatalf("create group failed, err:%v", subErr)
+	}
+
+	//client joined group
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	if subErr = client.ConnServer(); subErr != nil {
+		t.Fatalf("connect client failed, err:%v", subErr)
+	}
+	defer client.Close()
+	select {
+	case conn := <-connChan:
+		group.Join(conn)
+	case <-time.After(time.Second):
+		t.Fatalf("wait connect timeout")
+	}
+
+	//message read by group
+	client.SendPacket(1, []byte("legacy"))
+	select {
+	case data := <-readChan:
+		if data != "legacy" {
+			t.Fatalf("unexpected group read data:%v", data)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("member of group not read in legacy mode")
+	}
+}
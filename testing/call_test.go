@@ -0,0 +1,237 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//reply data after delay
+type replyRouter struct {
+	face.BaseRouter
+	delay time.Duration
+}
+
+func (r *replyRouter) Handle(req iface.IRequest) {
+	time.Sleep(r.delay)
+	req.Reply(req.GetMessage().GetData())
+}
+
+//reply typed error
+type denyRouter struct {
+	face.BaseRouter
+}
+
+func (r *denyRouter) Handle(req iface.IRequest) {
+	req.ReplyError(face.NewFrameError(100, "denied"))
+}
+
+//test client call with response, error and time out
+func TestClientCall(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	server.AddRouter(2, &replyRouter{})
+	server.AddRouter(3, &replyRouter{delay: time.Millisecond * 300})
+	server.AddRouter(4, &denyRouter{})
+
+	pushChan := make(chan iface.IMessage, 1)
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	client.SetCBForRead(func(msg iface.IMessage) error {
+		pushChan <- msg
+		return nil
+	})
+	if _, subErr := client.Call(context.Background(), 2, []byte("x")); subErr != cree.ErrCallDisconnected {
+		t.Fatalf("call before connect should be disconnected, err:%v", subErr)
+	}
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+
+	//concurrent calls matched by seq
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := fmt.Sprintf("call-%d", i)
+			message, subErr := client.Call(context.Background(), 2, []byte(data))
+			if subErr != nil || string(message.GetData()) != data {
+				t.Errorf("unexpected call result:%v, err:%v", message, subErr)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	//remote errors
+	frameErr := &face.FrameError{}
+	_, subErr := client.Call(context.Background(), 9, []byte("x"))
+	if !errors.As(subErr, &frameErr) || frameErr.Code != define.FrameErrNoHandler {
+		t.Fatalf("unexpected no handler err:%v", subErr)
+	}
+	_, subErr = client.Call(context.Background(), 4, []byte("x"))
+	if !errors.As(subErr, &frameErr) || frameErr.Code != 100 || frameErr.Error() != "denied" {
+		t.Fatalf("unexpected reply err:%v", subErr)
+	}
+
+	//time out and late response dropped
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 50)
+	defer cancel()
+	if _, subErr = client.Call(ctx, 3, []byte("x")); subErr != cree.ErrCallTimeOut {
+		t.Fatalf("unexpected time out err:%v", subErr)
+	}
+
+	//reply of push message is push
+	client.SendPacket(2, []byte("push"))
+	select {
+	case msg := <-pushChan:
+		if string(msg.GetData()) != "push" {
+			t.Fatalf("unexpected push data:%v", string(msg.GetData()))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait push reply timeout")
+	}
+
+	//disconnect while waiting
+	time.AfterFunc(time.Millisecond * 50, client.Close)
+	if _, subErr = client.Call(context.Background(), 3, []byte("x")); subErr != cree.ErrCallDisconnected {
+		t.Fatalf("unexpected disconnect err:%v", subErr)
+	}
+}
+
+//test server request client and gather replies of group
+func TestServerRequest(t *testing.T) {
+	connChan := make(chan iface.IConnect, 3)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	group, subErr := server.CreateGroup(2,
+		func(int64, iface.IConnect, iface.IRequest) error {
+			return nil
+		})
+	if subErr != nil {
+		t.Fatalf("create group failed, err:%v", subErr)
+	}
+
+	//clients reply, deny and slow
+	handlers := []func(msg iface.IMessage) ([]byte, error){
+		func(msg iface.IMessage) ([]byte, error) {
+			return append([]byte("ok:"), msg.GetData()...), nil
+		},
+		func(msg iface.IMessage) ([]byte, error) {
+			return nil, face.NewFrameError(101, "no")
+		},
+		func(msg iface.IMessage) ([]byte, error) {
+			time.Sleep(time.Millisecond * 500)
+			return msg.GetData(), nil
+		},
+	}
+	conns := make([]iface.IConnect, 0)
+	clients := make([]*cree.Client, 0)
+	for _, handler := range handlers {
+		client := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+		})
+		client.AddRequestHandler(5, handler)
+		if subErr = client.Connect(context.Background()); subErr != nil {
+			t.Fatalf("connect failed, err:%v", subErr)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+		select {
+		case conn := <-connChan:
+			conns = append(conns, conn)
+			group.Join(conn)
+		case <-time.After(time.Second):
+			t.Fatalf("wait connect timeout")
+		}
+	}
+
+	//request one client
+	message, subErr := conns[0].Request(context.Background(), 5, []byte("buy"))
+	if subErr != nil || string(message.GetData()) != "ok:buy" {
+		t.Fatalf("unexpected request result:%v, err:%v", message, subErr)
+	}
+	frameErr := &face.FrameError{}
+	if _, subErr = conns[1].Request(context.Background(), 5, []byte("buy")); !errors.As(subErr, &frameErr) || frameErr.Code != 101 {
+		t.Fatalf("unexpected deny err:%v", subErr)
+	}
+	if _, subErr = conns[0].Request(context.Background(), 6, []byte("buy")); !errors.As(subErr, &frameErr) ||
+		frameErr.Code != define.FrameErrNoHandler {
+		t.Fatalf("unexpected no handler err:%v", subErr)
+	}
+
+	//gather replies of group until deadline
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 200)
+	defer cancel()
+	replies, errs := group.Request(ctx, 5, []byte("all"))
+	if len(replies) != 1 || string(replies[conns[0].GetConnId()].GetData()) != "ok:all" {
+		t.Fatalf("unexpected group replies:%v", replies)
+	}
+	if !errors.As(errs[conns[1].GetConnId()], &frameErr) || errs[conns[2].GetConnId()] != face.ErrRequestTimeOut {
+		t.Fatalf("unexpected group errs:%v", errs)
+	}
+
+	//disconnect while waiting
+	time.AfterFunc(time.Millisecond * 50, clients[2].Close)
+	if _, subErr = conns[2].Request(context.Background(), 5, []byte("x")); subErr != face.ErrConnClosed {
+		t.Fatalf("unexpected disconnect err:%v", subErr)
+	}
+}
+
+//test call and request refused at once by codec without kind
+func TestCallUnsupported(t *testing.T) {
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Packet: define.PacketVarint,
+	})
+	server.AddRouter(2, &replyRouter{})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Packet: define.PacketVarint,
+	})
+	if subErr := client.ConnServer(); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+
+	//refused without waiting ctx
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5)
+	defer cancel()
+	begin := time.Now()
+	if _, subErr := client.Call(ctx, 2, []byte("call")); subErr != cree.ErrCallUnsupported {
+		t.Fatalf("unexpected call err:%v", subErr)
+	}
+	select {
+	case conn := <-connChan:
+		if _, subErr := conn.Request(ctx, 5, []byte("request")); subErr != face.ErrCallUnsupported {
+			t.Fatalf("unexpected request err:%v", subErr)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("wait connect timeout")
+	}
+	if time.Since(begin) > time.Second {
+		t.Fatalf("unsupported call should be refused at once")
+	}
+}
@@ -12,19 +12,34 @@ import (
 
 var (
 	host = "127.0.0.1"
-	port = 7800
+	port int
 	clientMap = map[int]*cree.Client{}
 	locker = sync.RWMutex{}
 	cc *cree.Client
 	err error
+	portOnce sync.Once
+	clientOnce sync.Once
 )
 
-//init
-func init() {
-	cc, err = connClient()
-	if err != nil {
-		panic(any(err))
-	}
+//get port of shared local server, started on first use
+func sharedPort() int {
+	portOnce.Do(func() {
+		port = serverPort(newTestServer(&cree.ServerConf{
+			Host: host,
+		}))
+	})
+	return port
+}
+
+//get client of shared local server, connected on first use
+func sharedClient() *cree.Client {
+	clientOnce.Do(func() {
+		cc, err = connClient()
+		if err != nil {
+			panic(any(err))
+		}
+	})
+	return cc
 }
 
 //cb for client read
@@ -38,7 +53,7 @@ func connClient() (*cree.Client, error) {
 	//set client conf
 	clientCfg := &cree.ClientConf{
 		Host: host,
-		Port: port,
+		Port: sharedPort(),
 	}
 	//init new client
 	client := cree.NewClient(clientCfg)
@@ -62,7 +77,7 @@ func TestConnect(t *testing.T) {
 func TestWrite(t *testing.T) {
 	messageId := uint32(1)
 	data := fmt.Sprintf("time:%d", time.Now().Unix())
-	subErr := cc.SendPacket(messageId, []byte(data))
+	subErr := sharedClient().SendPacket(messageId, []byte(data))
 	t.Logf("test write, subErr:%v\n", subErr)
 }
 
@@ -73,7 +88,7 @@ func BenchmarkWrite(b *testing.B) {
 	failed := 0
 	for i := 0; i < b.N; i++ {
 		data := fmt.Sprintf("time:%d", time.Now().Unix())
-		subErr := cc.SendPacket(messageId, []byte(data))
+		subErr := sharedClient().SendPacket(messageId, []byte(data))
 		if subErr != nil {
 			failed++
 		}else{
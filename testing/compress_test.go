@@ -0,0 +1,128 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//test compressors with max pack size
+func TestCompressPacket(t *testing.T) {
+	for _, name := range []string{define.CompressFlate, define.CompressGzip} {
+		packet, subErr := face.NewCompressPacket(face.NewPacket(), name, 0)
+		if subErr != nil {
+			t.Fatalf("new %v packet failed, err:%v", name, subErr)
+		}
+
+		//large data compressed, small data kept raw
+		for _, size := range []int{define.DefaultCompressThreshold * 4, 10} {
+			message := face.NewMessage()
+			message.SetKind(face.MakeKind(define.KindRequest, 3))
+			message.SetData(bytes.Repeat([]byte("cree"), size / 4))
+			byteData, _ := packet.Pack(message)
+			raw, _, _ := face.NewPacket().Decode(byteData)
+			compressed := raw.GetKind() & define.KindFlagCompress != 0
+			if compressed != (size > define.DefaultCompressThreshold) {
+				t.Fatalf("%v unexpected compress flag of size %v", name, size)
+			}
+			out, _, subErr := packet.Decode(byteData)
+			if subErr != nil || !bytes.Equal(out.GetData(), message.GetData()) ||
+				out.GetKind() != message.GetKind() {
+				t.Fatalf("%v decode failed, err:%v", name, subErr)
+			}
+		}
+	}
+	if _, subErr := face.NewCompressPacket(face.NewPacket(), "unknown", 0); subErr == nil {
+		t.Fatalf("new packet with unknown compressor should be failed")
+	}
+	if face.HasKind(face.NewVarintPacket()) || !face.HasKind(face.NewPacket()) {
+		t.Fatalf("unexpected kind check of codec")
+	}
+}
+
+//test compress negotiated by client and raw connect
+func TestCompressNegotiate(t *testing.T) {
+	if _, subErr := cree.New(&cree.ServerConf{Compress: []string{"unknown"}}); subErr == nil {
+		t.Fatalf("new server with unknown compressor should be failed")
+	}
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Compress: []string{define.CompressGzip, define.CompressFlate},
+	})
+	server.AddRouter(2, &replyRouter{})
+
+	//client call with compress
+	data := bytes.Repeat([]byte(`{"name":"cree","level":3},`), 60)
+	for _, compress := range [][]string{{"snappy", define.CompressFlate}, nil} {
+		client := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+			Compress: compress,
+		})
+		if subErr := client.Connect(context.Background()); subErr != nil {
+			t.Fatalf("connect failed, err:%v", subErr)
+		}
+		message, subErr := client.Call(context.Background(), 2, data)
+		if subErr != nil || !bytes.Equal(message.GetData(), data) {
+			t.Fatalf("call with compress %v failed, err:%v", compress, subErr)
+		}
+		client.Close()
+	}
+
+	//hello of raw connect
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	reader := face.NewPacketReader(face.NewPacket(), 0)
+	hello, _ := json.Marshal(&face.HelloInfo{Compress: []string{define.CompressFlate}})
+	writeFrame(t, conn, face.MakeKind(define.KindHello, 1), 0, hello)
+	message, subErr := reader.ReadMessage(conn)
+	if subErr != nil || face.KindType(message.GetKind()) != define.KindHello ||
+		string(message.GetData()) != `{"compress":["flate"]}` {
+		t.Fatalf("unexpected hello reply:%v, err:%v", message, subErr)
+	}
+
+	//reply compressed on wire
+	packet, _ := face.NewCompressPacket(face.NewPacket(), define.CompressFlate, 0)
+	byteData, _ := packet.Pack(newMessage(face.MakeKind(define.KindRequest, 2), 2, data))
+	conn.Write(byteData)
+	message, subErr = reader.ReadMessage(conn)
+	if subErr != nil || message.GetKind() & define.KindFlagCompress == 0 ||
+		len(message.GetData()) >= len(data) {
+		t.Fatalf("reply should be compressed, err:%v", subErr)
+	}
+
+	//decompressed size over max pack size refused
+	bomb := bytes.Repeat([]byte{0}, define.PacketMaxSize * 8)
+	byteData, _ = packet.Pack(newMessage(face.MakeKind(define.KindRequest, 3), 2, bomb))
+	if len(byteData) > define.PacketMaxSize {
+		t.Fatalf("bomb should be small on wire, size:%v", len(byteData))
+	}
+	conn.Write(byteData)
+	reader.SetPacket(packet)
+	message, subErr = reader.ReadMessage(conn)
+	if subErr != nil || face.KindType(message.GetKind()) != define.KindError ||
+		face.DecodeFrameError(message).Code != define.FrameErrBadFrame {
+		t.Fatalf("bomb should be refused with error frame, message:%v, err:%v", message, subErr)
+	}
+}
+
+//init message of kind, id and data
+func newMessage(kind, messageId uint32, data []byte) iface.IMessage {
+	message := face.NewMessage()
+	message.SetKind(kind)
+	message.SetId(messageId)
+	message.SetData(data)
+	return message
+}
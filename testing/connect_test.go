@@ -0,0 +1,184 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//test slow consumer not block sender
+func TestWriteQueueOverflow(t *testing.T) {
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		WriteQueueSize: 4,
+		WriteOverflow: define.OverflowDropNewest,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+
+	//raw client never read
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+
+	var connect iface.IConnect
+	select {
+	case connect = <-connChan:
+	case <-time.After(time.Second):
+		t.Fatalf("wait connect timeout")
+	}
+
+	//send until socket buffer and queue full
+	data := make([]byte, 1024 * 1024)
+	begin := time.Now()
+	for i := 0; i < 64; i++ {
+		connect.SendData(data)
+	}
+	if time.Since(begin) > time.Second {
+		t.Fatalf("send blocked by slow consumer, cost:%v", time.Since(begin))
+	}
+	if connect.GetDropCount() <= 0 {
+		t.Fatalf("no data dropped, queue len:%v", connect.GetSendQueueLen())
+	}
+	if connect.GetSendQueueLen() > 4 {
+		t.Fatalf("queue over limit, queue len:%v", connect.GetSendQueueLen())
+	}
+}
+
+//test sends and streams refused after send closed
+func TestCloseSend(t *testing.T) {
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Hello: true,
+	})
+	client.SetCBForStream(func(stream iface.IStream) {
+		io.Copy(io.Discard, stream)
+	})
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+	connect := (<-connChan).(*face.Connect)
+	stream, subErr := connect.OpenStream()
+	if subErr != nil {
+		t.Fatalf("open stream failed, err:%v", subErr)
+	}
+
+	//closed with handed off error
+	connect.CloseSend(face.ErrConnHandedOff)
+	if subErr = connect.SendMessage(1, []byte("x")); !errors.Is(subErr, face.ErrConnHandedOff) {
+		t.Fatalf("unexpected send message err:%v", subErr)
+	}
+	if subErr = connect.SendData([]byte("x")); !errors.Is(subErr, face.ErrConnHandedOff) {
+		t.Fatalf("unexpected send data err:%v", subErr)
+	}
+	if _, subErr = stream.Read(make([]byte, 1)); !errors.Is(subErr, face.ErrConnHandedOff) {
+		t.Fatalf("unexpected stream err:%v", subErr)
+	}
+	if _, subErr = connect.OpenStream(); subErr == nil {
+		t.Fatalf("open stream after send closed should be failed")
+	}
+}
+
+//connect for send benchmark
+type benchConnect struct {
+	connect  iface.IConnect
+	received int64
+}
+
+//start connect for send benchmark, peer read all data
+//server and connect stopped after round of benchmark
+func startBenchConnect(b *testing.B, flushBytes int) *benchConnect {
+	//start server
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(b, &cree.ServerConf{
+		Host: host,
+		WriteFlushBytes: flushBytes,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+
+	//raw client read all data until closed
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		b.Fatalf("dial failed, err:%v", subErr)
+	}
+	b.Cleanup(func() {
+		conn.Close()
+	})
+	bc := &benchConnect{}
+	go func() {
+		buff := make([]byte, 65536)
+		for {
+			n, err := conn.Read(buff)
+			atomic.AddInt64(&bc.received, int64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	bc.connect = <-connChan
+	return bc
+}
+
+//send small frames and wait peer received all
+func benchmarkSendData(b *testing.B, send func(bc *benchConnect, frame []byte) error, flushBytes int) {
+	bc := startBenchConnect(b, flushBytes)
+	frame := make([]byte, 64)
+	b.SetBytes(int64(len(frame)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if subErr := send(bc, frame); subErr != nil {
+			b.Fatalf("send data failed, err:%v", subErr)
+		}
+	}
+	expect := int64(b.N * len(frame))
+	for atomic.LoadInt64(&bc.received) < expect {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//send by write queue of connect
+func queueSend(bc *benchConnect, frame []byte) error {
+	return bc.connect.SendData(frame)
+}
+
+//baseline, write frame on socket directly
+func BenchmarkSendDataDirectWrite(b *testing.B) {
+	benchmarkSendData(b, func(bc *benchConnect, frame []byte) error {
+		_, err := bc.connect.GetConn().Write(frame)
+		return err
+	}, -1)
+}
+
+//one frame per write of queue
+func BenchmarkSendDataSingleWrite(b *testing.B) {
+	benchmarkSendData(b, queueSend, -1)
+}
+
+//coalesced frames per writev
+func BenchmarkSendDataCoalesced(b *testing.B) {
+	benchmarkSendData(b, queueSend, 0)
+}
@@ -0,0 +1,215 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//reply size of data read from stream
+type streamRouter struct {
+	face.BaseRouter
+}
+
+func (r *streamRouter) HandleStream(req iface.IRequest, reader io.Reader) {
+	size, _ := io.Copy(io.Discard, reader)
+	req.Reply([]byte(strconv.FormatInt(size, 10)))
+}
+
+//push large data, send result returned
+type largeRouter struct {
+	face.BaseRouter
+	errChan chan error
+}
+
+func (r *largeRouter) Handle(req iface.IRequest) {
+	r.errChan <- req.GetConnect().SendMessage(req.GetMessage().GetId(), make([]byte, 20 << 10))
+}
+
+//decode all frames of data
+func decodeFrames(t *testing.T, packet iface.IPacket, data []byte) []iface.IMessage {
+	messages := make([]iface.IMessage, 0)
+	for len(data) > 0 {
+		message, size, subErr := packet.Decode(data)
+		if subErr != nil || message == nil {
+			t.Fatalf("decode frame failed, err:%v", subErr)
+		}
+		messages = append(messages, message)
+		data = data[size:]
+	}
+	return messages
+}
+
+//test fragments split by codec and reassembled with budget
+func TestFragmentAssemble(t *testing.T) {
+	packet := face.NewPacket()
+	packet.SetMaxPackSize(256)
+	data := make([]byte, 1000)
+	rand.Read(data)
+	kind := face.MakeKind(define.KindRequest, 7)
+	byteData, _ := face.AppendFrames(packet, nil, kind, 2, data, define.CapFragment)
+	frames := decodeFrames(t, packet, byteData)
+	if len(frames) != 6 {
+		t.Fatalf("unexpected fragments:%v", len(frames))
+	}
+
+	//reassembled after last fragment
+	assembler := face.NewAssembler(1024)
+	for i, frame := range frames {
+		whole, subErr := assembler.Add(frame, nil)
+		if subErr != nil || (whole != nil) != (i == len(frames) - 1) {
+			t.Fatalf("unexpected add result of fragment %v, err:%v", i, subErr)
+		}
+		if whole != nil && (whole.GetKind() != kind || whole.GetId() != 2 || !bytes.Equal(whole.GetData(), data)) {
+			t.Fatalf("unexpected reassembled message")
+		}
+	}
+
+	//small data not split
+	byteData, _ = face.AppendFrames(packet, nil, kind, 2, data[:100], 0)
+	if whole, _ := assembler.Add(decodeFrames(t, packet, byteData)[0], nil); whole == nil ||
+		!bytes.Equal(whole.GetData(), data[:100]) {
+		t.Fatalf("small data should not be split")
+	}
+
+	//lost fragment refused
+	assembler.Add(frames[0], nil)
+	if _, subErr := assembler.Add(frames[2], nil); subErr != face.ErrFragmentLost {
+		t.Fatalf("lost fragment should be refused, err:%v", subErr)
+	}
+
+	//over budget dropped with kind of message
+	assembler = face.NewAssembler(512)
+	var (
+		whole iface.IMessage
+		subErr error
+	)
+	for _, frame := range frames {
+		whole, subErr = assembler.Add(frame, nil)
+	}
+	frameErr := &face.FrameError{}
+	if !errors.As(subErr, &frameErr) || frameErr.Code != define.FrameErrTooLarge ||
+		whole == nil || whole.GetKind() != kind {
+		t.Fatalf("message over budget should be refused, err:%v", subErr)
+	}
+
+	//peer without fragment refused
+	if _, subErr = face.AppendFrames(packet, nil, kind, 2, data, 0); subErr != face.ErrFragmentUnsupported {
+		t.Fatalf("fragments should be refused without capability, err:%v", subErr)
+	}
+
+	//codec without kind not split
+	varint := face.NewVarintPacket()
+	varint.SetMaxPackSize(256)
+	if face.FragmentSize(varint) != 0 {
+		t.Fatalf("codec without kind should not be split")
+	}
+}
+
+//test large call, broadcast and stream router over max pack size
+func TestFragmentServe(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		FragmentBudget: 64 << 10,
+		Secure: true,
+		Compress: []string{define.CompressFlate},
+	})
+	server.AddRouter(2, &replyRouter{})
+	server.AddRouter(3, &streamRouter{})
+
+	pushChan := make(chan iface.IMessage, 1)
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Secure: true,
+		Compress: []string{define.CompressFlate},
+	})
+	client.SetCBForRead(func(msg iface.IMessage) error {
+		pushChan <- msg
+		return nil
+	})
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+
+	//call and reply both fragmented
+	data := make([]byte, 20 << 10)
+	rand.Read(data)
+	message, subErr := client.Call(context.Background(), 2, data)
+	if subErr != nil || !bytes.Equal(message.GetData(), data) {
+		t.Fatalf("large call failed, err:%v", subErr)
+	}
+
+	//over budget refused, stream router not limited
+	frameErr := &face.FrameError{}
+	large := make([]byte, 100 << 10)
+	if _, subErr = client.Call(context.Background(), 2, large); !errors.As(subErr, &frameErr) ||
+		frameErr.Code != define.FrameErrTooLarge {
+		t.Fatalf("unexpected over budget err:%v", subErr)
+	}
+	message, subErr = client.Call(context.Background(), 3, large)
+	if subErr != nil || string(message.GetData()) != strconv.Itoa(len(large)) {
+		t.Fatalf("stream call failed, message:%v, err:%v", message, subErr)
+	}
+
+	//large broadcast
+	server.SendMessage(&define.SendMsgReq{MsgId: 4, Data: data})
+	select {
+	case msg := <-pushChan:
+		if !bytes.Equal(msg.GetData(), data) {
+			t.Fatalf("unexpected broadcast data size:%v", len(msg.GetData()))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait broadcast timeout")
+	}
+}
+
+//test fragment and stream refused if peer not said hello
+func TestFragmentUnsupported(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	router := &largeRouter{
+		errChan: make(chan error, 1),
+	}
+	server.AddRouter(2, router)
+
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	if subErr := client.ConnServer(); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+
+	//large data of client refused
+	if subErr := client.SendPacket(2, make([]byte, 20 << 10)); subErr != face.ErrFragmentUnsupported {
+		t.Fatalf("large data should be refused, err:%v", subErr)
+	}
+	if _, subErr := client.OpenStream(); subErr != face.ErrStreamUnsupported {
+		t.Fatalf("stream should be refused, err:%v", subErr)
+	}
+
+	//large data of server refused
+	client.SendPacket(2, []byte("large"))
+	select {
+	case subErr := <-router.errChan:
+		if subErr != face.ErrFragmentUnsupported {
+			t.Fatalf("large data of server should be refused, err:%v", subErr)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait router timeout")
+	}
+}
@@ -0,0 +1,153 @@
+package testing
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//connect stub for handler
+type frameConnect struct {
+	iface.IConnect
+	connId int64
+	sent   []iface.IMessage
+}
+
+func (c *frameConnect) GetConnId() int64 {
+	return c.connId
+}
+
+func (c *frameConnect) SendFrame(kind uint32, messageId uint32, data []byte) error {
+	message := face.NewMessage()
+	message.SetKind(kind)
+	message.SetId(messageId)
+	message.SetData(data)
+	c.sent = append(c.sent, message)
+	return nil
+}
+
+//write one frame into connect
+func writeFrame(t *testing.T, conn net.Conn, kind, messageId uint32, data []byte) {
+	message := face.NewMessage()
+	message.SetKind(kind)
+	message.SetId(messageId)
+	message.SetData(data)
+	byteData, _ := face.NewPacket().Pack(message)
+	if _, subErr := conn.Write(byteData); subErr != nil {
+		t.Fatalf("write frame failed, err:%v", subErr)
+	}
+}
+
+//test kind made by frame type and seq
+func TestFrameKind(t *testing.T) {
+	kind := face.MakeKind(define.KindResponse, 0x123456)
+	if face.KindType(kind) != define.KindResponse || face.KindSeq(kind) != 0x123456 {
+		t.Fatalf("unexpected kind:%x", kind)
+	}
+	if face.KindSeq(face.MakeKind(define.KindRequest, define.KindSeqMax + 2)) != 1 {
+		t.Fatalf("seq should be wrapped")
+	}
+	if !face.IsDataFrame(0) || face.IsDataFrame(face.MakeKind(define.KindPong, 1)) {
+		t.Fatalf("unexpected data frame check")
+	}
+}
+
+//test handler route response to waiter
+func TestFrameWaiter(t *testing.T) {
+	handler := face.NewHandler()
+	conn := &frameConnect{connId: 1}
+	seq := handler.NextSeq()
+	waiter, subErr := handler.AddWaiter(conn.connId, seq)
+	if subErr != nil {
+		t.Fatalf("add waiter failed, err:%v", subErr)
+	}
+	if _, subErr = handler.AddWaiter(conn.connId, seq); subErr == nil {
+		t.Fatalf("add same waiter should be failed")
+	}
+
+	//response of other seq dropped
+	response := face.NewMessage()
+	response.SetKind(face.MakeKind(define.KindResponse, seq + 1))
+	handler.DoMessageHandle(face.NewRequest(conn, response))
+	select {
+	case <-waiter:
+		t.Fatalf("response of other seq should not be routed")
+	default:
+	}
+
+	//response of same seq routed
+	response.SetKind(face.MakeKind(define.KindResponse, seq))
+	response.SetData([]byte("done"))
+	if subErr = handler.DoMessageHandle(face.NewRequest(conn, response)); subErr != nil {
+		t.Fatalf("handle response failed, err:%v", subErr)
+	}
+	if message := <-waiter; string(message.GetData()) != "done" {
+		t.Fatalf("unexpected response:%v", string(message.GetData()))
+	}
+
+	//waiter closed with connect
+	seq = handler.NextSeq()
+	waiter, _ = handler.AddWaiter(conn.connId, seq)
+	handler.CloseWaiters(conn.connId)
+	if _, ok := <-waiter; ok {
+		t.Fatalf("waiter should be closed")
+	}
+	if len(conn.sent) > 0 {
+		t.Fatalf("response should not be answered")
+	}
+}
+
+//test ping, error and close frame of server
+func TestFrameServe(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	reader := face.NewPacketReader(face.NewPacket(), 0)
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+
+	//ping answered with pong of same seq
+	writeFrame(t, conn, face.MakeKind(define.KindPing, 5), 1, []byte("ping"))
+	message, subErr := reader.ReadMessage(conn)
+	if subErr != nil {
+		t.Fatalf("read pong failed, err:%v", subErr)
+	}
+	if message.GetKind() != face.MakeKind(define.KindPong, 5) || string(message.GetData()) != "ping" {
+		t.Fatalf("unexpected pong, kind:%x, data:%v", message.GetKind(), string(message.GetData()))
+	}
+
+	//request of no handler answered with typed error
+	writeFrame(t, conn, face.MakeKind(define.KindRequest, 6), 9, []byte("who"))
+	message, subErr = reader.ReadMessage(conn)
+	if subErr != nil {
+		t.Fatalf("read error frame failed, err:%v", subErr)
+	}
+	frameErr := face.DecodeFrameError(message)
+	if message.GetKind() != face.MakeKind(define.KindError, 6) ||
+		frameErr.Code != define.FrameErrNoHandler || frameErr.MessageId != 9 {
+		t.Fatalf("unexpected error frame, kind:%x, err:%+v", message.GetKind(), frameErr)
+	}
+
+	//request routed and answered
+	writeFrame(t, conn, face.MakeKind(define.KindRequest, 7), 1, []byte("echo"))
+	message, subErr = reader.ReadMessage(conn)
+	if subErr != nil || string(message.GetData()) != "echo" {
+		t.Fatalf("read echo failed, err:%v", subErr)
+	}
+
+	//close frame close connect
+	writeFrame(t, conn, face.MakeKind(define.KindClose, 0), 0, []byte{})
+	if _, subErr = reader.ReadMessage(conn); subErr != io.EOF {
+		t.Fatalf("connect should be closed, err:%v", subErr)
+	}
+}
@@ -0,0 +1,160 @@
+package testing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//reply capabilities of connect
+type capsRouter struct {
+	face.BaseRouter
+	connChan chan *define.Capabilities
+}
+
+func (r *capsRouter) Handle(req iface.IRequest) {
+	r.connChan <- req.GetConnect().GetCapabilities()
+	req.Reply([]byte("ok"))
+}
+
+//test capabilities negotiated by hello, and client refused by hook
+func TestHelloServe(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		HelloRequired: true,
+		Checksum: true,
+		Compress: []string{define.CompressFlate},
+	})
+	server.AddRouter(2, &replyRouter{})
+	connChan := make(chan *define.Capabilities, 1)
+	server.AddRouter(3, &capsRouter{connChan: connChan})
+	server.SetHello(func(conn iface.IConnect, offer *define.Capabilities) error {
+		if offer.App == "legacy" {
+			return errors.New("app legacy not supported")
+		}
+		return nil
+	})
+
+	//negotiated on both sides
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Checksum: true,
+		Hello: true,
+		App: "game",
+		AppVersion: "1.2.0",
+		Compress: []string{define.CompressGzip, define.CompressFlate},
+	})
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+	caps := client.GetCapabilities()
+	want := uint32(define.CapCompress | define.CapChecksum | define.CapFragment | define.CapStream)
+	if caps == nil || caps.Version != define.ProtocolVersion || caps.Caps != want ||
+		caps.Compress != define.CompressFlate {
+		t.Fatalf("unexpected client capabilities:%+v", caps)
+	}
+	if _, subErr := client.Call(context.Background(), 3, []byte("x")); subErr != nil {
+		t.Fatalf("call failed, err:%v", subErr)
+	}
+	if caps = <-connChan; caps == nil || caps.App != "game" || caps.AppVersion != "1.2.0" || caps.Caps != want {
+		t.Fatalf("unexpected connect capabilities:%+v", caps)
+	}
+
+	//refused by hook with reason
+	frameErr := &face.FrameError{}
+	legacy := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Checksum: true,
+		Hello: true,
+		App: "legacy",
+	})
+	if subErr := legacy.Connect(context.Background()); !errors.As(subErr, &frameErr) ||
+		frameErr.Code != define.FrameErrRejected || frameErr.Message != "app legacy not supported" {
+		t.Fatalf("unexpected hook reject err:%v", subErr)
+	}
+
+	//refused by setting mismatch
+	other := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Checksum: true,
+		Hello: true,
+	})
+	other.SetMaxPackSize(1024)
+	if subErr := other.Connect(context.Background()); !errors.As(subErr, &frameErr) ||
+		frameErr.Code != define.FrameErrRejected {
+		t.Fatalf("unexpected mismatch reject err:%v", subErr)
+	}
+
+	//data frame refused before hello
+	plain := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Checksum: true,
+	})
+	if subErr := plain.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer plain.Close()
+	if _, subErr := plain.Call(context.Background(), 2, []byte("x")); !errors.As(subErr, &frameErr) ||
+		frameErr.Code != define.FrameErrNoHello {
+		t.Fatalf("unexpected no hello err:%v", subErr)
+	}
+}
+
+//test hello negotiated once, later hello rejected
+func TestHelloTwice(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Compress: []string{define.CompressFlate},
+	})
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+
+	//first hello negotiated
+	hello, _ := json.Marshal(&face.HelloInfo{
+		Magic: define.HelloMagic,
+		Version: define.ProtocolVersion,
+		Caps: define.CapFragment | define.CapStream,
+	})
+	writeFrame(t, conn, face.MakeKind(define.KindHello, 1), 0, hello)
+	reader := face.NewPacketReader(face.NewPacket(), 0)
+	message, subErr := reader.ReadMessage(conn)
+	if subErr != nil || face.KindType(message.GetKind()) != define.KindHello {
+		t.Fatalf("unexpected hello reply:%v, err:%v", message, subErr)
+	}
+
+	//second hello rejected, connect closed
+	hello, _ = json.Marshal(&face.HelloInfo{
+		Magic: define.HelloMagic,
+		Version: define.ProtocolVersion,
+	})
+	writeFrame(t, conn, face.MakeKind(define.KindHello, 2), 0, hello)
+	message, subErr = reader.ReadMessage(conn)
+	if subErr != nil || face.KindType(message.GetKind()) != define.KindError ||
+		face.KindSeq(message.GetKind()) != 2 {
+		t.Fatalf("second hello should be rejected, message:%v, err:%v", message, subErr)
+	}
+	if frameErr := face.DecodeFrameError(message); frameErr == nil || frameErr.Code != define.FrameErrRejected {
+		t.Fatalf("unexpected reject error:%v", frameErr)
+	}
+	if _, subErr = reader.ReadMessage(conn); subErr != io.EOF {
+		t.Fatalf("connect should be closed, err:%v", subErr)
+	}
+}
@@ -0,0 +1,164 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+)
+
+//check address can be connected or not
+func canDial(address string) bool {
+	conn, err := net.DialTimeout("tcp", address, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+//test server new, listen and serve
+func TestServerLifecycle(t *testing.T) {
+	//invalid conf
+	if _, subErr := cree.New(&cree.ServerConf{Engine: "unknown"}); subErr == nil {
+		t.Fatalf("new server with invalid engine should be failed")
+	}
+
+	//new without side effects
+	baseGoroutines := stableGoroutines()
+	server, subErr := cree.New(&cree.ServerConf{
+		Address: "tcp://127.0.0.1:0",
+	})
+	if subErr != nil {
+		t.Fatalf("new server failed, err:%v", subErr)
+	}
+	subErr = server.AddListener(&cree.ListenerConf{
+		Name: "extra",
+		Host: host,
+	})
+	if subErr != nil {
+		t.Fatalf("add listener before listen failed, err:%v", subErr)
+	}
+	if num := runtime.NumGoroutine(); num != baseGoroutines || serverAddr(server) != "" {
+		t.Fatalf("new server should not start anything, goroutines:%v -> %v", baseGoroutines, num)
+	}
+
+	//listen on conf address and added listener
+	if subErr = server.Listen(); subErr != nil {
+		t.Fatalf("listen failed, err:%v", subErr)
+	}
+	if subErr = server.Listen(); subErr == nil {
+		t.Fatalf("listen twice should be failed")
+	}
+	address, extraAddress := serverAddr(server), listenerAddr(server, "extra")
+	if !canDial(address) || !canDial(extraAddress) {
+		t.Fatalf("listeners should be ready after listen")
+	}
+
+	//address in use returned as error
+	other, _ := cree.New(&cree.ServerConf{
+		Address: "tcp://" + address,
+	})
+	if subErr = other.Listen(); subErr == nil {
+		t.Fatalf("listen on address in use should be failed")
+	}
+
+	//serve until ctx cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.Serve(ctx)
+	}()
+	cancel()
+	select {
+	case subErr = <-errChan:
+		if !errors.Is(subErr, context.Canceled) {
+			t.Fatalf("unexpected serve result:%v", subErr)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("wait serve return timeout")
+	}
+	if canDial(address) || canDial(extraAddress) {
+		t.Fatalf("listeners should be closed after serve returned")
+	}
+}
+
+//test serve return when shutdown by others
+func TestServeShutdown(t *testing.T) {
+	server, subErr := cree.New(&cree.ServerConf{
+		Address: "tcp://127.0.0.1:0",
+	})
+	if subErr != nil {
+		t.Fatalf("new server failed, err:%v", subErr)
+	}
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- server.Serve(context.Background())
+	}()
+	for i := 0; i < 20 && serverAddr(server) == ""; i++ {
+		time.Sleep(time.Millisecond * 50)
+	}
+	server.Shutdown(context.Background())
+	select {
+	case subErr = <-errChan:
+		if subErr != cree.ErrServerClosed {
+			t.Fatalf("unexpected serve result:%v", subErr)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("wait serve return timeout")
+	}
+}
+
+//test client connect and serve
+func TestClientLifecycle(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+
+	//new without side effects
+	baseGoroutines := stableGoroutines()
+	clientConf := &cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	}
+	client := cree.NewClient(clientConf)
+	if num := runtime.NumGoroutine(); num != baseGoroutines {
+		t.Fatalf("new client should not start goroutine, goroutines:%v -> %v", baseGoroutines, num)
+	}
+	if subErr := client.Serve(context.Background()); subErr == nil {
+		t.Fatalf("serve before connect should be failed")
+	}
+
+	//serve until ctx cancelled
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 100)
+	defer cancel()
+	if subErr := client.Serve(ctx); !errors.Is(subErr, context.DeadlineExceeded) {
+		t.Fatalf("unexpected serve result:%v", subErr)
+	}
+
+	//serve until closed
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("reconnect failed, err:%v", subErr)
+	}
+	time.AfterFunc(time.Millisecond * 50, client.Close)
+	if subErr := client.Serve(context.Background()); subErr != cree.ErrClientClosed {
+		t.Fatalf("unexpected serve result:%v", subErr)
+	}
+
+	//serve until server closed
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("reconnect failed, err:%v", subErr)
+	}
+	time.AfterFunc(time.Millisecond * 50, server.Stop)
+	if subErr := client.Serve(context.Background()); subErr != io.EOF {
+		t.Fatalf("unexpected serve result:%v", subErr)
+	}
+}
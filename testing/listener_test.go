@@ -0,0 +1,181 @@
+package testing
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//listener router, reply name of listener
+type listenerRouter struct {
+	face.BaseRouter
+}
+
+func (*listenerRouter) Handle(req iface.IRequest) {
+	conn := req.GetConnect()
+	conn.SendMessage(req.GetMessage().GetId(), []byte(conn.GetListener()))
+}
+
+//connect and ask listener name
+func askListener(t *testing.T, conf *cree.ClientConf) (*cree.Client, string) {
+	readChan := make(chan iface.IMessage, 1)
+	client := cree.NewClient(conf)
+	client.SetCBForRead(func(msg iface.IMessage) error {
+		readChan <- msg
+		return nil
+	})
+	if subErr := client.ConnServer(); subErr != nil {
+		t.Fatalf("connect server failed, err:%v", subErr)
+	}
+	client.SendPacket(3, []byte("which"))
+	select {
+	case msg := <-readChan:
+		return client, string(msg.GetData())
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait listener name timeout")
+	}
+	return client, ""
+}
+
+//echo once by client
+func echoOnce(t *testing.T, conf *cree.ClientConf) {
+	readChan := make(chan iface.IMessage, 1)
+	client := cree.NewClient(conf)
+	client.SetCBForRead(func(msg iface.IMessage) error {
+		readChan <- msg
+		return nil
+	})
+	if subErr := client.ConnServer(); subErr != nil {
+		t.Fatalf("connect server failed, err:%v", subErr)
+	}
+	defer client.Close()
+
+	client.SendPacket(1, []byte("ping"))
+	select {
+	case msg := <-readChan:
+		if string(msg.GetData()) != "ping" {
+			t.Fatalf("unexpected echo data:%v", string(msg.GetData()))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait echo timeout")
+	}
+}
+
+//test unix socket address
+func TestUnixSocket(t *testing.T) {
+	address := "unix://" + filepath.Join(t.TempDir(), "cree.sock")
+	startServer(t, &cree.ServerConf{
+		Address: address,
+	})
+	echoOnce(t, &cree.ClientConf{
+		Address: address,
+	})
+}
+
+//test server with outside listener
+func TestServerWithListener(t *testing.T) {
+	listener, subErr := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if subErr != nil {
+		t.Fatalf("listen failed, err:%v", subErr)
+	}
+	server := cree.NewServerWithListener(listener)
+	t.Cleanup(server.Stop)
+	server.AddRouter(1, &echoRouter{})
+
+	addr := listener.Addr().(*net.TCPAddr)
+	echoOnce(t, &cree.ClientConf{
+		Host: host,
+		Port: addr.Port,
+	})
+}
+
+//test multi listeners with own max connects and tls
+func TestMultiListeners(t *testing.T) {
+	ca := genTestCA(t)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	server.AddRouter(3, &listenerRouter{})
+
+	//add public listener with tls
+	subErr := server.AddListener(&cree.ListenerConf{
+		Name: "public",
+		Host: host,
+		MaxConnects: 1,
+		TLS: &define.TLSConf{
+			Certificates: []tls.Certificate{ca.issue(t, "server", 4)},
+		},
+	})
+	if subErr != nil {
+		t.Fatalf("add listener failed, err:%v", subErr)
+	}
+	subErr = server.AddListener(&cree.ListenerConf{
+		Name: "public",
+		Host: host,
+	})
+	if subErr == nil {
+		t.Fatalf("duplicate listener name should be refused")
+	}
+
+	publicAddr := listenerAddr(server, "public")
+
+	//internal client
+	internalClient, name := askListener(t, &cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	defer internalClient.Close()
+	if name != define.ListenerDefault {
+		t.Fatalf("unexpected listener of internal client:%v", name)
+	}
+
+	//public client
+	publicConf := &cree.ClientConf{
+		Host: host,
+		Port: server.GetListenerAddr("public").(*net.TCPAddr).Port,
+		TLS: &define.TLSConf{
+			CAPool: ca.pool,
+		},
+	}
+	publicClient, name := askListener(t, publicConf)
+	if name != "public" {
+		t.Fatalf("unexpected listener of public client:%v", name)
+	}
+	if connects := server.GetListenerConnects("public"); connects != 1 {
+		t.Fatalf("unexpected public connects:%v", connects)
+	}
+
+	//public listener up to max connects
+	conn, subErr := tls.Dial("tcp", publicAddr, &tls.Config{
+		RootCAs: ca.pool,
+	})
+	if subErr == nil {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, subErr = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if subErr == nil {
+		t.Fatalf("connect over max count should be refused")
+	}
+
+	//released after public client closed
+	publicClient.Close()
+	for i := 0; i < 20 && server.GetListenerConnects("public") > 0; i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if connects := server.GetListenerConnects("public"); connects != 0 {
+		t.Fatalf("public connects not released:%v", connects)
+	}
+	publicClient, name = askListener(t, publicConf)
+	defer publicClient.Close()
+	if name != "public" {
+		t.Fatalf("unexpected listener of public client:%v", name)
+	}
+}
@@ -0,0 +1,376 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//read same frame repeatedly
+type frameConn struct {
+	net.Conn
+	frame []byte
+}
+
+func (c *frameConn) Read(p []byte) (int, error) {
+	return copy(p, c.frame), nil
+}
+
+func (c *frameConn) LocalAddr() net.Addr {
+	return nil
+}
+
+func (c *frameConn) RemoteAddr() net.Addr {
+	return nil
+}
+
+//sum data of message, request not kept
+type sumRouter struct {
+	face.BaseRouter
+	sum int
+}
+
+func (r *sumRouter) Handle(req iface.IRequest) {
+	for _, v := range req.GetMessage().GetData() {
+		r.sum += int(v)
+	}
+}
+
+//test pack and unpack
+func TestPacketPackUnPack(t *testing.T) {
+	packet := face.NewPacket()
+	message := face.NewMessage()
+	message.SetId(3)
+	message.SetKind(2)
+	message.SetData([]byte("hello"))
+
+	byteData, subErr := packet.Pack(message)
+	if subErr != nil {
+		t.Fatalf("pack failed, err:%v", subErr)
+	}
+	appended, _ := packet.AppendPack([]byte("x"), message)
+	if !bytes.Equal(appended[1:], byteData) {
+		t.Fatalf("append pack not same as pack")
+	}
+
+	headLen := packet.GetHeadLen()
+	out, subErr := packet.UnPack(byteData[:headLen])
+	if subErr != nil {
+		t.Fatalf("unpack failed, err:%v", subErr)
+	}
+	if out.GetId() != 3 || out.GetKind() != 2 || out.GetLen() != 5 {
+		t.Fatalf("unexpected message, id:%v, kind:%v, len:%v",
+			out.GetId(), out.GetKind(), out.GetLen())
+	}
+}
+
+//test built-in codecs framed by reader
+func TestPacketCodecs(t *testing.T) {
+	names := []string{
+		define.PacketDefault,
+		define.PacketCompact,
+		define.PacketVarint,
+		define.PacketLength,
+		define.PacketDelimiter,
+	}
+	for _, name := range names {
+		packet, subErr := face.CreatePacket(name)
+		if subErr != nil {
+			t.Fatalf("create packet %v failed, err:%v", name, subErr)
+		}
+
+		//pack messages into one stream
+		stream := make([]byte, 0)
+		datas := []string{"hello", "", "world"}
+		for _, data := range datas {
+			message := face.NewMessage()
+			message.SetId(1)
+			message.SetData([]byte(data))
+			stream, subErr = packet.AppendPack(stream, message)
+			if subErr != nil {
+				t.Fatalf("%v pack failed, err:%v", name, subErr)
+			}
+		}
+
+		//frame stream read byte by byte
+		reader := face.NewPacketReader(packet, 4)
+		source := iotest.OneByteReader(bytes.NewReader(stream))
+		for _, data := range datas {
+			message, subErr := reader.ReadMessage(source)
+			if subErr != nil {
+				t.Fatalf("%v read message failed, err:%v", name, subErr)
+			}
+			if string(message.GetData()) != data {
+				t.Fatalf("%v unexpected data:%v, expected:%v", name, string(message.GetData()), data)
+			}
+		}
+
+		//half message kept as pending
+		_, subErr = reader.ReadMessage(bytes.NewReader(stream[:3]))
+		if subErr == nil || len(reader.Pending()) != 3 {
+			t.Fatalf("%v half message should be pending, err:%v", name, subErr)
+		}
+
+		//too large message
+		packet.SetMaxPackSize(4)
+		_, _, subErr = packet.Decode(stream)
+		if !errors.Is(subErr, face.ErrPacketTooLarge) {
+			t.Fatalf("%v decode too large message, unexpected err:%v", name, subErr)
+		}
+	}
+
+	//unknown and registered codec
+	if _, subErr := face.CreatePacket("unknown"); subErr == nil {
+		t.Fatalf("create unknown packet should be failed")
+	}
+	face.RegisterPacket("crlf", func() iface.IPacket {
+		return face.NewDelimiterPacket([]byte("\r\n"))
+	})
+	if _, subErr := face.CreatePacket("crlf"); subErr != nil {
+		t.Fatalf("create registered packet failed, err:%v", subErr)
+	}
+}
+
+//test server and client with selected codec
+func TestPacketCodecServe(t *testing.T) {
+	if _, subErr := cree.New(&cree.ServerConf{Packet: "unknown"}); subErr == nil {
+		t.Fatalf("new server with unknown packet should be failed")
+	}
+	names := []string{
+		define.PacketCompact,
+		define.PacketVarint,
+		define.PacketLength,
+		define.PacketDelimiter,
+	}
+	for _, name := range names {
+		server := startServer(t, &cree.ServerConf{
+			Host: host,
+			Packet: name,
+		})
+		server.RegisterRedirect(&echoRouter{})
+
+		//echo by redirect router if no message id
+		readChan := make(chan iface.IMessage, 1)
+		client := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+			Packet: name,
+		})
+		client.SetCBForRead(func(msg iface.IMessage) error {
+			readChan <- msg
+			return nil
+		})
+		if subErr := client.ConnServer(); subErr != nil {
+			t.Fatalf("%v connect failed, err:%v", name, subErr)
+		}
+		client.SendPacket(1, []byte(name))
+		select {
+		case msg := <-readChan:
+			if string(msg.GetData()) != name {
+				t.Fatalf("%v unexpected echo data:%v", name, string(msg.GetData()))
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("%v echo timeout", name)
+		}
+		client.Close()
+		server.Stop()
+	}
+}
+
+//test allocation budgets of codec path
+func TestPacketAllocs(t *testing.T) {
+	packet := face.NewPacket()
+	message := face.NewMessage()
+	message.SetId(1)
+	message.SetData(make([]byte, 128))
+	dst := make([]byte, 0, 1024)
+	header, _ := packet.Pack(message)
+	header = header[:packet.GetHeadLen()]
+
+	checks := []struct {
+		name   string
+		budget float64
+		fn     func()
+	}{
+		{"AppendPack", 0, func() {
+			dst, _ = packet.AppendPack(dst[:0], message)
+		}},
+		{"UnPackTo", 0, func() {
+			packet.UnPackTo(header, message)
+		}},
+		{"Pack", 1, func() {
+			packet.Pack(message)
+		}},
+		{"PooledMessage", 0, func() {
+			m := face.AcquireMessage()
+			m.SetId(1)
+			face.ReleaseMessage(m)
+		}},
+		{"PooledBuffer", 0, func() {
+			buff := face.AcquireBuffer()
+			*buff, _ = packet.AppendPack(*buff, message)
+			face.ReleaseBuffer(buff)
+		}},
+	}
+	for _, check := range checks {
+		allocs := testing.AllocsPerRun(1000, check.fn)
+		if allocs > check.budget {
+			t.Errorf("%v allocs:%v, over budget:%v", check.name, allocs, check.budget)
+		}
+	}
+}
+
+func BenchmarkPacketPack(b *testing.B) {
+	packet := face.NewPacket()
+	message := face.NewMessage()
+	message.SetId(1)
+	message.SetData(make([]byte, 128))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet.Pack(message)
+	}
+}
+
+func BenchmarkPacketAppendPack(b *testing.B) {
+	packet := face.NewPacket()
+	message := face.NewMessage()
+	message.SetId(1)
+	message.SetData(make([]byte, 128))
+	dst := make([]byte, 0, 1024)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		dst, _ = packet.AppendPack(dst[:0], message)
+	}
+}
+
+func BenchmarkPacketUnPack(b *testing.B) {
+	packet := face.NewPacket()
+	message := face.NewMessage()
+	message.SetId(1)
+	byteData, _ := packet.Pack(message)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet.UnPack(byteData)
+	}
+}
+
+func BenchmarkPacketUnPackTo(b *testing.B) {
+	packet := face.NewPacket()
+	message := face.NewMessage()
+	message.SetId(1)
+	byteData, _ := packet.Pack(message)
+	out := face.NewMessage()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		packet.UnPackTo(byteData, out)
+	}
+}
+
+//test checksum header, corrupt frame counted and connect closed
+func TestPacketChecksum(t *testing.T) {
+	packet := face.NewPacket()
+	packet.SetChecksum(true)
+	byteData, _ := packet.Pack(newMessage(face.MakeKind(define.KindRequest, 1), 3, []byte("hello")))
+	if packet.GetHeadLen() != face.PacketCheckHeadSize || len(byteData) != face.PacketCheckHeadSize + 5 {
+		t.Fatalf("unexpected checksum frame size:%v", len(byteData))
+	}
+	message, _, subErr := packet.Decode(byteData)
+	if subErr != nil || message.GetId() != 3 || string(message.GetData()) != "hello" {
+		t.Fatalf("decode failed, err:%v", subErr)
+	}
+
+	//flipped bit of id or data refused
+	for _, pos := range []int{8, face.PacketCheckHeadSize} {
+		corrupt := append([]byte(nil), byteData...)
+		corrupt[pos] ^= 1
+		if _, _, subErr = packet.Decode(corrupt); subErr != face.ErrCorruptFrame {
+			t.Fatalf("corrupt frame at %v should be refused, err:%v", pos, subErr)
+		}
+		if _, subErr = packet.UnPack(corrupt); subErr != face.ErrCorruptFrame {
+			t.Fatalf("corrupt frame at %v should be refused by unpack, err:%v", pos, subErr)
+		}
+	}
+	if packet.GetCorruptCount() != 4 {
+		t.Fatalf("unexpected corrupt count:%v", packet.GetCorruptCount())
+	}
+
+	//server and client with checksum
+	if _, subErr = cree.New(&cree.ServerConf{Checksum: true, Packet: define.PacketVarint}); subErr == nil {
+		t.Fatalf("new server with checksum of varint should be failed")
+	}
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Checksum: true,
+	})
+	server.AddRouter(2, &replyRouter{})
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Checksum: true,
+	})
+	if subErr = client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+	if message, subErr = client.Call(context.Background(), 2, []byte("sum")); subErr != nil ||
+		string(message.GetData()) != "sum" {
+		t.Fatalf("call with checksum failed, err:%v", subErr)
+	}
+
+	//corrupt frame close connect
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	byteData, _ = packet.Pack(newMessage(face.MakeKind(define.KindRequest, 1), 2, []byte("sum")))
+	byteData[len(byteData) - 1] ^= 1
+	conn.Write(byteData)
+	if _, subErr = conn.Read(make([]byte, 64)); subErr != io.EOF {
+		t.Fatalf("connect should be closed, err:%v", subErr)
+	}
+	if server.GetCorruptCount() != 1 || client.GetCorruptCount() != 0 {
+		t.Fatalf("unexpected corrupt count:%v", server.GetCorruptCount())
+	}
+}
+
+//test read, route and release of one message without allocation
+func TestReadDispatchAllocs(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	message := face.NewMessage()
+	message.SetId(2)
+	message.SetData(bytes.Repeat([]byte{1}, 128))
+	frame, _ := server.GetPacket().Pack(message)
+
+	//connect read frames of fake conn
+	router := &sumRouter{}
+	handler := face.NewHandler()
+	handler.AddRouter(2, router)
+	connect := face.NewConnect(server, &frameConn{frame: frame}, 1, handler)
+	allocs := testing.AllocsPerRun(1000, func() {
+		req, subErr := connect.ReadMessage()
+		if subErr != nil {
+			t.Fatalf("read message failed, err:%v", subErr)
+		}
+		face.ReleaseRequest(req)
+	})
+	if allocs > 0 {
+		t.Errorf("read dispatch allocs:%v, over budget:0", allocs)
+	}
+	if router.sum <= 0 {
+		t.Fatalf("message not routed")
+	}
+}
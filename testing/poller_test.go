@@ -0,0 +1,268 @@
+//go:build linux
+
+package testing
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//echo after released
+type holdRouter struct {
+	face.BaseRouter
+	started chan bool
+	release chan struct{}
+}
+
+func (r *holdRouter) Handle(req iface.IRequest) {
+	select {
+	case r.started <- true:
+	default:
+	}
+	<-r.release
+	message := req.GetMessage()
+	req.GetConnect().SendMessage(message.GetId(), message.GetData())
+}
+
+//read stream after released
+type holdStreamRouter struct {
+	face.BaseRouter
+	release chan struct{}
+	errChan chan error
+}
+
+func (r *holdStreamRouter) HandleStream(req iface.IRequest, reader io.Reader) {
+	<-r.release
+	_, err := io.Copy(io.Discard, reader)
+	r.errChan <- err
+}
+
+//test epoll engine echo
+func TestEpollEngine(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Engine: define.EngineEpoll,
+		EventLoops: 2,
+	})
+
+	//init clients
+	clients := make([]*cree.Client, 0)
+	readChan := make(chan iface.IMessage, 64)
+	for i := 0; i < 4; i++ {
+		c := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+		})
+		c.SetCBForRead(func(msg iface.IMessage) error {
+			readChan <- msg
+			return nil
+		})
+		if subErr := c.ConnServer(); subErr != nil {
+			t.Fatalf("connect client failed, err:%v", subErr)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	//send and wait echo
+	for _, c := range clients {
+		if subErr := c.SendPacket(1, []byte("epoll")); subErr != nil {
+			t.Fatalf("send packet failed, err:%v", subErr)
+		}
+	}
+	for i := 0; i < len(clients); i++ {
+		select {
+		case msg := <-readChan:
+			if string(msg.GetData()) != "epoll" {
+				t.Fatalf("unexpected echo data:%v", string(msg.GetData()))
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("echo timeout, received:%v", i)
+		}
+	}
+}
+
+//test epoll engine framing with header not fixed
+func TestEpollPacketCodec(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Engine: define.EngineEpoll,
+		EventLoops: 1,
+		Packet: define.PacketVarint,
+	})
+	server.RegisterRedirect(&echoRouter{})
+
+	readChan := make(chan iface.IMessage, 4)
+	c := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Packet: define.PacketVarint,
+	})
+	c.SetCBForRead(func(msg iface.IMessage) error {
+		readChan <- msg
+		return nil
+	})
+	if subErr := c.ConnServer(); subErr != nil {
+		t.Fatalf("connect client failed, err:%v", subErr)
+	}
+	defer c.Close()
+
+	//large message framed by multi reads
+	datas := []string{"epoll", string(make([]byte, 1500)), "varint"}
+	for _, data := range datas {
+		c.SendPacket(1, []byte(data))
+	}
+	for _, data := range datas {
+		select {
+		case msg := <-readChan:
+			if string(msg.GetData()) != data {
+				t.Fatalf("unexpected echo data len:%v", len(msg.GetData()))
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("echo timeout")
+		}
+	}
+}
+
+//test blocked router not delay other connect of same event loop
+func TestEpollBlockedRouter(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Engine: define.EngineEpoll,
+		EventLoops: 1,
+		Buckets: 1,
+	})
+	router := &holdRouter{
+		started: make(chan bool, 1),
+		release: make(chan struct{}),
+	}
+	server.AddRouter(2, router)
+
+	//init held and other clients
+	clients := make([]*cree.Client, 0)
+	readChans := make([]chan iface.IMessage, 0)
+	for i := 0; i < 2; i++ {
+		readChan := make(chan iface.IMessage, 256)
+		c := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+		})
+		c.SetCBForRead(func(msg iface.IMessage) error {
+			readChan <- msg
+			return nil
+		})
+		if subErr := c.ConnServer(); subErr != nil {
+			t.Fatalf("connect client failed, err:%v", subErr)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+		readChans = append(readChans, readChan)
+	}
+
+	//held messages over queue size
+	total := define.DefaultEventLoopQueueSize * 2
+	for i := 0; i < total; i++ {
+		clients[0].SendPacket(2, []byte(strconv.Itoa(i)))
+	}
+	select {
+	case <-router.started:
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait held message timeout")
+	}
+
+	//other connect served
+	clients[1].SendPacket(1, []byte("epoll"))
+	select {
+	case msg := <-readChans[1]:
+		if string(msg.GetData()) != "epoll" {
+			t.Fatalf("unexpected echo data:%v", string(msg.GetData()))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("echo delayed by blocked router")
+	}
+
+	//held messages replied in order after released
+	close(router.release)
+	for i := 0; i < total; i++ {
+		select {
+		case msg := <-readChans[0]:
+			if string(msg.GetData()) != strconv.Itoa(i) {
+				t.Fatalf("unexpected held reply:%v, expect:%v", string(msg.GetData()), i)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("wait held reply timeout, received:%v", i)
+		}
+	}
+}
+
+//test stream router not read reset, not delay other connect of same event loop
+func TestEpollStreamRouterHeld(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Engine: define.EngineEpoll,
+		EventLoops: 1,
+		FragmentBudget: 64 << 10,
+	})
+	router := &holdStreamRouter{
+		release: make(chan struct{}),
+		errChan: make(chan error, 1),
+	}
+	server.AddRouter(2, &replyRouter{})
+	server.AddRouter(5, router)
+	defer close(router.release)
+
+	//init held and other clients
+	clients := make([]*cree.Client, 0)
+	for i := 0; i < 2; i++ {
+		c := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+			Hello: true,
+		})
+		if subErr := c.Connect(context.Background()); subErr != nil {
+			t.Fatalf("connect client failed, err:%v", subErr)
+		}
+		defer c.Close()
+		clients = append(clients, c)
+	}
+
+	//stream data over budget not read by router
+	errChan := make(chan error, 1)
+	go func() {
+		_, subErr := clients[0].Call(context.Background(), 5, make([]byte, 256 << 10))
+		errChan <- subErr
+	}()
+
+	//other connect served
+	time.Sleep(time.Millisecond * 100)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond * 500)
+	defer cancel()
+	message, subErr := clients[1].Call(ctx, 2, []byte("epoll"))
+	if subErr != nil || string(message.GetData()) != "epoll" {
+		t.Fatalf("call of other connect failed, err:%v", subErr)
+	}
+
+	//refused as too large, stream reset
+	frameErr := &face.FrameError{}
+	select {
+	case subErr = <-errChan:
+		if !errors.As(subErr, &frameErr) || frameErr.Code != define.FrameErrTooLarge {
+			t.Fatalf("unexpected held stream err:%v", subErr)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatalf("wait held stream refused timeout")
+	}
+	router.release <- struct{}{}
+	if subErr = <-router.errChan; !errors.As(subErr, &frameErr) || frameErr.Code != define.FrameErrTooLarge {
+		t.Fatalf("unexpected stream err of router:%v", subErr)
+	}
+}
@@ -0,0 +1,153 @@
+package testing
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//dial and write proxy header, wait server connect
+func dialWithProxyHeader(
+	t *testing.T,
+	address string,
+	connChan chan iface.IConnect,
+	header []byte) (net.Conn, iface.IConnect) {
+	conn, subErr := net.Dial("tcp", address)
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	if _, subErr = conn.Write(header); subErr != nil {
+		t.Fatalf("write proxy header failed, err:%v", subErr)
+	}
+	select {
+	case connect := <-connChan:
+		return conn, connect
+	case <-time.After(time.Second * 2):
+		conn.Close()
+		return nil, nil
+	}
+}
+
+//gen v2 header of tcp4 with tlv
+func genProxyV2Header(src, dst *net.TCPAddr, tlvType byte, tlvValue []byte) []byte {
+	body := make([]byte, 12)
+	copy(body[0:4], src.IP.To4())
+	copy(body[4:8], dst.IP.To4())
+	binary.BigEndian.PutUint16(body[8:10], uint16(src.Port))
+	binary.BigEndian.PutUint16(body[10:12], uint16(dst.Port))
+	body = append(body, tlvType, 0, byte(len(tlvValue)))
+	body = append(body, tlvValue...)
+
+	header := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	header = append(header, 0x21, 0x11, 0, 0)
+	binary.BigEndian.PutUint16(header[14:16], uint16(len(body)))
+	return append(header, body...)
+}
+
+//test proxy protocol v1 and v2
+func TestProxyProtocol(t *testing.T) {
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		ProxyProtocol: true,
+		TrustedProxies: []string{"127.0.0.0/8"},
+	})
+	server.AddRouter(1, &echoRouter{})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+
+	//v1 header
+	conn, connect := dialWithProxyHeader(t, serverAddr(server), connChan,
+		[]byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 5300\r\n"))
+	if connect == nil {
+		t.Fatalf("wait v1 connect timeout")
+	}
+	if addr := connect.GetRemoteAddr().String(); addr != "203.0.113.7:56324" {
+		t.Fatalf("unexpected v1 remote addr:%v", addr)
+	}
+	if connect.GetProxyAddr() == nil {
+		t.Fatalf("v1 proxy addr should not be nil")
+	}
+
+	//data after header routed as normal
+	readChan := make(chan []byte, 1)
+	go func() {
+		buff := make([]byte, 64)
+		n, _ := conn.Read(buff)
+		readChan <- buff[:n]
+	}()
+	message := face.NewMessage()
+	message.SetId(1)
+	message.SetData([]byte("ping"))
+	byteData, _ := server.GetPacket().Pack(message)
+	conn.Write(byteData)
+	select {
+	case data := <-readChan:
+		if len(data) != len(byteData) {
+			t.Fatalf("unexpected echo size:%v", len(data))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait echo after v1 header timeout")
+	}
+	conn.Close()
+
+	//v2 header with tlv
+	src := &net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 40000}
+	dst := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5300}
+	conn, connect = dialWithProxyHeader(t, serverAddr(server), connChan,
+		genProxyV2Header(src, dst, define.ProxyV2TLVAuthority, []byte("cree.example.com")))
+	if connect == nil {
+		t.Fatalf("wait v2 connect timeout")
+	}
+	defer conn.Close()
+	if addr := connect.GetRemoteAddr().String(); addr != src.String() {
+		t.Fatalf("unexpected v2 remote addr:%v", addr)
+	}
+	tlvs := connect.GetProxyTLVs()
+	if len(tlvs) != 1 || tlvs[0].Type != define.ProxyV2TLVAuthority ||
+		string(tlvs[0].Value) != "cree.example.com" {
+		t.Fatalf("unexpected v2 tlvs:%v", tlvs)
+	}
+
+	//invalid header refused
+	conn, connect = dialWithProxyHeader(t, serverAddr(server), connChan,
+		[]byte("GET / HTTP/1.1\r\n\r\n"))
+	if connect != nil {
+		t.Fatalf("invalid proxy header should be refused")
+	}
+}
+
+//test proxy header of untrusted source not parsed, none trusted if empty
+func TestProxyProtocolUntrusted(t *testing.T) {
+	for _, trusted := range [][]string{{"10.0.0.0/8"}, nil} {
+		connChan := make(chan iface.IConnect, 1)
+		server := startServer(t, &cree.ServerConf{
+			Host: host,
+			ProxyProtocol: true,
+			TrustedProxies: trusted,
+		})
+		server.SetConnected(func(conn iface.IConnect) {
+			connChan <- conn
+		})
+
+		conn, connect := dialWithProxyHeader(t, serverAddr(server), connChan,
+			[]byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 5300\r\n"))
+		if connect == nil {
+			t.Fatalf("wait connect timeout, trusted:%v", trusted)
+		}
+		defer conn.Close()
+		if addr := connect.GetRemoteAddr().String(); addr != conn.LocalAddr().String() {
+			t.Fatalf("untrusted source should keep socket addr, trusted:%v, got:%v", trusted, addr)
+		}
+		if connect.GetProxyAddr() != nil {
+			t.Fatalf("untrusted source should not have proxy addr, trusted:%v", trusted)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//test sealed data opened by peer, tampered and replayed refused
+func TestSecurePacket(t *testing.T) {
+	staticKey, _ := face.NewSecureKey()
+	clientKey, _ := face.NewSecureKey()
+	serverState, serverKey, subErr := face.AcceptSecure(clientKey.PublicKey().Bytes(), staticKey)
+	if subErr != nil {
+		t.Fatalf("accept secure failed, err:%v", subErr)
+	}
+	clientState, subErr := face.DialSecure(clientKey, serverKey, staticKey.PublicKey().Bytes())
+	if subErr != nil {
+		t.Fatalf("dial secure failed, err:%v", subErr)
+	}
+	sender, _ := face.NewSecurePacket(face.NewPacket(), clientState)
+	receiver, _ := face.NewSecurePacket(face.NewPacket(), serverState)
+
+	//sealed on wire and opened
+	data := []byte("secret of cree")
+	byteData, _ := sender.Pack(newMessage(face.MakeKind(define.KindRequest, 1), 2, data))
+	if bytes.Contains(byteData, data) {
+		t.Fatalf("data should be sealed")
+	}
+	message, _, subErr := receiver.Decode(byteData)
+	if subErr != nil || !bytes.Equal(message.GetData(), data) ||
+		message.GetKind() != face.MakeKind(define.KindRequest, 1) {
+		t.Fatalf("open failed, err:%v", subErr)
+	}
+
+	//replayed, tampered and plain frame refused
+	if _, _, subErr = receiver.Decode(byteData); !errors.Is(subErr, face.ErrSecureFrame) {
+		t.Fatalf("replayed frame should be refused, err:%v", subErr)
+	}
+	byteData, _ = sender.Pack(newMessage(face.MakeKind(define.KindRequest, 2), 2, data))
+	byteData[9] ^= 1
+	if _, _, subErr = receiver.Decode(byteData); !errors.Is(subErr, face.ErrSecureFrame) {
+		t.Fatalf("tampered frame should be refused, err:%v", subErr)
+	}
+	byteData, _ = face.NewPacket().Pack(newMessage(0, 2, data))
+	if _, _, subErr = receiver.Decode(byteData); !errors.Is(subErr, face.ErrSecureFrame) {
+		t.Fatalf("plain frame should be refused, err:%v", subErr)
+	}
+	plain, _ := face.NewSecurePacket(face.NewPacket(), serverState)
+	byteData, _ = face.NewPacket().Pack(newMessage(face.MakeKind(define.KindHello, 1), 0, []byte("{}")))
+	if _, _, subErr = plain.Decode(byteData); !errors.Is(subErr, face.ErrSecureFrame) {
+		t.Fatalf("plain hello should be refused after keys derived, err:%v", subErr)
+	}
+
+	//wrong static key not opened
+	otherKey, _ := face.NewSecureKey()
+	otherState, _ := face.DialSecure(clientKey, serverKey, otherKey.PublicKey().Bytes())
+	other, _ := face.NewSecurePacket(face.NewPacket(), otherState)
+	byteData, _ = other.Pack(newMessage(0, 2, data))
+	if _, _, subErr = receiver.Decode(byteData); !errors.Is(subErr, face.ErrSecureFrame) {
+		t.Fatalf("frame of other key should be refused, err:%v", subErr)
+	}
+
+	//compressed before sealed, counter kept by state
+	state := sender.GetState()
+	restored, _ := face.NewSecurePacket(face.NewPacket(), state)
+	packet, _ := face.NewCompressPacket(restored, define.CompressFlate, 0)
+	large := bytes.Repeat(data, 100)
+	byteData, _ = packet.Pack(newMessage(0, 3, large))
+	if len(byteData) >= len(large) || face.GetSecurePacket(packet) != restored {
+		t.Fatalf("data should be compressed, size:%v", len(byteData))
+	}
+	opener, _ := face.NewCompressPacket(receiver, define.CompressFlate, 0)
+	if message, _, subErr = opener.Decode(byteData); subErr != nil || !bytes.Equal(message.GetData(), large) {
+		t.Fatalf("open compressed failed, err:%v", subErr)
+	}
+}
+
+//test secure channel negotiated by client, pinned key and plain connect
+func TestSecureServe(t *testing.T) {
+	staticKey, _ := face.NewSecureKey()
+	if _, subErr := cree.New(&cree.ServerConf{Secure: true, Packet: define.PacketVarint}); subErr == nil {
+		t.Fatalf("new secure server with varint codec should be failed")
+	}
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Secure: true,
+		SecureKey: staticKey.Bytes(),
+		Compress: []string{define.CompressFlate},
+	})
+	server.AddRouter(2, &replyRouter{})
+
+	//call and broadcast with pinned key
+	pushChan := make(chan iface.IMessage, 1)
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Secure: true,
+		SecureServerKey: staticKey.PublicKey().Bytes(),
+		Compress: []string{define.CompressFlate},
+	})
+	client.SetCBForRead(func(msg iface.IMessage) error {
+		pushChan <- msg
+		return nil
+	})
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+	for _, data := range [][]byte{[]byte("x"), bytes.Repeat([]byte("cree"), 200)} {
+		message, subErr := client.Call(context.Background(), 2, data)
+		if subErr != nil || !bytes.Equal(message.GetData(), data) {
+			t.Fatalf("secure call failed, err:%v", subErr)
+		}
+	}
+	server.SendMessage(&define.SendMsgReq{MsgId: 3, Data: []byte("all")})
+	select {
+	case msg := <-pushChan:
+		if string(msg.GetData()) != "all" {
+			t.Fatalf("unexpected broadcast data:%v", string(msg.GetData()))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait broadcast timeout")
+	}
+
+	//other pinned key refused
+	otherKey, _ := face.NewSecureKey()
+	other := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Secure: true,
+		SecureServerKey: otherKey.PublicKey().Bytes(),
+	})
+	if subErr := other.Connect(context.Background()); subErr != cree.ErrSecureKeyPinned {
+		t.Fatalf("unexpected pinned key err:%v", subErr)
+	}
+
+	//plain data frame refused
+	plain := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+	})
+	if subErr := plain.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer plain.Close()
+	frameErr := &face.FrameError{}
+	if _, subErr := plain.Call(context.Background(), 2, []byte("x")); !errors.As(subErr, &frameErr) ||
+		frameErr.Code != define.FrameErrInsecure {
+		t.Fatalf("unexpected insecure err:%v", subErr)
+	}
+
+	//secure refused by plain server
+	plainServer := startServer(t, &cree.ServerConf{
+		Host: host,
+	})
+	refused := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(plainServer),
+		Secure: true,
+	})
+	if subErr := refused.Connect(context.Background()); subErr != cree.ErrSecureRefused {
+		t.Fatalf("unexpected refused err:%v", subErr)
+	}
+}
+
+//test replayed frame of raw connect close it
+func TestSecureReplay(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Secure: true,
+	})
+	server.AddRouter(2, &replyRouter{})
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+
+	//hello with key
+	clientKey, _ := face.NewSecureKey()
+	hello, _ := json.Marshal(&face.HelloInfo{Key: clientKey.PublicKey().Bytes()})
+	writeFrame(t, conn, face.MakeKind(define.KindHello, 1), 0, hello)
+	reader := face.NewPacketReader(face.NewPacket(), 0)
+	message, subErr := reader.ReadMessage(conn)
+	reply := &face.HelloInfo{}
+	if subErr != nil || json.Unmarshal(message.GetData(), reply) != nil || len(reply.Key) <= 0 {
+		t.Fatalf("unexpected hello reply:%v, err:%v", message, subErr)
+	}
+	state, _ := face.DialSecure(clientKey, reply.Key, reply.StaticKey)
+	packet, _ := face.NewSecurePacket(face.NewPacket(), state)
+	reader.SetPacket(packet)
+
+	//sealed request answered
+	byteData, _ := packet.Pack(newMessage(face.MakeKind(define.KindRequest, 2), 2, []byte("echo")))
+	conn.Write(byteData)
+	message, subErr = reader.ReadMessage(conn)
+	if subErr != nil || string(message.GetData()) != "echo" {
+		t.Fatalf("read sealed reply failed, err:%v", subErr)
+	}
+
+	//replayed request close connect
+	conn.Write(byteData)
+	if _, subErr = reader.ReadMessage(conn); subErr != io.EOF {
+		t.Fatalf("connect should be closed, err:%v", subErr)
+	}
+}
+
+//test plain hello injected after secure channel not downgrade connect
+func TestSecureDowngrade(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Secure: true,
+		Compress: []string{define.CompressFlate},
+	})
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+
+	//hello with key
+	clientKey, _ := face.NewSecureKey()
+	hello, _ := json.Marshal(&face.HelloInfo{Key: clientKey.PublicKey().Bytes()})
+	writeFrame(t, conn, face.MakeKind(define.KindHello, 1), 0, hello)
+	reader := face.NewPacketReader(face.NewPacket(), 0)
+	message, subErr := reader.ReadMessage(conn)
+	reply := &face.HelloInfo{}
+	if subErr != nil || json.Unmarshal(message.GetData(), reply) != nil || len(reply.Key) <= 0 {
+		t.Fatalf("unexpected hello reply:%v, err:%v", message, subErr)
+	}
+
+	//plain hello without key refused, nothing sent in plain
+	hello, _ = json.Marshal(&face.HelloInfo{Compress: []string{define.CompressFlate}})
+	writeFrame(t, conn, face.MakeKind(define.KindHello, 2), 0, hello)
+	if message, subErr = reader.ReadMessage(conn); subErr != io.EOF {
+		t.Fatalf("connect should be closed, message:%v, err:%v", message, subErr)
+	}
+}
@@ -0,0 +1,82 @@
+package testing
+
+import (
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * server for testing
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - server bind random port, stopped after test
+ */
+
+//echo router
+type echoRouter struct {
+	face.BaseRouter
+}
+
+func (*echoRouter) Handle(req iface.IRequest) {
+	message := req.GetMessage()
+	req.GetConnect().SendMessage(message.GetId(), message.GetData())
+}
+
+//start local server for testing, stopped after test
+func startServer(t testing.TB, conf *cree.ServerConf) *cree.Server {
+	server := newTestServer(conf)
+	t.Cleanup(server.Stop)
+	return server
+}
+
+//new listened server, bind random port if address not set
+func newTestServer(conf *cree.ServerConf) *cree.Server {
+	if conf.Address == "" {
+		conf.Address = "tcp://" + net.JoinHostPort(host, "0")
+	}
+	server := cree.NewServer(conf)
+	server.AddRouter(1, &echoRouter{})
+	return server
+}
+
+//get address of default listener
+func serverAddr(server *cree.Server) string {
+	return listenerAddr(server, define.ListenerDefault)
+}
+
+//get port of default listener
+func serverPort(server *cree.Server) int {
+	_, port, _ := net.SplitHostPort(serverAddr(server))
+	v, _ := net.LookupPort("tcp", port)
+	return v
+}
+
+//get address of listener by name
+func listenerAddr(server *cree.Server, name string) string {
+	addr := server.GetListenerAddr(name)
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+//wait goroutines of earlier tests quit, return stable count
+func stableGoroutines() int {
+	num := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		time.Sleep(time.Millisecond * 50)
+		now := runtime.NumGoroutine()
+		if now == num {
+			break
+		}
+		num = now
+	}
+	return num
+}
@@ -0,0 +1,145 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//slow router, reply after a while
+type slowRouter struct {
+	face.BaseRouter
+	started chan bool
+}
+
+func (r *slowRouter) Handle(req iface.IRequest) {
+	r.started <- true
+	time.Sleep(time.Millisecond * 300)
+	message := req.GetMessage()
+	req.GetConnect().SendMessage(message.GetId(), message.GetData())
+}
+
+//read all messages until connect closed
+func readAllMessages(t *testing.T, conn net.Conn) []iface.IMessage {
+	var (
+		messages []iface.IMessage
+	)
+	packet := face.NewPacket()
+	header := make([]byte, packet.GetHeadLen())
+	conn.SetReadDeadline(time.Now().Add(time.Second * 3))
+	for {
+		if _, subErr := io.ReadFull(conn, header); subErr != nil {
+			if subErr != io.EOF {
+				t.Fatalf("read message failed, err:%v", subErr)
+			}
+			return messages
+		}
+		message, subErr := packet.UnPack(header)
+		if subErr != nil {
+			t.Fatalf("unpack message failed, err:%v", subErr)
+		}
+		data := make([]byte, message.GetLen())
+		if _, subErr = io.ReadFull(conn, data); subErr != nil {
+			t.Fatalf("read message data failed, err:%v", subErr)
+		}
+		message.SetData(data)
+		messages = append(messages, message)
+	}
+}
+
+//test graceful shutdown drain connects and leak no goroutine
+func TestGracefulShutdown(t *testing.T) {
+	goAwayMsgId := uint32(9)
+	baseGoroutines := stableGoroutines()
+	connChan := make(chan iface.IConnect, 1)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Buckets: 2,
+		GoAwayMsgId: goAwayMsgId,
+	})
+	router := &slowRouter{
+		started: make(chan bool, 1),
+	}
+	server.AddRouter(5, router)
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	group, subErr := server.CreateGroup(1,
+		func(int64, iface.IConnect, iface.IRequest) error {
+			return nil
+		})
+	if subErr != nil {
+		t.Fatalf("create group failed, err:%v", subErr)
+	}
+
+	//raw client joined group
+	conn, subErr := net.Dial("tcp", serverAddr(server))
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	select {
+	case connect := <-connChan:
+		group.Join(connect)
+	case <-time.After(time.Second):
+		t.Fatalf("wait connect timeout")
+	}
+	group.SendMessage(1, []byte("group"))
+
+	//in-flight slow request
+	message := face.NewMessage()
+	message.SetId(5)
+	message.SetData([]byte("slow"))
+	byteData, _ := server.GetPacket().Pack(message)
+	conn.Write(byteData)
+	select {
+	case <-router.started:
+	case <-time.After(time.Second):
+		t.Fatalf("wait slow request timeout")
+	}
+
+	//shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5)
+	defer cancel()
+	if subErr = server.Shutdown(ctx); subErr != nil {
+		t.Fatalf("shutdown failed, err:%v", subErr)
+	}
+
+	//group message, going away and in-flight reply received before closed
+	idMap := map[uint32]bool{}
+	for _, v := range readAllMessages(t, conn) {
+		idMap[v.GetId()] = true
+		if v.GetId() == goAwayMsgId &&
+			!bytes.Equal(v.GetData(), []byte(define.ServerGoingAway)) {
+			t.Fatalf("unexpected going away data:%v", string(v.GetData()))
+		}
+	}
+	if len(idMap) != 3 || !idMap[1] || !idMap[goAwayMsgId] || !idMap[5] {
+		t.Fatalf("unexpected messages before closed:%v", idMap)
+	}
+
+	//new connect refused
+	if newConn, subErr := net.Dial("tcp", serverAddr(server)); subErr == nil {
+		newConn.Close()
+		t.Fatalf("new connect should be refused after shutdown")
+	}
+
+	//all goroutines of server quit
+	for i := 0; i < 20 && runtime.NumGoroutine() > baseGoroutines; i++ {
+		time.Sleep(time.Millisecond * 100)
+	}
+	if num := runtime.NumGoroutine(); num > baseGoroutines {
+		buff := make([]byte, 1 << 20)
+		n := runtime.Stack(buff, true)
+		t.Fatalf("goroutine leaked, base:%v, now:%v\n%s", baseGoroutines, num, buff[:n])
+	}
+}
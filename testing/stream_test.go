@@ -0,0 +1,130 @@
+package testing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//test streams over window, blocked stream not block others
+func TestStreamServe(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		Secure: true,
+	})
+	server.AddRouter(2, &replyRouter{})
+	connChan := make(chan iface.IConnect, 1)
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	holdChan := make(chan struct{})
+	resetChan := make(chan error, 1)
+	server.SetStream(func(conn iface.IConnect, stream iface.IStream) {
+		defer stream.Close()
+		head := make([]byte, 1)
+		if _, subErr := io.ReadFull(stream, head); subErr != nil {
+			return
+		}
+		switch head[0] {
+		case 'e':
+			//echo until closed
+			io.Copy(stream, stream)
+		case 'h':
+			//hold without read
+			<-holdChan
+		case 'r':
+			_, subErr := io.Copy(io.Discard, stream)
+			resetChan <- subErr
+		}
+	})
+
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		Secure: true,
+	})
+	client.SetCBForStream(func(stream iface.IStream) {
+		defer stream.Close()
+		io.Copy(stream, stream)
+	})
+	if subErr := client.Connect(context.Background()); subErr != nil {
+		t.Fatalf("connect failed, err:%v", subErr)
+	}
+	defer client.Close()
+	conn := <-connChan
+
+	//echo data over window
+	data := make([]byte, define.StreamWindowSize * 3)
+	rand.Read(data)
+	stream, subErr := client.OpenStream()
+	if subErr != nil {
+		t.Fatalf("open stream failed, err:%v", subErr)
+	}
+	go func() {
+		stream.Write([]byte("e"))
+		stream.Write(data)
+		stream.CloseWrite()
+	}()
+	stream.SetReadDeadline(time.Now().Add(time.Second * 5))
+	echo, subErr := io.ReadAll(stream)
+	if subErr != nil || !bytes.Equal(echo, data) {
+		t.Fatalf("echo failed, size:%v, err:%v", len(echo), subErr)
+	}
+	stream.Close()
+
+	//held stream blocked by window, call not blocked
+	held, _ := client.OpenStream()
+	held.Write([]byte("h"))
+	held.SetWriteDeadline(time.Now().Add(time.Millisecond * 500))
+	written, subErr := held.Write(data)
+	if !errors.Is(subErr, os.ErrDeadlineExceeded) || written != define.StreamWindowSize - 1 {
+		t.Fatalf("unexpected held write:%v, err:%v", written, subErr)
+	}
+	message, subErr := client.Call(context.Background(), 2, []byte("x"))
+	if subErr != nil || string(message.GetData()) != "x" {
+		t.Fatalf("call failed, err:%v", subErr)
+	}
+	close(holdChan)
+
+	//reset by client
+	reset, _ := client.OpenStream()
+	reset.Write([]byte("r"))
+	reset.Reset()
+	select {
+	case subErr = <-resetChan:
+		if !errors.Is(subErr, face.ErrStreamReset) {
+			t.Fatalf("unexpected reset err:%v", subErr)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait reset timeout")
+	}
+
+	//opened by server
+	stream, subErr = conn.OpenStream()
+	if subErr != nil {
+		t.Fatalf("open stream of server failed, err:%v", subErr)
+	}
+	stream.Write([]byte("server"))
+	stream.CloseWrite()
+	stream.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if echo, subErr = io.ReadAll(stream); subErr != nil || string(echo) != "server" {
+		t.Fatalf("echo of server failed, data:%v, err:%v", string(echo), subErr)
+	}
+
+	//aborted by connect closed
+	stream, _ = client.OpenStream()
+	client.Close()
+	if _, subErr = stream.Read(make([]byte, 1)); !errors.Is(subErr, face.ErrConnClosed) {
+		t.Fatalf("unexpected closed err:%v", subErr)
+	}
+}
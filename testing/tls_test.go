@@ -0,0 +1,150 @@
+package testing
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//identity router, reply peer identity
+type identityRouter struct {
+	face.BaseRouter
+}
+
+func (*identityRouter) Handle(req iface.IRequest) {
+	conn := req.GetConnect()
+	conn.SendMessage(req.GetMessage().GetId(), []byte(conn.GetPeerIdentity()))
+}
+
+//test cert authority
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+//gen self-signed ca
+func genTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("gen ca key failed, err:%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{CommonName: "cree test ca"},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create ca cert failed, err:%v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &testCA{cert: cert, key: key, pool: pool}
+}
+
+//issue cert signed by ca
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("gen key failed, err:%v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject: pkix.Name{CommonName: commonName},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses: []net.IP{net.ParseIP(host)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("create cert failed, err:%v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+//test mutual tls and peer identity
+func TestTLSPeerIdentity(t *testing.T) {
+	ca := genTestCA(t)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		TLS: &define.TLSConf{
+			Certificates: []tls.Certificate{ca.issue(t, "server", 2)},
+			CAPool: ca.pool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+			MinVersion: tls.VersionTLS13,
+		},
+	})
+	server.AddRouter(2, &identityRouter{})
+
+	//init tls client
+	readChan := make(chan iface.IMessage, 1)
+	client := cree.NewClient(&cree.ClientConf{
+		Host: host,
+		Port: serverPort(server),
+		TLS: &define.TLSConf{
+			Certificates: []tls.Certificate{ca.issue(t, "client-001", 3)},
+			CAPool: ca.pool,
+		},
+	})
+	client.SetCBForRead(func(msg iface.IMessage) error {
+		readChan <- msg
+		return nil
+	})
+	if subErr := client.ConnServer(); subErr != nil {
+		t.Fatalf("connect tls server failed, err:%v", subErr)
+	}
+	defer client.Close()
+
+	//send and wait identity
+	client.SendPacket(2, []byte("who"))
+	select {
+	case msg := <-readChan:
+		if string(msg.GetData()) != "client-001" {
+			t.Fatalf("unexpected peer identity:%v", string(msg.GetData()))
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("wait identity timeout")
+	}
+
+	//client without certificate should be refused
+	conn, subErr := tls.Dial("tcp", serverAddr(server), &tls.Config{
+		RootCAs: ca.pool,
+	})
+	if subErr == nil {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, subErr = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	if subErr == nil {
+		t.Fatalf("client without certificate should be refused")
+	}
+
+	//client below min version should be refused
+	_, subErr = tls.Dial("tcp", serverAddr(server), &tls.Config{
+		RootCAs: ca.pool,
+		MaxVersion: tls.VersionTLS12,
+	})
+	if subErr == nil {
+		t.Fatalf("client below min version should be refused")
+	}
+}
@@ -0,0 +1,113 @@
+package testing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+type sumReq struct {
+	Values []int `json:"values"`
+}
+
+type sumResp struct {
+	Sum int `json:"sum"`
+}
+
+//sum values, error for empty values
+func sumHandle(ctx context.Context, conn iface.IConnect, req *sumReq) (*sumResp, error) {
+	if len(req.Values) <= 0 {
+		return nil, errors.New("empty values")
+	}
+	resp := &sumResp{}
+	for _, v := range req.Values {
+		resp.Sum += v
+	}
+	if resp.Sum < 0 {
+		return nil, face.NewFrameError(100, "negative sum")
+	}
+	return resp, nil
+}
+
+//test built-in codecs
+func TestCodec(t *testing.T) {
+	for _, name := range []string{define.CodecJson, define.CodecGob} {
+		codec, _ := face.CreateCodec(name)
+		data, subErr := codec.Marshal(&sumReq{Values: []int{1, 2}})
+		req := &sumReq{}
+		if subErr != nil || codec.Unmarshal(data, req) != nil || len(req.Values) != 2 {
+			t.Fatalf("codec %v failed, err:%v", name, subErr)
+		}
+	}
+
+	//raw codec copy data
+	codec, _ := face.CreateCodec(define.CodecRaw)
+	data, _ := codec.Marshal("raw")
+	var value []byte
+	if subErr := codec.Unmarshal(data, &value); subErr != nil || string(value) != "raw" {
+		t.Fatalf("raw codec failed, err:%v", subErr)
+	}
+	data[0] = 'x'
+	if string(value) != "raw" {
+		t.Fatalf("raw data should be copied")
+	}
+	if _, subErr := codec.Marshal(1); !errors.Is(subErr, face.ErrCodecType) {
+		t.Fatalf("unexpected type err:%v", subErr)
+	}
+	if _, subErr := face.CreateCodec("none"); subErr == nil {
+		t.Fatalf("create unknown codec should be failed")
+	}
+}
+
+//test typed handler and call, errors mapped to error frame
+func TestTypedCall(t *testing.T) {
+	for _, name := range []string{define.CodecJson, define.CodecGob} {
+		server := startServer(t, &cree.ServerConf{
+			Host: host,
+			Codec: name,
+		})
+		if subErr := cree.Handle(server, 2, sumHandle); subErr != nil {
+			t.Fatalf("register typed handler failed, err:%v", subErr)
+		}
+		if cree.Handle(server, 2, sumHandle) == nil || cree.Handle(server, 0, sumHandle) == nil {
+			t.Fatalf("register exists or invalid message id should be failed")
+		}
+		client := cree.NewClient(&cree.ClientConf{
+			Host: host,
+			Port: serverPort(server),
+			Codec: name,
+		})
+		if subErr := client.Connect(context.Background()); subErr != nil {
+			t.Fatalf("connect failed, err:%v", subErr)
+		}
+		defer client.Close()
+
+		//typed reply
+		resp, subErr := cree.Call[sumReq, sumResp](context.Background(), client, 2, &sumReq{Values: []int{1, 2, 3}})
+		if subErr != nil || resp.Sum != 6 {
+			t.Fatalf("typed call of %v failed, resp:%v, err:%v", name, resp, subErr)
+		}
+
+		//typed error kept, others as internal error
+		frameErr := &face.FrameError{}
+		_, subErr = cree.Call[sumReq, sumResp](context.Background(), client, 2, &sumReq{Values: []int{-1}})
+		if !errors.As(subErr, &frameErr) || frameErr.Code != 100 || frameErr.Message != "negative sum" {
+			t.Fatalf("unexpected typed err:%v", subErr)
+		}
+		_, subErr = cree.Call[sumReq, sumResp](context.Background(), client, 2, nil)
+		if !errors.As(subErr, &frameErr) || frameErr.Code != define.FrameErrInternal {
+			t.Fatalf("unexpected internal err:%v", subErr)
+		}
+
+		//bad data refused
+		if _, subErr = client.Call(context.Background(), 2, []byte("{bad")); !errors.As(subErr, &frameErr) ||
+			frameErr.Code != define.FrameErrBadData {
+			t.Fatalf("unexpected bad data err:%v", subErr)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package testing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+//env of upgrade child process
+const upgradeChildEnv = "CREE_TEST_UPGRADE_CHILD"
+
+//run as upgrade child process if env set, tests not run
+func TestMain(m *testing.M) {
+	if os.Getenv(upgradeChildEnv) != "" {
+		os.Exit(runUpgradeChild())
+	}
+	os.Exit(m.Run())
+}
+
+//reply pid, connect id, tag and property
+type upgradeRouter struct {
+	face.BaseRouter
+}
+
+func (r *upgradeRouter) Handle(req iface.IRequest) {
+	conn := req.GetConnect()
+	level, _ := conn.GetProperty("level")
+	reply := fmt.Sprintf("%v,%v,%v,%v,%v", os.Getpid(), conn.GetConnId(),
+		conn.GetTags()["vip"], level, conn.GetGroupId())
+	conn.SendMessage(req.GetMessage().GetId(), []byte(reply))
+}
+
+//stop server in background
+type stopRouter struct {
+	face.BaseRouter
+	server *cree.Server
+}
+
+func (r *stopRouter) Handle(req iface.IRequest) {
+	go r.server.Stop()
+}
+
+//new server of parent or child process
+//listener of child inherited from parent by name
+func newUpgradeServer() (*cree.Server, error) {
+	server := newTestServer(&cree.ServerConf{
+		Host: host,
+		Buckets: 2,
+	})
+	server.AddRouter(6, &upgradeRouter{})
+	_, err := server.CreateGroup(1,
+		func(int64, iface.IConnect, iface.IRequest) error {
+			return nil
+		})
+	if err != nil {
+		server.Stop()
+		return nil, err
+	}
+	return server, nil
+}
+
+//start server of parent process, stopped after test
+func startUpgradeServer(t *testing.T) *cree.Server {
+	server, subErr := newUpgradeServer()
+	if subErr != nil {
+		t.Fatalf("create group failed, err:%v", subErr)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+//run server of child process until stopped by request
+//return exit code of child process
+func runUpgradeChild() int {
+	server, err := newUpgradeServer()
+	if err != nil {
+		log.Printf("upgrade child, create group failed, err:%v", err)
+		return 1
+	}
+	server.AddRouter(7, &stopRouter{server: server})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 5)
+	defer cancel()
+	if err = server.Resume(ctx); err != nil {
+		log.Printf("upgrade child, resume failed, err:%v", err)
+		server.Stop()
+		return 1
+	}
+	doneChan := make(chan bool)
+	go func() {
+		server.Start()
+		close(doneChan)
+	}()
+	select {
+	case <-doneChan:
+		return 0
+	case <-time.After(time.Second * 10):
+		log.Printf("upgrade child, wait stopped timeout")
+		server.Stop()
+		return 1
+	}
+}
+
+//read one reply and split fields
+func readUpgradeReply(t *testing.T, conn net.Conn) []string {
+	packet := face.NewPacket()
+	header := make([]byte, packet.GetHeadLen())
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, subErr := io.ReadFull(conn, header); subErr != nil {
+		t.Fatalf("read reply failed, err:%v", subErr)
+	}
+	message, subErr := packet.UnPack(header)
+	if subErr != nil {
+		t.Fatalf("unpack reply failed, err:%v", subErr)
+	}
+	data := make([]byte, message.GetLen())
+	if _, subErr = io.ReadFull(conn, data); subErr != nil {
+		t.Fatalf("read reply data failed, err:%v", subErr)
+	}
+	return strings.Split(string(data), ",")
+}
+
+//pack request of message id
+func packUpgradeRequest(server *cree.Server, msgId uint32) []byte {
+	message := face.NewMessage()
+	message.SetId(msgId)
+	message.SetData([]byte("who"))
+	byteData, _ := server.GetPacket().Pack(message)
+	return byteData
+}
+
+//test hot restart with listener and connect handoff
+func TestUpgrade(t *testing.T) {
+	connChan := make(chan iface.IConnect, 1)
+	server := startUpgradeServer(t)
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+	group, _ := server.GetGroup(1)
+	address := serverAddr(server)
+
+	//connect with tag, property and group
+	conn, subErr := net.Dial("tcp", address)
+	if subErr != nil {
+		t.Fatalf("dial failed, err:%v", subErr)
+	}
+	defer conn.Close()
+	select {
+	case connect := <-connChan:
+		connect.SetTag("vip")
+		connect.SetProperty("level", 3)
+		group.Join(connect)
+	case <-time.After(time.Second):
+		t.Fatalf("wait connect timeout")
+	}
+	request := packUpgradeRequest(server, 6)
+	conn.Write(request)
+	fields := readUpgradeReply(t, conn)
+	parentPid, connId := fields[0], fields[1]
+	if parentPid != strconv.Itoa(os.Getpid()) {
+		t.Fatalf("unexpected parent reply:%v", fields)
+	}
+
+	//half request read by parent
+	conn.Write(request[:3])
+	time.Sleep(time.Millisecond * 50)
+
+	//upgrade to child process
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second * 10)
+	defer cancel()
+	subErr = server.Upgrade(ctx, &cree.UpgradeConf{
+		Path: os.Args[0],
+		Args: []string{},
+		Env: []string{upgradeChildEnv + "=1"},
+		HandoffConnects: true,
+	})
+	if subErr != nil {
+		t.Fatalf("upgrade failed, err:%v", subErr)
+	}
+
+	//rest of request served by child with same connect meta
+	conn.Write(request[3:])
+	fields = readUpgradeReply(t, conn)
+	expected := []string{connId, "true", "3", "1"}
+	if fields[0] == parentPid || strings.Join(fields[1:], ",") != strings.Join(expected, ",") {
+		t.Fatalf("unexpected child reply:%v", fields)
+	}
+	childPid := fields[0]
+
+	//new connect accepted by child
+	newConn, subErr := net.Dial("tcp", address)
+	if subErr != nil {
+		t.Fatalf("dial child failed, err:%v", subErr)
+	}
+	defer newConn.Close()
+	newConn.Write(request)
+	fields = readUpgradeReply(t, newConn)
+	if fields[0] != childPid || fields[1] == connId {
+		t.Fatalf("unexpected reply of new connect:%v", fields)
+	}
+
+	//stop child
+	conn.Write(packUpgradeRequest(server, 7))
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, subErr = conn.Read(make([]byte, 1)); subErr != io.EOF {
+		t.Fatalf("connect should be closed by child, err:%v", subErr)
+	}
+}
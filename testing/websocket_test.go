@@ -0,0 +1,105 @@
+package testing
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andyzhou/cree"
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/iface"
+)
+
+//test websocket and tcp clients share routers and broadcast
+func TestWebSocketShareServer(t *testing.T) {
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		WsAddress: "ws://127.0.0.1:0/cree",
+		Buckets: 1,
+	})
+
+	//init tcp and websocket clients
+	readChan := make(chan string, 8)
+	confs := []*cree.ClientConf{
+		{Host: host, Port: serverPort(server)},
+		{Address: "ws://" + listenerAddr(server, define.ListenerWebSocket) + "/cree"},
+	}
+	clients := make([]*cree.Client, 0)
+	for _, conf := range confs {
+		client := cree.NewClient(conf)
+		client.SetCBForRead(func(msg iface.IMessage) error {
+			readChan <- string(msg.GetData())
+			return nil
+		})
+		if subErr := client.ConnServer(); subErr != nil {
+			t.Fatalf("connect server failed, err:%v", subErr)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+
+	//router echo for websocket client
+	for _, payload := range []string{"from ws", strings.Repeat("x", 1500)} {
+		clients[1].SendPacket(1, []byte(payload))
+		select {
+		case data := <-readChan:
+			if data != payload {
+				t.Fatalf("unexpected echo data len:%v", len(data))
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("wait websocket echo timeout")
+		}
+	}
+
+	//bad path should be refused
+	badClient := cree.NewClient(&cree.ClientConf{
+		Address: "ws://" + listenerAddr(server, define.ListenerWebSocket) + "/other",
+	})
+	if subErr := badClient.ConnServer(); subErr == nil {
+		t.Fatalf("websocket with bad path should be refused")
+	}
+}
+
+//test websocket connect in same bucket as tcp
+func TestWebSocketBroadcast(t *testing.T) {
+	connChan := make(chan iface.IConnect, 2)
+	server := startServer(t, &cree.ServerConf{
+		Host: host,
+		WsAddress: "ws://127.0.0.1:0/",
+		Buckets: 1,
+	})
+	server.SetConnected(func(conn iface.IConnect) {
+		connChan <- conn
+	})
+
+	readChan := make(chan string, 8)
+	wsAddress := "ws://" + listenerAddr(server, define.ListenerWebSocket) + "/"
+	for _, conf := range []*cree.ClientConf{{Host: host, Port: serverPort(server)}, {Address: wsAddress}} {
+		client := cree.NewClient(conf)
+		client.SetCBForRead(func(msg iface.IMessage) error {
+			readChan <- string(msg.GetData())
+			return nil
+		})
+		if subErr := client.ConnServer(); subErr != nil {
+			t.Fatalf("connect server failed, err:%v", subErr)
+		}
+		defer client.Close()
+		<-connChan
+	}
+
+	//broadcast by bucket reach both
+	server.SendMessage(&define.SendMsgReq{
+		MsgId: 1,
+		Data: []byte("all"),
+	})
+	for i := 0; i < 2; i++ {
+		select {
+		case data := <-readChan:
+			if data != "all" {
+				t.Fatalf("unexpected broadcast data:%v", data)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("wait broadcast timeout, received:%v", i)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package cree
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"os"
+
+	"github.com/andyzhou/cree/define"
+)
+
+/*
+ * tls config for server and client
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ */
+
+//build tls config
+func buildTLSConfig(conf *define.TLSConf, isServer bool) (*tls.Config, error) {
+	var (
+		certificates []tls.Certificate
+		caPool *x509.CertPool
+	)
+	//check
+	if conf == nil {
+		return nil, errors.New("invalid parameter")
+	}
+
+	//load certificates
+	certificates = conf.Certificates
+	if conf.CertFile != "" && conf.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.CertFile, conf.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		certificates = []tls.Certificate{cert}
+	}
+	if isServer && len(certificates) <= 0 {
+		return nil, errors.New("no server certificate")
+	}
+
+	//load ca pool
+	caPool = conf.CAPool
+	if conf.CAFile != "" {
+		pemData, err := os.ReadFile(conf.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pemData) {
+			return nil, errors.New("invalid ca file")
+		}
+	}
+
+	//init tls config
+	minVersion := conf.MinVersion
+	if minVersion <= 0 {
+		minVersion = tls.VersionTLS12
+	}
+	tlsConf := &tls.Config{
+		Certificates: certificates,
+		MinVersion: minVersion,
+	}
+	if isServer {
+		tlsConf.ClientCAs = caPool
+		tlsConf.ClientAuth = conf.ClientAuth
+	}else{
+		tlsConf.RootCAs = caPool
+		tlsConf.ServerName = conf.ServerName
+		tlsConf.InsecureSkipVerify = conf.SkipVerify
+	}
+	return tlsConf, nil
+}
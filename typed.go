@@ -0,0 +1,108 @@
+package cree
+
+import (
+	"context"
+	"errors"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * face for typed router and call
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - data decoded and encoded by codec of server or client, default json
+ * - empty data decoded as zero value, nil reply sent as empty data
+ * - returned error sent as error frame, *face.FrameError kept as it is
+ */
+
+//router of typed handler
+type typedRouter[Req, Resp any] struct {
+	face.BaseRouter
+	codec  iface.ICodec
+	handle func(context.Context, iface.IConnect, *Req) (*Resp, error)
+}
+
+//register typed handler of message id
+//error if message id invalid or router of it exists, old router kept
+//ctx is background, never canceled even if connect closed
+func Handle[Req, Resp any](
+	s *Server,
+	messageId uint32,
+	handle func(ctx context.Context, conn iface.IConnect, req *Req) (*Resp, error)) error {
+	//check
+	if s == nil || handle == nil {
+		return errors.New("invalid parameter")
+	}
+	router := &typedRouter[Req, Resp]{
+		codec: s.codec,
+		handle: handle,
+	}
+	return s.handler.AddRouter(messageId, router)
+}
+
+//send typed request and wait typed response
+//return error of Client.Call, or decode error of response
+func Call[Req, Resp any](
+	ctx context.Context,
+	c *Client,
+	messageId uint32,
+	req *Req) (*Resp, error) {
+	//check
+	if c == nil {
+		return nil, errors.New("invalid parameter")
+	}
+	if req == nil {
+		req = new(Req)
+	}
+
+	//encode request and call
+	data, err := c.codec.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	message, err := c.Call(ctx, messageId, data)
+	if err != nil {
+		return nil, err
+	}
+
+	//decode response
+	resp := new(Resp)
+	if len(message.GetData()) > 0 {
+		if err = c.codec.Unmarshal(message.GetData(), resp); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+//decode request, call handler and reply
+func (r *typedRouter[Req, Resp]) Handle(req iface.IRequest) {
+	//decode request
+	in := new(Req)
+	if data := req.GetMessage().GetData(); len(data) > 0 {
+		if err := r.codec.Unmarshal(data, in); err != nil {
+			req.ReplyError(face.NewFrameError(define.FrameErrBadData, err.Error()))
+			return
+		}
+	}
+
+	//call handler
+	out, err := r.handle(context.Background(), req.GetConnect(), in)
+	if err != nil {
+		req.ReplyError(err)
+		return
+	}
+
+	//encode reply
+	data := []byte{}
+	if out != nil {
+		if data, err = r.codec.Marshal(out); err != nil {
+			req.ReplyError(err)
+			return
+		}
+	}
+	req.Reply(data)
+}
@@ -0,0 +1,530 @@
+package cree
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/andyzhou/cree/define"
+	"github.com/andyzhou/cree/face"
+	"github.com/andyzhou/cree/iface"
+)
+
+/*
+ * hot restart for server
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - parent pass listeners to exec'd child over unix socket
+ * - child call resume after routers setup, then parent hand off
+ *   live tcp or unix connects, tls and websocket connects drained
+ * - parent shutdown once child ack, process exited by caller
+ */
+
+//hot restart config
+type UpgradeConf struct {
+	Path            string   //executable of child process, default current
+	Args            []string //args of child process, default current
+	Env             []string //extra env of child process
+	HandoffConnects bool     //hand off live connects, or drain and close them
+}
+
+//listener meta of handoff
+type upgradeListener struct {
+	Name string
+	Fds  int
+}
+
+//connect meta of handoff
+//properties passed as json, value type may be changed
+type upgradeConnect struct {
+	ConnId     int64
+	Listener   string
+	GroupId    int64
+	Tags       []string
+	Properties map[string]interface{}
+	Pending    []byte //read but not framed data
+	Compress   string //negotiated compress codec
+	Secure     *face.SecureState //keys and counters of secure channel
+	Capabilities *define.Capabilities //negotiated by hello
+	ProxySrc   string
+	ProxyDst   string
+	ProxyTLVs  []define.ProxyTLV
+}
+
+//connects handoff of parent process
+type connHandoff struct {
+	sock     *net.UnixConn
+	enabled  bool
+	connects []*face.Connect
+	fds      []int
+	metas    []*upgradeConnect
+}
+
+//upgrade to new process without downtime
+//listeners passed to child process at once,
+//connects handed off after child resumed, then server shutdown.
+//if failed before child resumed, child killed and server keep running.
+//process not exited, caller should exit after nil returned, like os.Exit(0).
+func (s *Server) Upgrade(ctx context.Context, conf *UpgradeConf) error {
+	var (
+		err error
+	)
+	//check
+	if s.isQuit() {
+		return errors.New("cree.server, server is quit")
+	}
+	if conf == nil {
+		conf = &UpgradeConf{}
+	}
+	path := conf.Path
+	if path == "" {
+		if path, err = os.Executable(); err != nil {
+			return err
+		}
+	}
+	args := conf.Args
+	if args == nil {
+		args = os.Args[1:]
+	}
+
+	//init handoff socket
+	sock, childFile, err := newUpgradePair()
+	if err != nil {
+		return err
+	}
+	defer sock.Close()
+	stopWatch := watchUpgradeSock(ctx, sock)
+	defer stopWatch()
+
+	//start child process, handoff socket as fd 3
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), conf.Env...)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", define.UpgradeEnvName, 3))
+	cmd.ExtraFiles = []*os.File{childFile}
+	err = cmd.Start()
+	childFile.Close()
+	if err != nil {
+		return err
+	}
+	go cmd.Wait()
+
+	//send listeners and wait child ready
+	if err = s.sendListeners(sock); err == nil {
+		err = waitUpgradeFrame(sock, define.UpgradeFrameReady)
+	}
+	if err == nil && !s.beginQuit() {
+		err = errors.New("cree.server, server is quit")
+	}
+	if err != nil {
+		log.Printf("cree.server, upgrade failed, err:%v", err.Error())
+		cmd.Process.Kill()
+		return err
+	}
+
+	//shutdown with connects handoff
+	s.keepUnixSocket()
+	handoff := &connHandoff{
+		sock: sock,
+		enabled: conf.HandoffConnects,
+	}
+	return s.shutdown(ctx, handoff)
+}
+
+//resume connects handed off by parent process
+//should be called after routers, hooks and groups setup,
+//restored connect joined group with same id if exists.
+//nothing to do if not started by upgrade.
+func (s *Server) Resume(ctx context.Context) error {
+	//get handoff socket
+	s.listenerLocker.Lock()
+	sock := s.upgradeSock
+	inheritMap := s.inheritMap
+	s.upgradeSock = nil
+	s.inheritMap = nil
+	s.listenerLocker.Unlock()
+	if sock == nil {
+		return nil
+	}
+	defer sock.Close()
+	stopWatch := watchUpgradeSock(ctx, sock)
+	defer stopWatch()
+
+	//close listeners not used
+	for _, listeners := range inheritMap {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+	}
+
+	//notify parent and receive connects until done
+	if err := writeUpgradeFrame(sock, define.UpgradeFrameReady, nil, nil); err != nil {
+		return err
+	}
+	for {
+		kind, fds, body, err := readUpgradeFrame(sock)
+		if err != nil {
+			return err
+		}
+		if kind == define.UpgradeFrameDone {
+			break
+		}
+		var metas []*upgradeConnect
+		if kind == define.UpgradeFrameConnects {
+			err = json.Unmarshal(body, &metas)
+		}
+		if kind != define.UpgradeFrameConnects || err != nil || len(metas) != len(fds) {
+			closeRawFds(fds)
+			return errors.New("cree.server, invalid upgrade connects frame")
+		}
+		for i, meta := range metas {
+			s.restoreConn(fds[i], meta)
+		}
+	}
+	return writeUpgradeFrame(sock, define.UpgradeFrameAck, nil, nil)
+}
+
+///////////////
+//private func
+///////////////
+
+//inherit listeners of parent process
+//called on init, if failed listen as normal
+func (s *Server) inheritListeners() {
+	//check env
+	fdVal := os.Getenv(define.UpgradeEnvName)
+	if fdVal == "" {
+		return
+	}
+	os.Unsetenv(define.UpgradeEnvName)
+	fd, err := strconv.Atoi(fdVal)
+	if err != nil {
+		log.Printf("cree.server, invalid upgrade fd %v", fdVal)
+		return
+	}
+	sock, err := newUpgradeConn(os.NewFile(uintptr(fd), "cree-upgrade"))
+	if err != nil {
+		log.Printf("cree.server, init upgrade socket failed, err:%v", err.Error())
+		return
+	}
+
+	//receive listeners
+	sock.SetReadDeadline(time.Now().Add(define.UpgradeTimeOut * time.Second))
+	kind, fds, body, err := readUpgradeFrame(sock)
+	sock.SetReadDeadline(time.Time{})
+	var metas []*upgradeListener
+	if err == nil && kind == define.UpgradeFrameListeners {
+		err = json.Unmarshal(body, &metas)
+	}
+	if err != nil || kind != define.UpgradeFrameListeners {
+		log.Printf("cree.server, receive upgrade listeners failed, err:%v", err)
+		closeRawFds(fds)
+		sock.Close()
+		return
+	}
+
+	//rebuild listeners by name
+	inheritMap := map[string][]net.Listener{}
+	for _, meta := range metas {
+		for i := 0; i < meta.Fds && len(fds) > 0; i++ {
+			file := os.NewFile(uintptr(fds[0]), meta.Name)
+			fds = fds[1:]
+			listener, subErr := net.FileListener(file)
+			file.Close()
+			if subErr != nil {
+				log.Printf("cree.server, rebuild listener %v failed, err:%v", meta.Name, subErr.Error())
+				continue
+			}
+			inheritMap[meta.Name] = append(inheritMap[meta.Name], listener)
+		}
+	}
+	closeRawFds(fds)
+	s.upgradeSock = sock
+	s.inheritMap = inheritMap
+}
+
+//send listeners to child process
+func (s *Server) sendListeners(sock *net.UnixConn) error {
+	var (
+		metas []*upgradeListener
+		fds []int
+	)
+	s.listenerLocker.RLock()
+	defer s.listenerLocker.RUnlock()
+	for name, li := range s.listenerMap {
+		meta := &upgradeListener{
+			Name: name,
+		}
+		for _, listener := range li.raws {
+			sc, ok := listener.(syscall.Conn)
+			if !ok {
+				log.Printf("cree.server, listener %v not support handoff", name)
+				break
+			}
+			fd, err := getRawFd(sc)
+			if err != nil {
+				return err
+			}
+			fds = append(fds, fd)
+			meta.Fds++
+		}
+		metas = append(metas, meta)
+	}
+	return writeUpgradeFrame(sock, define.UpgradeFrameListeners, fds, metas)
+}
+
+//keep unix socket file when listener closed, used by child process
+func (s *Server) keepUnixSocket() {
+	s.listenerLocker.RLock()
+	defer s.listenerLocker.RUnlock()
+	for _, li := range s.listenerMap {
+		for _, listener := range li.raws {
+			if v, ok := listener.(*net.UnixListener); ok {
+				v.SetUnlinkOnClose(false)
+			}
+		}
+	}
+}
+
+//restore connect handed off by parent process
+func (s *Server) restoreConn(fd int, meta *upgradeConnect) {
+	//rebuild connect
+	file := os.NewFile(uintptr(fd), "")
+	conn, err := net.FileConn(file)
+	file.Close()
+	if err != nil {
+		log.Printf("cree.server, restore connect %v failed, err:%v", meta.ConnId, err.Error())
+		return
+	}
+	if meta.ProxySrc != "" {
+		conn = face.RestoreProxyConn(conn, resolveTCPAddr(meta.ProxySrc),
+			resolveTCPAddr(meta.ProxyDst), meta.ProxyTLVs)
+	}
+
+	//keep connect count and id
+	li := s.getListener(meta.Listener)
+	atomic.AddInt32(&s.connects, 1)
+	if li != nil {
+		atomic.AddInt32(&li.connects, 1)
+	}
+	for {
+		connId := atomic.LoadInt64(&s.connId)
+		if meta.ConnId <= connId ||
+			atomic.CompareAndSwapInt64(&s.connId, connId, meta.ConnId) {
+			break
+		}
+	}
+
+	//init connect with tags, properties and group
+	connect := face.NewConnect(s, conn, meta.ConnId, s.handler)
+	connect.SetListener(meta.Listener)
+	connect.SetPending(meta.Pending)
+	if meta.Compress != "" || meta.Secure != nil {
+		packet, subErr := s.newConnPacket(connect.GetPacket(), meta.Compress, meta.Secure)
+		if subErr != nil {
+			log.Printf("cree.server, restore packet of connect %v failed, err:%v", meta.ConnId, subErr.Error())
+		}else{
+			connect.SetPacket(packet)
+		}
+	}
+	if meta.Capabilities != nil {
+		connect.SetCapabilities(meta.Capabilities)
+	}
+	if len(meta.Tags) > 0 {
+		connect.SetTag(meta.Tags...)
+	}
+	for k, v := range meta.Properties {
+		connect.SetProperty(k, v)
+	}
+	if meta.GroupId > 0 {
+		if group, _ := s.GetGroup(meta.GroupId); group != nil {
+			group.Join(connect)
+		}else{
+			log.Printf("cree.server, group %v of connect %v not exists", meta.GroupId, meta.ConnId)
+		}
+	}
+	s.serveConn(connect, li)
+}
+
+//get ids of connects not handed off, need going away message
+func (h *connHandoff) goAwayIds(buckets []iface.IBucket) []int64 {
+	var (
+		connIds []int64
+	)
+	for _, bucket := range buckets {
+		for _, conn := range bucket.GetConnects() {
+			if _, _, ok := handoffConn(conn); !ok {
+				connIds = append(connIds, conn.GetConnId())
+			}
+		}
+	}
+	return connIds
+}
+
+//collect connects and meta, after read stopped and before groups cleared
+func (h *connHandoff) collect(s *Server, buckets []iface.IBucket) {
+	if !h.enabled {
+		return
+	}
+	for _, bucket := range buckets {
+		for _, conn := range bucket.GetConnects() {
+			//get raw fd
+			connect, proxyConn, ok := handoffConn(conn)
+			if !ok {
+				continue
+			}
+			fd, err := getRawFd(connect.GetConn().(syscall.Conn))
+			if err != nil {
+				continue
+			}
+
+			//init meta
+			meta := &upgradeConnect{
+				ConnId: connect.GetConnId(),
+				Listener: connect.GetListener(),
+				GroupId: connect.GetGroupId(),
+				Pending: connect.TakePending(),
+				Capabilities: connect.GetCapabilities(),
+			}
+			if s.poller != nil {
+				meta.Pending = s.poller.TakePending(connect)
+			}
+			if packet, ok := connect.GetPacket().(*face.CompressPacket); ok {
+				meta.Compress = packet.GetName()
+			}
+			for tag := range connect.GetTags() {
+				meta.Tags = append(meta.Tags, tag)
+			}
+			properties := connect.GetProperties()
+			if _, err = json.Marshal(properties); err != nil {
+				log.Printf("cree.server, skip properties of connect %v, err:%v", meta.ConnId, err.Error())
+			}else if len(properties) > 0 {
+				meta.Properties = properties
+			}
+			if proxyConn != nil {
+				meta.ProxySrc = proxyConn.RemoteAddr().String()
+				meta.ProxyDst = proxyConn.LocalAddr().String()
+				meta.ProxyTLVs = proxyConn.TLVs()
+			}
+			h.connects = append(h.connects, connect)
+			h.fds = append(h.fds, fd)
+			h.metas = append(h.metas, meta)
+		}
+	}
+}
+
+//send connects to child process, detach them after child ack
+//send of connects closed first, keys not used after snapshot
+func (h *connHandoff) send(ctx context.Context, s *Server) error {
+	//close send and streams, wait packed data written
+	for _, connect := range h.connects {
+		connect.CloseSend(face.ErrConnHandedOff)
+	}
+	for _, connect := range h.connects {
+		if err := connect.Flush(ctx); err != nil {
+			return err
+		}
+	}
+
+	//keys and counters of secure channel, no more frames sealed
+	for i, connect := range h.connects {
+		if secure := face.GetSecurePacket(connect.GetPacket()); secure != nil {
+			h.metas[i].Secure = secure.GetState()
+		}
+	}
+
+	//send by batch
+	for i := 0; i < len(h.fds); i += define.UpgradeBatchSize {
+		end := i + define.UpgradeBatchSize
+		if end > len(h.fds) {
+			end = len(h.fds)
+		}
+		err := writeUpgradeFrame(h.sock, define.UpgradeFrameConnects,
+			h.fds[i:end], h.metas[i:end])
+		if err != nil {
+			return err
+		}
+	}
+	if err := writeUpgradeFrame(h.sock, define.UpgradeFrameDone, nil, nil); err != nil {
+		return err
+	}
+
+	//wait child ack
+	if err := waitUpgradeFrame(h.sock, define.UpgradeFrameAck); err != nil {
+		return err
+	}
+
+	//detach connects, served by child process
+	for _, connect := range h.connects {
+		connId := connect.GetConnId()
+		if bucket := s.getBucket(connId); bucket != nil {
+			bucket.DetachConnect(connId)
+		}
+	}
+	return nil
+}
+
+//get connect which can be handed off, like tcp or unix
+//proxy connect returned if wrapped
+func handoffConn(conn iface.IConnect) (*face.Connect, *face.ProxyConn, bool) {
+	connect, ok := conn.(*face.Connect)
+	if !ok {
+		return nil, nil, false
+	}
+	netConn := connect.GetConn()
+	proxyConn, _ := netConn.(*face.ProxyConn)
+	if proxyConn != nil {
+		netConn = proxyConn.Conn
+	}
+	switch netConn.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return connect, proxyConn, true
+	}
+	return nil, nil, false
+}
+
+//wait frame of kind from handoff socket
+func waitUpgradeFrame(sock *net.UnixConn, kind byte) error {
+	subKind, fds, _, err := readUpgradeFrame(sock)
+	closeRawFds(fds)
+	if err != nil {
+		return err
+	}
+	if subKind != kind {
+		return fmt.Errorf("cree.server, unexpected upgrade frame %v", subKind)
+	}
+	return nil
+}
+
+//interrupt handoff socket when ctx done
+func watchUpgradeSock(ctx context.Context, sock *net.UnixConn) func() {
+	stopChan := make(chan struct{})
+	go func() {
+		select {
+		case <- ctx.Done():
+			sock.SetDeadline(time.Now())
+		case <- stopChan:
+		}
+	}()
+	return func() {
+		close(stopChan)
+	}
+}
+
+//resolve tcp address, nil if failed
+func resolveTCPAddr(address string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		return nil
+	}
+	return addr
+}
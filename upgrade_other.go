@@ -0,0 +1,50 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package cree
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+)
+
+/*
+ * hot restart socket for other os
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - only supported on unix
+ */
+
+//inter error define
+var errUpgradeUnsupported = errors.New("cree.server, upgrade only supported on unix")
+
+//create handoff socket pair
+func newUpgradePair() (*net.UnixConn, *os.File, error) {
+	return nil, nil, errUpgradeUnsupported
+}
+
+//init handoff socket from file
+func newUpgradeConn(file *os.File) (*net.UnixConn, error) {
+	file.Close()
+	return nil, errUpgradeUnsupported
+}
+
+//get fd of listener or connect
+func getRawFd(conn syscall.Conn) (int, error) {
+	return 0, errUpgradeUnsupported
+}
+
+//write one frame with fds
+func writeUpgradeFrame(sock *net.UnixConn, kind byte, fds []int, data interface{}) error {
+	return errUpgradeUnsupported
+}
+
+//read one frame with fds
+func readUpgradeFrame(sock *net.UnixConn) (byte, []int, []byte, error) {
+	return 0, nil, nil, errUpgradeUnsupported
+}
+
+//close received fds
+func closeRawFds(fds []int) {
+}
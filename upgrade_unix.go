@@ -0,0 +1,166 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package cree
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/andyzhou/cree/define"
+)
+
+/*
+ * hot restart socket for unix
+ * @author <AndyZhou>
+ * @mail <diudiu8848@163.com>
+ * - fds passed with SCM_RIGHTS
+ * - frame: kind(1) + reserved(1) + fds(2) + body size(4) + json body
+ */
+
+//create handoff socket pair, file of child side passed to child process
+func newUpgradePair() (*net.UnixConn, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	parentFile := os.NewFile(uintptr(fds[0]), "cree-upgrade-parent")
+	childFile := os.NewFile(uintptr(fds[1]), "cree-upgrade-child")
+	sock, err := newUpgradeConn(parentFile)
+	if err != nil {
+		childFile.Close()
+		return nil, nil, err
+	}
+	return sock, childFile, nil
+}
+
+//init handoff socket from file, file closed after
+func newUpgradeConn(file *os.File) (*net.UnixConn, error) {
+	defer file.Close()
+	conn, err := net.FileConn(file)
+	if err != nil {
+		return nil, err
+	}
+	sock, ok := conn.(*net.UnixConn)
+	if !ok {
+		conn.Close()
+		return nil, errors.New("cree.server, upgrade fd is not unix socket")
+	}
+	return sock, nil
+}
+
+//get fd of listener or connect
+//fd only valid before it closed
+func getRawFd(conn syscall.Conn) (int, error) {
+	var (
+		fd int
+	)
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	err = rawConn.Control(func(sysFd uintptr) {
+		fd = int(sysFd)
+	})
+	return fd, err
+}
+
+//write one frame with fds
+func writeUpgradeFrame(sock *net.UnixConn, kind byte, fds []int, data interface{}) error {
+	var (
+		body []byte
+		err error
+	)
+	//check
+	if len(fds) > define.UpgradeBatchSize {
+		return errors.New("cree.server, too many fds of one frame")
+	}
+	if data != nil {
+		if body, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+
+	//init frame
+	frame := make([]byte, define.UpgradeFrameHeadSize + len(body))
+	frame[0] = kind
+	binary.BigEndian.PutUint16(frame[2:], uint16(len(fds)))
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(body)))
+	copy(frame[define.UpgradeFrameHeadSize:], body)
+
+	//fds sent with first part of frame
+	var oob []byte
+	if len(fds) > 0 {
+		oob = syscall.UnixRights(fds...)
+	}
+	n, _, err := sock.WriteMsgUnix(frame, oob, nil)
+	if err != nil {
+		return err
+	}
+	if n < len(frame) {
+		_, err = sock.Write(frame[n:])
+	}
+	return err
+}
+
+//read one frame with fds
+func readUpgradeFrame(sock *net.UnixConn) (byte, []int, []byte, error) {
+	var (
+		fds []int
+	)
+	//read header and fds
+	head := make([]byte, define.UpgradeFrameHeadSize)
+	oob := make([]byte, syscall.CmsgSpace(define.UpgradeBatchSize * 4))
+	n, oobn, _, _, err := sock.ReadMsgUnix(head, oob)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if oobn > 0 {
+		msgs, subErr := syscall.ParseSocketControlMessage(oob[:oobn])
+		if subErr != nil {
+			return 0, nil, nil, subErr
+		}
+		for _, msg := range msgs {
+			subFds, subErr := syscall.ParseUnixRights(&msg)
+			if subErr != nil {
+				closeRawFds(fds)
+				return 0, nil, nil, subErr
+			}
+			fds = append(fds, subFds...)
+		}
+	}
+	if n < len(head) {
+		if _, err = io.ReadFull(sock, head[n:]); err != nil {
+			closeRawFds(fds)
+			return 0, nil, nil, err
+		}
+	}
+	if int(binary.BigEndian.Uint16(head[2:])) != len(fds) {
+		closeRawFds(fds)
+		return 0, nil, nil, errors.New("cree.server, upgrade frame fds mismatch")
+	}
+
+	//read body
+	body := make([]byte, binary.BigEndian.Uint32(head[4:]))
+	if _, err = io.ReadFull(sock, body); err != nil {
+		closeRawFds(fds)
+		return 0, nil, nil, err
+	}
+	for _, fd := range fds {
+		syscall.CloseOnExec(fd)
+	}
+	return head[0], fds, body, nil
+}
+
+//close received fds
+func closeRawFds(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}